@@ -3,11 +3,14 @@ package file_transfer
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/rs/zerolog/log"
@@ -27,8 +30,17 @@ func NewDockerService() (*DockerService, error) {
 	return &DockerService{client: cli}, nil
 }
 
-// RunImage 运行Docker镜像
-func (ds *DockerService) RunImage(name string, user string, hostname string, image string, workdir string, mounts []mount.Mount, mask bool, ReadonlyRootfs bool, networkdisabled bool, timeout int, networkhosted bool, env []string) (ok bool, id string) {
+// Ping 检查Docker守护进程是否可用
+func (ds *DockerService) Ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := ds.client.Ping(ctx)
+	return err
+}
+
+// RunImage 运行Docker镜像，entrypoint非空时覆盖镜像的入口点（docker不再合并镜像的CMD）
+func (ds *DockerService) RunImage(name string, user string, hostname string, image string, workdir string, mounts []mount.Mount, mask bool, ReadonlyRootfs bool, networkdisabled bool, timeout int, networkhosted bool, env []string, restrictedNetwork string, entrypoint []string, securityOpts []string) (ok bool, id string) {
 
 	var masked []string
 	if mask {
@@ -38,6 +50,16 @@ func (ds *DockerService) RunImage(name string, user string, hostname string, ima
 	network := ""
 	if networkhosted {
 		network = "host"
+	} else if restrictedNetwork != "" {
+		network = restrictedNetwork
+	}
+
+	// 移除异常退出时残留的同名容器，避免创建失败
+	err := ds.client.ContainerRemove(context.Background(), name, container.RemoveOptions{Force: true})
+	if err == nil {
+		log.Warn().Str("name", name).Msg("removed stale container with the same name")
+	} else if !client.IsErrNotFound(err) {
+		log.Err(err).Str("name", name).Msg("stale container remove error")
 	}
 
 	resp, err := ds.client.ContainerCreate(context.Background(), &container.Config{
@@ -48,12 +70,14 @@ func (ds *DockerService) RunImage(name string, user string, hostname string, ima
 		NetworkDisabled: networkdisabled,
 		Env:             env,
 		StopTimeout:     &timeout,
+		Entrypoint:      entrypoint,
 	}, &container.HostConfig{
 		MaskedPaths:    masked,
 		Mounts:         mounts,
 		ReadonlyRootfs: ReadonlyRootfs,
 		AutoRemove:     true,
 		NetworkMode:    container.NetworkMode(network),
+		SecurityOpt:    securityOpts,
 
 		Resources: container.Resources{Ulimits: []*container.Ulimit{
 			{Name: "memlock", Soft: -1, Hard: -1},
@@ -81,6 +105,67 @@ func (ds *DockerService) RunImage(name string, user string, hostname string, ima
 	return true, id
 }
 
+// CreateRestrictedNetwork 创建内部网络并接入hosts中的容器，网络上的容器只能访问这些容器，不能访问外部网络
+// 同名网络已存在时必须是内部网络，否则返回错误
+func (ds *DockerService) CreateRestrictedNetwork(name string, hosts []string) error {
+	info, err := ds.client.NetworkInspect(context.Background(), name, network.InspectOptions{})
+	if err == nil {
+		if !info.Internal {
+			return errors.New("network " + name + " exists and is not internal")
+		}
+	} else if client.IsErrNotFound(err) {
+		_, err = ds.client.NetworkCreate(context.Background(), name, network.CreateOptions{
+			Driver:   "bridge",
+			Internal: true,
+		})
+		if err != nil {
+			return err
+		}
+		log.Debug().Str("network", name).Msg("created restricted network")
+	} else {
+		return err
+	}
+
+	// 重试时网络已存在，跳过已接入的容器
+	connected := make(map[string]bool)
+	for _, ep := range info.Containers {
+		connected[ep.Name] = true
+	}
+	for _, host := range hosts {
+		if connected[host] {
+			continue
+		}
+		err = ds.client.NetworkConnect(context.Background(), name, host, nil)
+		if err != nil {
+			return fmt.Errorf("failed to connect allowed host %s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// RemoveNetwork 断开网络上的所有容器并删除网络
+func (ds *DockerService) RemoveNetwork(name string) {
+	info, err := ds.client.NetworkInspect(context.Background(), name, network.InspectOptions{})
+	if err != nil {
+		if !client.IsErrNotFound(err) {
+			log.Err(err).Str("network", name).Msg("network inspect error")
+		}
+		return
+	}
+	for id := range info.Containers {
+		err = ds.client.NetworkDisconnect(context.Background(), name, id, true)
+		if err != nil && !client.IsErrNotFound(err) {
+			log.Err(err).Str("network", name).Str("id", id).Msg("network disconnect error")
+		}
+	}
+	err = ds.client.NetworkRemove(context.Background(), name)
+	if err != nil {
+		log.Err(err).Str("network", name).Msg("network remove error")
+		return
+	}
+	log.Debug().Str("network", name).Msg("removed restricted network")
+}
+
 // CleanContainer 清理容器
 func (ds *DockerService) CleanContainer(id string) {
 	var timeout = 1
@@ -0,0 +1,67 @@
+package file_transfer
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// quotaSlack 达到配额后仍允许通过的上传字节数，保证列目录、删除文件等协议消息不被拒绝
+const quotaSlack = 64 << 10
+
+// ErrQuotaExceeded 上传超过用户存储配额
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// DirUsage 计算目录中所有普通文件的总大小
+func DirUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// quotaWriter 转发上传数据，累计字节数超过剩余配额时重新统计目录占用，仍超出则拒绝写入
+// 上传的协议字节数不小于写入文件的字节数，因此目录占用最多超出配额quotaSlack
+type quotaWriter struct {
+	w         io.Writer
+	dir       string
+	quota     int64
+	remaining int64
+}
+
+// newQuotaWriter 创建配额限制的writer
+func newQuotaWriter(w io.Writer, dir string, quota int64) (*quotaWriter, error) {
+	usage, err := DirUsage(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaWriter{w: w, dir: dir, quota: quota, remaining: quota - usage + quotaSlack}, nil
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > qw.remaining {
+		usage, err := DirUsage(qw.dir)
+		if err != nil {
+			return 0, err
+		}
+		qw.remaining = qw.quota - usage + quotaSlack
+		if int64(len(p)) > qw.remaining {
+			return 0, ErrQuotaExceeded
+		}
+	}
+	qw.remaining -= int64(len(p))
+	return qw.w.Write(p)
+}
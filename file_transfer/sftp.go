@@ -1,6 +1,7 @@
 package file_transfer
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -34,7 +35,7 @@ func SftpHandler(sess ssh.Session, cfg *types.Config, dockerService *DockerServi
 			Source: path,
 			Target: "/work",
 		},
-	}, true, true, false, 120, false, nil)
+	}, true, true, false, 120, false, nil, "", nil, nil)
 
 	if !success {
 		log.Println(name, "failed to run sftp container")
@@ -59,8 +60,23 @@ func SftpHandler(sess ssh.Session, cfg *types.Config, dockerService *DockerServi
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 
+	var upstream io.Writer = conn
+	if cfg.UserQuotaBytes > 0 {
+		qw, err := newQuotaWriter(conn, path, cfg.UserQuotaBytes)
+		if err != nil {
+			log.Println(name, "failed to calculate storage usage", path, err)
+			conn.Close()
+			return
+		}
+		upstream = qw
+	}
+
 	go func() {
-		io.Copy(conn, sess)
+		_, err := io.Copy(upstream, sess)
+		if errors.Is(err, ErrQuotaExceeded) {
+			log.Println(name, "storage quota exceeded, closing session", id)
+			io.WriteString(sess.Stderr(), "soj: storage quota exceeded, upload refused\n")
+		}
 		conn.CloseWrite()
 		// log.Println(name, "session up closed", id)
 		wg.Done()
@@ -0,0 +1,148 @@
+package judge
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// maxArchiveSize 压缩包解压后的最大总字节数
+const maxArchiveSize = 256 << 20
+
+// submitArchive 将用户上传的 <submit_path>.tar.gz 解压到评测环境的 <submit_path> 目录
+// 解压出的文件与目录提交一样计入fileCount，超过maxFiles（大于0时）返回errTooManyFiles
+func (e *Evaluator) submitArchive(ctx *types.SubmitCtx, submits_dir string, submit_path string, maxFiles int, fileCount *int) error {
+	f, err := openSubmitted(ctx.SubmitDir, submit_path+".tar.gz")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrap(err, "failed to open gzip stream")
+	}
+	defer gz.Close()
+
+	err = e.mkdirOwned(submits_dir, submit_path)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "." {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return errors.New("archive entry " + hdr.Name + " escapes the submit directory")
+		}
+
+		rel := path.Join(submit_path, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdirOwned(submits_dir, rel)
+			if err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			*fileCount++
+			if maxFiles > 0 && *fileCount > maxFiles {
+				return errTooManyFiles
+			}
+
+			total += hdr.Size
+			if total > maxArchiveSize {
+				return errors.New("archive is too large")
+			}
+
+			err = e.mkdirOwned(submits_dir, path.Dir(rel))
+			if err != nil {
+				return err
+			}
+
+			var dst = path.Join(submits_dir, rel)
+			hash, err := writeFileHashed(io.LimitReader(tr, hdr.Size), dst)
+			if err != nil {
+				return err
+			}
+
+			os.Chown(dst, e.cfg.SubmitUid, e.cfg.SubmitGid)
+			os.Chmod(dst, e.fileMode)
+
+			log.Debug().Timestamp().Str("id", ctx.ID).Str("submit_file", rel).Str("hash", hash.MD5).Str("sha256", hash.SHA256).Msg("extracted submit file")
+
+			ctx.SubmitsHashes = append(ctx.SubmitsHashes, types.SubmitHash{
+				Hash:   hash.MD5,
+				SHA256: hash.SHA256,
+				Path:   rel,
+			})
+
+			ctx.Userface.Println("	*", aurora.Yellow(rel), ":", aurora.Blue(hash.SHA256))
+		default:
+			return errors.New("archive entry " + hdr.Name + " is not a regular file or directory")
+		}
+	}
+
+	return nil
+}
+
+// mkdirOwned 在base下逐级创建rel目录，并将新目录交给提交用户
+func (e *Evaluator) mkdirOwned(base string, rel string) error {
+	var cur = base
+	for _, part := range strings.Split(path.Clean(rel), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		cur = path.Join(cur, part)
+		err := os.Mkdir(cur, e.dirMode)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		os.Chown(cur, e.cfg.SubmitUid, e.cfg.SubmitGid)
+		os.Chmod(cur, e.dirMode)
+	}
+	return nil
+}
+
+// writeFileHashed 将r写入dst并返回哈希
+func writeFileHashed(r io.Reader, dst string) (fileHashes, error) {
+	destinationFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fileHashes{}, err
+	}
+	defer destinationFile.Close()
+
+	hashes, err := copyHashed(destinationFile, r)
+	if err != nil {
+		return fileHashes{}, err
+	}
+
+	if err := destinationFile.Sync(); err != nil {
+		return fileHashes{}, err
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,26 @@
+package judge
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// reservedEnvPrefix 内置环境变量的前缀，问题和工作流的自定义变量不能使用
+const reservedEnvPrefix = "SOJ_"
+
+// appendEnv 将问题和工作流的自定义环境变量追加到内置变量之后
+// 优先级: 内置 SOJ_* 变量 > 工作流 Env > 问题 Env
+func appendEnv(envs []string, problemEnv, workflowEnv map[string]string) []string {
+	merged := make(map[string]string, len(problemEnv)+len(workflowEnv))
+	maps.Copy(merged, problemEnv)
+	maps.Copy(merged, workflowEnv)
+
+	for _, k := range slices.Sorted(maps.Keys(merged)) {
+		if strings.HasPrefix(k, reservedEnvPrefix) {
+			continue
+		}
+		envs = append(envs, k+"="+merged[k])
+	}
+	return envs
+}
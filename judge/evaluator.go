@@ -1,17 +1,21 @@
 package judge
 
 import (
-	"crypto/md5"
-	"encoding/hex"
-	"encoding/json"
+	"bytes"
 	"io"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"github.com/mrhaoxx/SOJ/file_transfer"
 	"github.com/mrhaoxx/SOJ/types"
 	"github.com/pkg/errors"
 
@@ -21,57 +25,135 @@ import (
 	"github.com/docker/docker/api/types/mount"
 )
 
+// errTooManyFiles 目录提交的文件数超过限制
+var errTooManyFiles = errors.New("too many files in submission")
+
+// errJudgeInfra 评测容器无法启动，与提交本身无关，此时工作流的步骤尚未运行
+var errJudgeInfra = errors.New("judge infrastructure error")
+
 // Evaluator 评测器
 type Evaluator struct {
 	cfg       *types.Config
 	docker    DockerInterface
 	dbService *types.DatabaseService
+	queue     *Queue
+
+	// 提交文件和目录的权限
+	fileMode os.FileMode
+	dirMode  os.FileMode
+
+	// 评测容器的安全选项
+	securityOpts []string
+
+	// 正在进行的评测，供看门狗使用
+	activeMu sync.Mutex
+	active   map[string]*activeJudge
 }
 
 // DockerInterface Docker接口
 type DockerInterface interface {
-	RunImage(name string, user string, hostname string, image string, workdir string, mounts []mount.Mount, mask bool, ReadonlyRootfs bool, networkdisabled bool, timeout int, networkhosted bool, env []string) (ok bool, id string)
+	RunImage(name string, user string, hostname string, image string, workdir string, mounts []mount.Mount, mask bool, ReadonlyRootfs bool, networkdisabled bool, timeout int, networkhosted bool, env []string, restrictedNetwork string, entrypoint []string, securityOpts []string) (ok bool, id string)
 	CleanContainer(id string)
+	CreateRestrictedNetwork(name string, hosts []string) error
+	RemoveNetwork(name string)
 	ExecContainer(id string, cmd string, timeout int, stdout, stderr io.Writer, env []string, privileged bool) (int, string, error)
 	GetContainerLogs(id string) (string, error)
 }
 
 // NewEvaluator 创建新的评测器
 func NewEvaluator(cfg *types.Config, docker DockerInterface, dbService *types.DatabaseService) *Evaluator {
+	workers := cfg.JudgeWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	fileMode, dirMode, err := cfg.SubmitModes()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid submit file modes, using defaults")
+		fileMode, dirMode = 0400, 0700
+	}
+	securityOpts, err := cfg.SecurityOpts()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid container security options, using docker defaults")
+	}
 	return &Evaluator{
 		cfg:       cfg,
 		docker:    docker,
 		dbService: dbService,
+		queue:     NewQueue(workers, cfg.MaxPerUserConcurrent, dbService.IsAdmin),
+		fileMode:  fileMode,
+		dirMode:   dirMode,
+		active:    make(map[string]*activeJudge),
+
+		securityOpts: securityOpts,
 	}
 }
 
+// Queue 获取评测队列
+func (e *Evaluator) Queue() *Queue {
+	return e.queue
+}
+
 // RunJudge 运行评测
 func (e *Evaluator) RunJudge(ctx *types.SubmitCtx, problem *types.Problem) {
+	// 首先设置为pending状态，等待资源准备，首次写入时分配用户序号
+	e.markPending(ctx)
+	e.runJudge(ctx, problem)
+}
+
+// runJudge 运行已标记为pending的提交的评测
+func (e *Evaluator) runJudge(ctx *types.SubmitCtx, problem *types.Problem) {
 	log.Debug().Timestamp().Str("id", ctx.ID).Str("user", ctx.User).Str("problem", ctx.Problem).Msg("run judge")
 
-	// var start_time = time.Now()
+	var start_time = time.Now()
 	var err error
 
+	// 排队（含pending）阶段从创建评测开始计时
+	timer := newPhaseTimer(start_time, "queue")
+
+	// 试评测不在数据库中，看门狗无法发现，因此不登记
+	aj := &activeJudge{}
+	if !ctx.DryRun {
+		aj = e.track(ctx.ID)
+		defer e.untrack(ctx.ID)
+	}
+
 	defer func() {
+		checkStalled(aj, ctx)
 		log.Debug().Timestamp().Str("id", ctx.ID).Str("status", ctx.Status).Str("judgemsg", ctx.Msg).AnErr("err", err).Msg("judge finished")
-		ctx.Userface.Println(types.GetTime(time.Now()), "Submission", types.ColorizeStatus(ctx.Status))
+		ctx.Userface.Println(types.GetTime(time.Now()), "Submission", types.ColorizeStatus(ctx.Status), elapsed(timer.enter("")))
+		ctx.Userface.Println(aurora.Gray(15, "Timing: "+timer.summary()))
 		close(ctx.Running)
-		e.dbService.UpdateSubmit(ctx)
+		e.update(ctx)
+		if !ctx.DryRun {
+			e.notify(ctx)
+		}
 	}()
 
-	ctx.Userface.Println("Submission ID:", aurora.Magenta(ctx.ID))
-
-	// 首先设置为pending状态，等待资源准备
-	ctx.SetStatus("pending").SetMsg("submission is pending, waiting for judge resources")
-	e.dbService.UpdateSubmit(ctx)
+	if ctx.UserSeq > 0 {
+		ctx.Userface.Println("Submission ID:", aurora.Magenta(ctx.ID), aurora.Gray(15, "(#"+strconv.Itoa(ctx.UserSeq)+")"))
+	} else {
+		ctx.Userface.Println("Submission ID:", aurora.Magenta(ctx.ID))
+	}
 	ctx.Userface.Println(types.GetTime(time.Now()), "Submission", types.ColorizeStatus(ctx.Status))
 
+	// 等待评测槽位，排队位置变化时通知用户
+	e.queue.Acquire(ctx, func(position int) {
+		ctx.SetStatus("queued-" + strconv.Itoa(position)).SetMsg("waiting in queue, position " + strconv.Itoa(position))
+		e.update(ctx)
+		ctx.Userface.Println(types.GetTime(time.Now()), "Waiting in queue, position", aurora.Yellow(position))
+	})
+	defer e.queue.Release(ctx)
+
+	ctx.Userface.Println(types.GetTime(time.Now()), "Submission", aurora.Green("running"), elapsed(timer.enter("prep_dirs")))
+
 	// 开始准备评测环境
 	ctx.SetStatus("prep_dirs").SetMsg("preparing working directories")
-	e.dbService.UpdateSubmit(ctx)
+	e.update(ctx)
 
 	var submits_dir = path.Join(ctx.Workdir, "submits")
 	var workflow_dir = path.Join(ctx.Workdir, "work")
+	// result_dir 属于root且不会交给SubmitUid，提交的程序无法写入
+	var result_dir = path.Join(ctx.Workdir, "result")
 
 	var rsubmits_dir = path.Join(ctx.RealWorkdir, "submits")
 	var rworkflow_dir = path.Join(ctx.RealWorkdir, "work")
@@ -80,7 +162,11 @@ func (e *Evaluator) RunJudge(ctx *types.SubmitCtx, problem *types.Problem) {
 	if err != nil {
 		goto workdir_creation_failed
 	}
-	err = os.Mkdir(submits_dir, 0700)
+	err = os.Mkdir(submits_dir, e.dirMode)
+	if err != nil {
+		goto workdir_creation_failed
+	}
+	err = os.Chmod(submits_dir, e.dirMode)
 	if err != nil {
 		goto workdir_creation_failed
 	}
@@ -88,6 +174,12 @@ func (e *Evaluator) RunJudge(ctx *types.SubmitCtx, problem *types.Problem) {
 	if err != nil {
 		goto workdir_creation_failed
 	}
+	if protectedResult(problem) {
+		err = os.Mkdir(result_dir, 0700)
+		if err != nil {
+			goto workdir_creation_failed
+		}
+	}
 	err = os.Chown(ctx.Workdir, e.cfg.SubmitUid, e.cfg.SubmitGid)
 	if err != nil {
 		goto workdir_creation_failed
@@ -121,23 +213,69 @@ func (e *Evaluator) RunJudge(ctx *types.SubmitCtx, problem *types.Problem) {
 
 workdir_creation_failed:
 	ctx.SetStatus("failed").SetMsg("failed to create submit workdir")
-	e.dbService.UpdateSubmit(ctx)
+	e.update(ctx)
 	return
 
 workdir_created:
 	log.Debug().Timestamp().Str("id", ctx.ID).Str("submit_workdir", ctx.Workdir).Msg("created working dirs")
 
-	ctx.Userface.Println(types.GetTime(time.Now()), "Submitting files")
+	ctx.Userface.Println(types.GetTime(time.Now()), "Submitting files", elapsed(timer.enter("prep_files")))
 
 	ctx.SetStatus("prep_files").SetMsg("preparing files")
-	e.dbService.UpdateSubmit(ctx)
+	e.update(ctx)
+
+	maxFiles := problem.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = e.cfg.MaxSubmitFiles
+	}
+	var fileCount int
 
 	for _, submit := range problem.Submits {
-		if !submit.IsDir {
+		if submit.Optional {
+			var src = submit.Path
+			if !ctx.StoredFiles {
+				src = submit.SourcePath()
+			}
+			if _, err := os.Lstat(path.Join(ctx.SubmitDir, src)); os.IsNotExist(err) {
+				log.Debug().Timestamp().Str("id", ctx.ID).Str("submit_path", src).Msg("skipped missing optional submit file")
+				ctx.Userface.Println("	*", aurora.Yellow(src), ":", aurora.Gray(15, "skipped (optional)"))
+				continue
+			}
+		}
+
+		// 保存的提交文件已通过检查，且压缩包已解压，不再检查大小
+		if submit.MaxFileSize > 0 && !ctx.StoredFiles {
+			size, err := submitSize(path.Join(ctx.SubmitDir, submit.SourcePath()), submit.IsDir && !submit.Archive)
+			if err == nil && size > submit.MaxFileSize {
+				log.Info().Timestamp().Str("id", ctx.ID).Str("submit_path", submit.SourcePath()).Int64("size", size).Int64("max_size", submit.MaxFileSize).Msg("submit file too large")
+				ctx.SetStatus("failed").SetMsg("submit " + strconv.Quote(submit.SourcePath()) + " exceeds the size limit of " + strconv.FormatInt(submit.MaxFileSize, 10) + " bytes")
+				e.update(ctx)
+				ctx.Userface.Println("	*", aurora.Yellow(submit.SourcePath()), ":", aurora.Red("too large"))
+				return
+			}
+		}
+
+		if submit.Archive && !ctx.StoredFiles {
+			err = e.submitArchive(ctx, submits_dir, submit.Path, maxFiles, &fileCount)
+			if errors.Is(err, errTooManyFiles) {
+				log.Info().Timestamp().Str("id", ctx.ID).Str("submit_path", submit.Path).Int("max_files", maxFiles).Msg("too many files in submission")
+				ctx.SetStatus("failed").SetMsg("too many files in submission (max " + strconv.Itoa(maxFiles) + ")")
+				e.update(ctx)
+				ctx.Userface.Println("	*", aurora.Yellow(submit.Path+".tar.gz"), ":", aurora.Red("too many files"))
+				return
+			}
+			if err != nil {
+				log.Info().Timestamp().Str("id", ctx.ID).Str("submit_path", submit.Path).AnErr("err", err).Msg("failed to extract submit archive")
+				ctx.SetStatus("failed").SetMsg("failed to extract submit archive " + strconv.Quote(submit.Path+".tar.gz"))
+				e.update(ctx)
+				ctx.Userface.Println("	*", aurora.Yellow(submit.Path+".tar.gz"), ":", aurora.Red("failed"))
+				return
+			}
+		} else if !submit.IsDir && !submit.Archive {
 			err = e.submitFile(ctx, submits_dir, submit.Path)
 			if err != nil {
 				ctx.SetStatus("failed").SetMsg("failed to copy submit file " + strconv.Quote(submit.Path))
-				e.dbService.UpdateSubmit(ctx)
+				e.update(ctx)
 				ctx.Userface.Println("	*", aurora.Yellow(submit.Path), ":", aurora.Red("failed"))
 				return
 			}
@@ -148,16 +286,28 @@ workdir_created:
 					return errors.Wrap(err, "failed to execute filepath.WalkDir")
 				}
 				if !info.IsDir() {
-					if filepath.IsAbs(path) {
-						path, _ = filepath.Rel(dir_path, path)
+					fileCount++
+					if maxFiles > 0 && fileCount > maxFiles {
+						return errTooManyFiles
 					}
-					return e.submitFile(ctx, submits_dir, submit.Path+"/"+path)
+					rel, err := filepath.Rel(dir_path, path)
+					if err != nil {
+						return err
+					}
+					return e.submitFile(ctx, submits_dir, submit.Path+"/"+rel)
 				}
 				return nil
 			})
+			if errors.Is(err, errTooManyFiles) {
+				log.Info().Timestamp().Str("id", ctx.ID).Str("submit_path", submit.Path).Int("max_files", maxFiles).Msg("too many files in submission")
+				ctx.SetStatus("failed").SetMsg("too many files in submission (max " + strconv.Itoa(maxFiles) + ")")
+				e.update(ctx)
+				ctx.Userface.Println("	*", aurora.Yellow(submit.Path), ":", aurora.Red("too many files"))
+				return
+			}
 			if err != nil {
 				ctx.SetStatus("failed").SetMsg("failed to copy submit directory " + strconv.Quote(submit.Path))
-				e.dbService.UpdateSubmit(ctx)
+				e.update(ctx)
 				ctx.Userface.Println("	*", aurora.Yellow(submit.Path), ":", aurora.Red("failed"))
 				return
 			}
@@ -166,220 +316,744 @@ workdir_created:
 
 	log.Debug().Timestamp().Str("id", ctx.ID).Msg("copied submit files")
 
-	ctx.Userface.Println(types.GetTime(time.Now()), "Running Judge workflows")
+	if err = checkManifest(problem.Manifest, ctx.SubmitsHashes); err != nil {
+		log.Info().Timestamp().Str("id", ctx.ID).AnErr("err", err).Msg("submit files do not match manifest")
+		ctx.SetStatus("failed").SetMsg(err.Error())
+		e.update(ctx)
+		ctx.Userface.Println(aurora.Red("error:"), err.Error())
+		return
+	}
+
+	if e.reuseDuplicate(ctx) {
+		return
+	}
+
+	ctx.Userface.Println(types.GetTime(time.Now()), "Running Judge workflows", elapsed(timer.enter("")))
 
 	ctx.SetStatus("run_workflow").SetMsg("running judge workflows")
-	e.dbService.UpdateSubmit(ctx)
+	e.update(ctx)
+
+	// 测试点汇总的子任务结果，设置后代替结果文件
+	var caseSubtasks []types.SubtaskResult
+
+	// 仍在运行的工作流容器和受限网络，评测结束时清理
+	var containers []string
+	var networks []string
+	defer func() {
+		for _, cid := range containers {
+			e.docker.CleanContainer(cid)
+		}
+		for _, network := range networks {
+			e.docker.RemoveNetwork(network)
+		}
+	}()
 
 	for idx, workflow := range problem.Workflow {
-		var _mount = []mount.Mount{
-			{
-				Type:     mount.TypeBind,
-				Source:   submits_dir,
-				Target:   "/submits",
-				ReadOnly: true,
-			},
-			{
-				Type:   mount.TypeBind,
-				Source: workflow_dir,
-				Target: "/work",
-			},
+		timer.enter("workflow " + strconv.Itoa(idx+1))
+
+		// 评测工作流运行前停止之前的容器，提交的程序不能在评测期间继续修改工作目录
+		if workflow.Grader {
+			for _, cid := range containers {
+				e.docker.CleanContainer(cid)
+			}
+			containers = nil
 		}
 
-		var envs = []string{
-			"SOJ_SUBMITS_DIR=/submits",
-			"SOJ_WORK_DIR=/work",
-			"SOJ_REAL_WORKDIR=" + rworkflow_dir,
-			"SOJ_REAL_SUBMITDIR=" + rsubmits_dir,
-			"SOJ_PROBLEM=" + ctx.Problem,
-			"SOJ_SUBMIT=" + ctx.ID,
-			"SOJ_WORK_UID=" + strconv.Itoa(e.cfg.SubmitUid),
-			"SOJ_WORK_GID=" + strconv.Itoa(e.cfg.SubmitGid),
+		if network := e.workflowNetwork(ctx, idx, &workflow); network != "" {
+			err = e.docker.CreateRestrictedNetwork(network, workflow.AllowedHosts)
+			networks = append(networks, network)
+			if err != nil {
+				log.Error().Timestamp().Str("id", ctx.ID).Str("network", network).AnErr("err", err).Msg("failed to create restricted network")
+				ctx.SetStatus("failed").SetMsg("failed to create restricted network")
+				e.update(ctx)
+				return
+			}
 		}
 
-		for _, mnt := range workflow.Mounts {
-			_mount = append(_mount, mount.Mount{
-				Type:     mount.Type(mnt.Type),
-				Source:   mnt.Source,
-				Target:   mnt.Target,
-				ReadOnly: mnt.ReadOnly,
-			})
+		subtasks, cid, err := e.retryWorkflow(ctx, aj, problem, idx, &workflow, submits_dir, workflow_dir, result_dir, rsubmits_dir, rworkflow_dir)
+		if cid != "" {
+			containers = append(containers, cid)
 		}
+		if err != nil {
+			return
+		}
+		if workflow.TestCases != nil {
+			caseSubtasks = subtasks
+		}
+
+		ctx.Userface.Println(types.GetTime(time.Now()), "workflow", strconv.Itoa(idx+1), "finished", elapsed(timer.enter("")))
+	}
 
-		ctx.SetStatus("run_workflow-" + strconv.Itoa(idx))
-		e.dbService.UpdateSubmit(ctx)
-		ctx.Userface.Println(types.GetTime(time.Now()), "running", "workflow", strconv.Itoa(idx+1), "/", len(problem.Workflow))
+	timer.enter("collect_result")
+	ctx.SetStatus("collect_result")
+	e.update(ctx)
 
-		stepshows := map[int]struct{}{}
-		stepprivillege := map[int]struct{}{}
+	if caseSubtasks != nil {
+		ctx.JudgeResult = testCasesResult(caseSubtasks)
+		e.adjustScore(ctx, problem)
+		e.complete(ctx)
+		return
+	}
 
-		for _, step := range workflow.Show {
-			stepshows[step] = struct{}{}
-		}
-		for _, step := range workflow.PrivilegedSteps {
-			stepprivillege[step] = struct{}{}
+	var result_format = resultFormat(problem)
+	var result_file = workflow_dir + "/" + resultFiles[result_format]
+	if protectedResult(problem) {
+		result_file = result_dir + "/" + resultFiles[result_format]
+	}
+
+	if !e.loadResult(ctx, result_format, result_file) {
+		return
+	}
+
+	e.adjustScore(ctx, problem)
+	e.complete(ctx)
+}
+
+// loadResult 读取并校验评测程序写入的结果文件，失败时将提交标记为失败并返回false
+func (e *Evaluator) loadResult(ctx *types.SubmitCtx, format string, result_file string) bool {
+	_result, err := os.ReadFile(result_file)
+
+	if err != nil {
+		log.Info().Timestamp().Str("id", ctx.ID).Str("result_file", result_file).AnErr("err", err).Msg("failed to read result file")
+		ctx.SetStatus("failed").SetMsg("failed to read result file")
+		e.update(ctx)
+		return false
+	}
+
+	ctx.JudgeResult, err = parseResult(format, _result)
+	if err == nil {
+		err = checkFiniteScores(&ctx.JudgeResult)
+	}
+	if errors.Is(err, errNonFiniteScore) {
+		log.Warn().Timestamp().Str("id", ctx.ID).Str("result_file", result_file).AnErr("err", err).Msg("invalid score from grader")
+		ctx.JudgeResult = types.JudgeResult{}
+		ctx.SetStatus("failed").SetMsg("invalid score from grader")
+		e.update(ctx)
+		return false
+	}
+	if err != nil {
+		log.Info().Timestamp().Str("id", ctx.ID).Str("result_file", result_file).AnErr("err", err).Msg("failed to parse result file")
+		ctx.SetStatus("failed").SetMsg("failed to parse result file")
+		e.update(ctx)
+		return false
+	}
+
+	return e.clampScore(ctx)
+}
+
+// clampScore 将评测程序给出的超出范围的分数截断，配置了RejectOutOfRangeScores时将提交标记为失败并返回false
+func (e *Evaluator) clampScore(ctx *types.SubmitCtx) bool {
+	lo, hi := e.cfg.ScoreRange()
+	orig, clamped := clampScore(&ctx.JudgeResult, lo, hi)
+	if !clamped {
+		return true
+	}
+
+	log.Warn().Timestamp().Str("id", ctx.ID).Str("problem", ctx.Problem).Float64("score", orig).Float64("min", lo).Float64("max", hi).Bool("reject", e.cfg.RejectOutOfRangeScores).Msg("score from grader out of range")
+	if e.cfg.RejectOutOfRangeScores {
+		ctx.JudgeResult.Score = orig
+		ctx.SetStatus("failed").SetMsg("score from grader out of range")
+		e.update(ctx)
+		return false
+	}
+	ctx.Userface.Println("Score clamped to valid range:", aurora.Gray(15, types.FormatScore(orig)), "->", aurora.Bold(types.FormatScore(ctx.JudgeResult.Score)))
+	return true
+}
+
+// adjustScore 应用问题的分数调整，调整时告知用户
+func (e *Evaluator) adjustScore(ctx *types.SubmitCtx, problem *types.Problem) {
+	base, adjusted := adjustScore(problem, &ctx.JudgeResult)
+	if !adjusted {
+		return
+	}
+	log.Debug().Timestamp().Str("id", ctx.ID).Float64("base_score", base).Float64("score", ctx.JudgeResult.Score).Msg("adjusted score for resource usage")
+	ctx.Userface.Println("Score adjusted for resource usage:", aurora.Gray(15, types.FormatScore(base)), "->", aurora.Bold(types.FormatScore(ctx.JudgeResult.Score)))
+}
+
+// containerSecurityOpts 工作流容器的安全选项，unconfined的工作流不使用seccomp和AppArmor配置
+func (e *Evaluator) containerSecurityOpts(workflow *types.Workflow) []string {
+	if !workflow.Unconfined {
+		return e.securityOpts
+	}
+	opts := []string{"seccomp=unconfined", "apparmor=unconfined"}
+	for _, opt := range e.securityOpts {
+		if opt == "no-new-privileges" {
+			opts = append(opts, opt)
 		}
+	}
+	return opts
+}
 
-		var usr = strconv.Itoa(e.cfg.SubmitUid)
-		if workflow.Root {
-			usr = "0"
+// retryWorkflow 运行评测工作流，评测容器无法启动时按workflow.Retries重试
+// 步骤开始运行后工作目录可能已被修改，此后的失败不再重试
+func (e *Evaluator) retryWorkflow(ctx *types.SubmitCtx, aj *activeJudge, problem *types.Problem, idx int, workflow *types.Workflow, submits_dir, workflow_dir, result_dir, rsubmits_dir, rworkflow_dir string) (subtasks []types.SubtaskResult, cid string, err error) {
+	var results = len(ctx.WorkflowResults)
+
+	for attempt := 0; ; attempt++ {
+		subtasks, cid, err = e.runWorkflow(ctx, aj, problem, idx, workflow, submits_dir, workflow_dir, result_dir, rsubmits_dir, rworkflow_dir)
+		if err == nil || !errors.Is(err, errJudgeInfra) || attempt >= workflow.Retries || aj.stalled.Load() {
+			return subtasks, cid, err
 		}
 
-		ok, cid := e.docker.RunImage("soj-judge-"+ctx.ID+"-"+strconv.Itoa(idx+1), usr, "soj-judgement", workflow.Image, "/work", _mount, false, false, workflow.DisableNetwork, workflow.Timeout, workflow.NetworkHostMode, envs)
+		log.Warn().Timestamp().Str("id", ctx.ID).Str("image", workflow.Image).Int("workflow", idx+1).Int("attempt", attempt+1).Int("retries", workflow.Retries).AnErr("err", err).Msg("retrying judge workflow after infrastructure error")
+		ctx.Userface.Println(types.GetTime(time.Now()), aurora.Yellow("retrying"), "workflow", strconv.Itoa(idx+1), aurora.Gray(15, "(attempt "+strconv.Itoa(attempt+2)+"/"+strconv.Itoa(workflow.Retries+1)+")"))
 
-		if !ok {
-			ctx.SetStatus("failed").SetMsg("failed to run judge container")
-			e.dbService.UpdateSubmit(ctx)
-			return
+		if cid != "" {
+			e.docker.CleanContainer(cid)
 		}
+		ctx.WorkflowResults = ctx.WorkflowResults[:results]
+	}
+}
 
-		defer e.docker.CleanContainer(cid)
+// runWorkflow 在新容器中运行一个评测工作流，返回测试点汇总结果和容器ID（由调用方清理）
+// 失败时已设置提交状态，错误为errJudgeInfra时表示评测容器无法启动，步骤尚未运行，可以重试
+func (e *Evaluator) runWorkflow(ctx *types.SubmitCtx, aj *activeJudge, problem *types.Problem, idx int, workflow *types.Workflow, submits_dir, workflow_dir, result_dir, rsubmits_dir, rworkflow_dir string) (subtasks []types.SubtaskResult, cid string, err error) {
+	submits_mount, work_mount, container_workdir := workflowPaths(workflow)
+
+	var _mount = []mount.Mount{
+		{
+			Type:     mount.TypeBind,
+			Source:   submits_dir,
+			Target:   submits_mount,
+			ReadOnly: true,
+		},
+		{
+			Type:     mount.TypeBind,
+			Source:   workflow_dir,
+			Target:   work_mount,
+			ReadOnly: workflow.Grader,
+		},
+	}
+
+	var envs = []string{
+		"SOJ_SUBMITS_DIR=" + submits_mount,
+		"SOJ_WORK_DIR=" + work_mount,
+		"SOJ_REAL_WORKDIR=" + rworkflow_dir,
+		"SOJ_REAL_SUBMITDIR=" + rsubmits_dir,
+		"SOJ_PROBLEM=" + ctx.Problem,
+		"SOJ_SUBMIT=" + ctx.ID,
+		"SOJ_WORK_UID=" + strconv.Itoa(e.cfg.SubmitUid),
+		"SOJ_WORK_GID=" + strconv.Itoa(e.cfg.SubmitGid),
+	}
+	if workflow.TestCases != nil {
+		_mount = append(_mount, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   workflow.TestCases.Dir,
+			Target:   testsDir,
+			ReadOnly: true,
+		})
+		envs = append(envs, "SOJ_TESTS_DIR="+testsDir)
+	}
+	// 受保护的结果目录只挂载到评测工作流或root工作流，其他工作流中提交的程序看不到也无法写入
+	if writesResult(problem, workflow) {
+		_mount = append(_mount, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: result_dir,
+			Target: resultDir,
+		})
+		envs = append(envs, "SOJ_RESULT_DIR="+resultDir)
+	}
+	envs = appendEnv(envs, problem.Env, workflow.Env)
+
+	for _, mnt := range workflow.Mounts {
+		_mount = append(_mount, mount.Mount{
+			Type:     mount.Type(mnt.Type),
+			Source:   mnt.Source,
+			Target:   mnt.Target,
+			ReadOnly: mnt.ReadOnly,
+		})
+	}
 
-		steps := make([]types.WorkflowStepResult, len(workflow.Steps))
+	ctx.SetStatus("run_workflow-" + strconv.Itoa(idx))
+	e.update(ctx)
+	ctx.Userface.Println(types.GetTime(time.Now()), "running", "workflow", strconv.Itoa(idx+1), "/", len(problem.Workflow))
 
-		for sidx, step := range workflow.Steps {
-			ctx.SetStatus("run_workflow-" + strconv.Itoa(idx) + "_" + strconv.Itoa(sidx))
-			e.dbService.UpdateSubmit(ctx)
+	stepshows := map[int]struct{}{}
+	stepprivillege := map[int]struct{}{}
+	stepcompile := map[int]struct{}{}
 
-			ctx.Userface.Println(types.GetTime(time.Now()), "running", "workflow", strconv.Itoa(idx+1), "step", strconv.Itoa(sidx+1), "/", len(workflow.Steps))
+	for _, step := range workflow.Show {
+		stepshows[step] = struct{}{}
+	}
+	for _, step := range workflow.PrivilegedSteps {
+		stepprivillege[step] = struct{}{}
+	}
+	for _, step := range workflow.CompileSteps {
+		stepcompile[step] = struct{}{}
+	}
+
+	var usr = strconv.Itoa(e.cfg.SubmitUid)
+	if workflow.Root || workflow.Grader {
+		usr = "0"
+	}
+
+	ok, cid := e.docker.RunImage(e.containerPrefix()+"-"+ctx.ID+"-"+strconv.Itoa(idx+1), usr, "soj-judgement", workflow.Image, container_workdir, _mount, false, false, workflow.DisableNetwork, workflow.Timeout, workflow.NetworkHostMode, envs, e.workflowNetwork(ctx, idx, workflow), workflow.Entrypoint, e.containerSecurityOpts(workflow))
+
+	if !ok {
+		ctx.SetStatus("failed").SetMsg("failed to run judge container")
+		e.update(ctx)
+		return nil, cid, errors.Wrap(errJudgeInfra, "failed to run judge container")
+	}
 
-			_, ok := stepshows[sidx+1]
-			_, priv := stepprivillege[sidx+1]
+	aj.setContainer(cid)
 
-			var rr io.Writer = nil
-			var re io.Writer = nil
-			if ok {
-				ctx.Userface.Println("	$", aurora.Yellow(step))
-				rr = &ColoredIO{ctx.Userface, aurora.BlueFg}
-				re = &ColoredIO{ctx.Userface, aurora.RedFg}
+	steps := make([]types.WorkflowStepResult, len(workflow.Steps))
+
+	for sidx, step := range workflow.Steps {
+		ctx.SetStatus("run_workflow-" + strconv.Itoa(idx) + "_" + strconv.Itoa(sidx))
+		e.update(ctx)
+
+		ctx.Userface.Println(types.GetTime(time.Now()), "running", "workflow", strconv.Itoa(idx+1), "step", strconv.Itoa(sidx+1), "/", len(workflow.Steps))
+
+		_, ok := stepshows[sidx+1]
+		_, priv := stepprivillege[sidx+1]
+		_, compile := stepcompile[sidx+1]
+
+		var rr io.Writer = nil
+		var re io.Writer = nil
+		var out, errout *ColoredIO
+		if ok {
+			ctx.Userface.Println("	$", aurora.Yellow(step))
+			out = &ColoredIO{Writer: ctx.Userface, Color: aurora.BlueFg}
+			errout = &ColoredIO{Writer: ctx.Userface, Color: aurora.RedFg}
+			if workflow.MergeOutput {
+				errout = out
+			}
+			rr, re = out, errout
+			if workflow.MaxShownBytes > 0 {
+				limit := &ShownLimit{Remaining: workflow.MaxShownBytes, Userface: ctx.Userface}
+				rr = &LimitedIO{rr, limit}
+				re = &LimitedIO{re, limit}
 			}
-			ec, logs, err := e.docker.ExecContainer(cid, step, workflow.Timeout, rr, re, envs, priv)
+		}
 
-			if ok {
-				ctx.Userface.Println(aurora.Gray(15, "exit code:"), aurora.Yellow(ec))
+		// 编译步骤保存stderr，失败时作为编译错误信息
+		var compileLog *cappedBuffer
+		if compile {
+			compileLog = &cappedBuffer{Max: compileLogLimit}
+			if re == nil {
+				rr, re = io.Discard, compileLog
+			} else {
+				re = io.MultiWriter(re, compileLog)
 			}
+		}
+		step_start := time.Now()
+		ec, logs, err := e.docker.ExecContainer(cid, step, workflow.Timeout, rr, re, envs, priv)
+		duration := time.Since(step_start)
 
-			if ec != 0 || err != nil {
-				ctx.SetStatus("failed").SetMsg("failed to run judge " + strconv.Itoa(idx+1) + " step " + strconv.Itoa(sidx+1))
-				e.dbService.UpdateSubmit(ctx)
+		if ok {
+			out.Flush()
+			errout.Flush()
+		}
 
-				log.Info().Timestamp().Str("id", ctx.ID).Str("image", workflow.Image).Str("step", step).Int("timeout", workflow.Timeout).AnErr("err", err).Str("logs", logs).Int("exitcode", ec).Msg("failed to run judge step")
-				return
-			}
+		if ok {
+			ctx.Userface.Println(aurora.Gray(15, "exit code:"), aurora.Yellow(ec))
+		}
 
-			steps[sidx] = types.WorkflowStepResult{
-				Logs:     logs,
+		steps[sidx] = types.WorkflowStepResult{
+			Logs:       logs,
+			ExitCode:   ec,
+			DurationNs: duration.Nanoseconds(),
+		}
+
+		if ec != 0 || err != nil {
+			ctx.WorkflowResults = append(ctx.WorkflowResults, types.WorkflowResult{
+				Success:  false,
 				ExitCode: ec,
+				Steps:    steps[:sidx+1],
+			})
+
+			// 编译步骤正常结束但退出码非零为编译错误，属于提交本身的问题
+			if compile && err == nil {
+				e.compileError(ctx, compileLog, !ok)
+				ctx.SetMsg("compilation error in judge " + strconv.Itoa(idx+1) + " step " + strconv.Itoa(sidx+1))
+				e.update(ctx)
+				log.Info().Timestamp().Str("id", ctx.ID).Str("image", workflow.Image).Str("step", step).Int("exitcode", ec).Dur("duration", duration).Msg("compilation error")
+				return nil, cid, errors.New("compilation error")
 			}
+			ctx.SetStatus("failed").SetMsg("failed to run judge " + strconv.Itoa(idx+1) + " step " + strconv.Itoa(sidx+1))
+			e.update(ctx)
+
+			log.Info().Timestamp().Str("id", ctx.ID).Str("image", workflow.Image).Str("step", step).Int("timeout", workflow.Timeout).AnErr("err", err).Str("logs", logs).Int("exitcode", ec).Dur("duration", duration).Msg("failed to run judge step")
 
-			e.dbService.UpdateSubmit(ctx)
-			log.Debug().Timestamp().Str("id", ctx.ID).Str("image", workflow.Image).Str("step", step).Int("timeout", workflow.Timeout).Str("logs", logs).Int("exitcode", ec).Msg("ran judge step")
+			// 步骤已经运行，工作目录可能已被修改，执行环境出错时也不重试
+			if err != nil {
+				return nil, cid, errors.Wrap(err, "judge step failed")
+			}
+			return nil, cid, errors.New("judge step failed")
 		}
 
-		logs, err := e.docker.GetContainerLogs(cid)
+		e.update(ctx)
+		log.Debug().Timestamp().Str("id", ctx.ID).Str("image", workflow.Image).Str("step", step).Int("timeout", workflow.Timeout).Str("logs", logs).Int("exitcode", ec).Dur("duration", duration).Msg("ran judge step")
+	}
+
+	if workflow.TestCases != nil {
+		ctx.SetStatus("run_workflow-" + strconv.Itoa(idx) + "_tests")
+		e.update(ctx)
+
+		subtasks, err = e.runTestCases(ctx, cid, workflow.TestCases, workflow.Timeout, envs)
+		if err != nil {
+			log.Info().Timestamp().Str("id", ctx.ID).Str("dir", workflow.TestCases.Dir).AnErr("err", err).Msg("failed to run test cases")
+			ctx.SetStatus("failed").SetMsg("failed to run test cases")
+			e.update(ctx)
+			return nil, cid, err
+		}
+	}
+
+	var logs string
+	if workflow.CaptureLogs() {
+		logs, err = e.docker.GetContainerLogs(cid)
 		if err != nil {
 			ctx.SetStatus("failed").SetMsg("failed to get judge logs")
-			e.dbService.UpdateSubmit(ctx)
-			return
+			e.update(ctx)
+			return nil, cid, errors.Wrap(err, "failed to get judge logs")
 		}
+	}
 
-		ctx.WorkflowResults = append(ctx.WorkflowResults, types.WorkflowResult{
-			Success: true,
-			Logs:    logs,
-			Steps:   steps,
-		})
+	ctx.WorkflowResults = append(ctx.WorkflowResults, types.WorkflowResult{
+		Success: true,
+		Logs:    logs,
+		Steps:   steps,
+	})
+
+	log.Debug().Timestamp().Any("mnt", _mount).Str("id", ctx.ID).Str("image", workflow.Image).Str("logs", logs).Msg("got judge logs")
+
+	return subtasks, cid, nil
+}
 
-		log.Debug().Timestamp().Any("mnt", _mount).Str("id", ctx.ID).Str("image", workflow.Image).Str("logs", logs).Msg("got judge logs")
+// compileError 以编译错误结束评测，编译输出作为评测信息，show为true时同时向用户显示
+func (e *Evaluator) compileError(ctx *types.SubmitCtx, compileLog *cappedBuffer, show bool) {
+	ctx.JudgeResult = types.JudgeResult{
+		Verdict: types.VerdictCompileError,
+		Msg:     compileLog.String(),
 	}
+	ctx.SetStatus("rejected")
 
-	ctx.SetStatus("collect_result")
-	e.dbService.UpdateSubmit(ctx)
+	ctx.Userface.Println(aurora.Red("Compilation Error"))
+	if show && compileLog.Len() > 0 {
+		out := &ColoredIO{Writer: ctx.Userface, Color: aurora.RedFg}
+		out.Write(compileLog.Bytes())
+		out.Flush()
+	}
+	if compileLog.Truncated {
+		ctx.Userface.Println(aurora.Gray(15, "... compiler output truncated"))
+	}
+}
 
-	var result_file = workflow_dir + "/result.json"
+// complete 根据评测结果结束评测，结果为失败时状态为rejected以区别于评测系统出错的failed
+func (e *Evaluator) complete(ctx *types.SubmitCtx) {
+	if ctx.JudgeResult.Success {
+		ctx.SetStatus("completed")
+	} else {
+		ctx.SetStatus("rejected")
+	}
+	ctx.SetMsg("judge successfully finished")
+	e.update(ctx)
+}
 
-	_result, err := os.ReadFile(result_file)
+// reuseDuplicate 提示用户本次提交与之前的某次提交完全相同，
+// 开启SkipDuplicateSubmits且问题版本未变时直接沿用其评测结果并返回true
+func (e *Evaluator) reuseDuplicate(ctx *types.SubmitCtx) bool {
+	if ctx.DryRun {
+		return false
+	}
 
+	dup, err := e.dbService.FindIdenticalSubmit(ctx)
 	if err != nil {
-		log.Info().Timestamp().Str("id", ctx.ID).Str("result_file", result_file).AnErr("err", err).Msg("failed to read result file")
-		ctx.SetStatus("failed").SetMsg("failed to read result file")
-		e.dbService.UpdateSubmit(ctx)
-		return
+		log.Error().Timestamp().Str("id", ctx.ID).AnErr("err", err).Msg("failed to look up identical submits")
+		return false
+	}
+	if dup == nil {
+		return false
 	}
 
-	err = json.Unmarshal(_result, &ctx.JudgeResult)
-	if err != nil {
-		log.Info().Timestamp().Str("id", ctx.ID).Str("result_file", result_file).AnErr("err", err).Msg("failed to parse result file")
-		ctx.SetStatus("failed").SetMsg("failed to parse result file")
-		e.dbService.UpdateSubmit(ctx)
-		return
+	ctx.Userface.Println(aurora.Yellow("note:"), "files are identical to submission", aurora.Magenta(dup.ID), "which scored", types.ColorizeScore(dup.JudgeResult))
+
+	// resubmit是用户明确要求重新评测，不沿用结果
+	if !e.cfg.SkipDuplicateSubmits || ctx.StoredFiles || dup.ProblemVersion != ctx.ProblemVersion {
+		return false
 	}
 
-	ctx.SetStatus("completed").SetMsg("judge successfully finished")
+	log.Info().Timestamp().Str("id", ctx.ID).Str("duplicate_of", dup.ID).Msg("reusing result of identical submit")
+	ctx.Userface.Println(aurora.Yellow("note:"), "skipping judge and reusing its result")
+
+	ctx.JudgeResult = dup.JudgeResult
+	ctx.SetStatus(dup.Status).SetMsg("identical to submission " + dup.ID + ", judge skipped")
+	e.update(ctx)
+	return true
+}
+
+// markPending 将提交设置为pending状态并写入数据库
+func (e *Evaluator) markPending(ctx *types.SubmitCtx) {
+	ctx.SetStatus("pending").SetMsg("submission is pending, waiting for judge resources")
+	e.update(ctx)
+}
+
+// update 将提交状态写入数据库，试评测时跳过
+func (e *Evaluator) update(ctx *types.SubmitCtx) {
+	if ctx.DryRun {
+		return
+	}
 	e.dbService.UpdateSubmit(ctx)
 }
 
-// copyFile 复制文件并返回MD5哈希
-func (e *Evaluator) copyFile(src, dst string) (string, error) {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return "", err
+// containerPrefix 获取评测容器名前缀
+func (e *Evaluator) containerPrefix() string {
+	if e.cfg.ContainerPrefix == "" {
+		return "soj-judge"
 	}
-	defer sourceFile.Close()
+	return e.cfg.ContainerPrefix
+}
 
+// workflowNetwork 获取设置了allowedhosts的工作流使用的受限网络名称，每次评测的每个工作流使用单独的网络
+// 未设置allowedhosts时返回空字符串
+func (e *Evaluator) workflowNetwork(ctx *types.SubmitCtx, idx int, workflow *types.Workflow) string {
+	if len(workflow.AllowedHosts) == 0 || workflow.DisableNetwork || workflow.NetworkHostMode {
+		return ""
+	}
+	var prefix = e.cfg.RestrictedNetwork
+	if prefix == "" {
+		prefix = "soj-restricted"
+	}
+	return prefix + "-" + ctx.ID + "-" + strconv.Itoa(idx+1)
+}
+
+// copyFile 复制文件并返回哈希
+func (e *Evaluator) copyFile(src io.Reader, dst string) (fileHashes, error) {
 	destinationFile, err := os.Create(dst)
 	if err != nil {
-		return "", err
+		return fileHashes{}, err
 	}
 	defer destinationFile.Close()
 
-	hash := md5.New()
-	if _, err = io.Copy(destinationFile, io.TeeReader(sourceFile, hash)); err != nil {
-		return "", err
+	hashes, err := copyHashed(destinationFile, src)
+	if err != nil {
+		return fileHashes{}, err
 	}
 
 	if err := destinationFile.Sync(); err != nil {
-		return "", err
+		return fileHashes{}, err
 	}
 
-	md5String := hex.EncodeToString(hash.Sum(nil))
-	return md5String, nil
+	return hashes, nil
+}
+
+// submitSize 获取提交项的大小，目录为其中所有普通文件之和
+func submitSize(p string, isDir bool) (int64, error) {
+	if isDir {
+		return file_transfer.DirUsage(p)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
 }
 
 // submitFile 提交文件到评测环境
 func (e *Evaluator) submitFile(ctx *types.SubmitCtx, submits_dir string, submit_path string) error {
-	var src_submit_path = path.Join(ctx.SubmitDir, submit_path)
-	var dst_submit_path = path.Join(submits_dir, submit_path)
+	src, err := openSubmitted(ctx.SubmitDir, submit_path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
 
-	os.MkdirAll(path.Dir(dst_submit_path), 0700)
-	os.Chown(path.Dir(dst_submit_path), e.cfg.SubmitUid, e.cfg.SubmitGid)
+	dst_submit_path, err := resolveWithin(submits_dir, submit_path)
+	if err != nil {
+		return err
+	}
 
-	hash, err := e.copyFile(src_submit_path, dst_submit_path)
+	err = e.mkdirOwned(submits_dir, path.Dir(submit_path))
+	if err != nil {
+		return err
+	}
+
+	hash, err := e.copyFile(src, dst_submit_path)
 	if err != nil {
 		return err
 	} else {
 		os.Chown(dst_submit_path, e.cfg.SubmitUid, e.cfg.SubmitGid)
-		os.Chmod(dst_submit_path, 0400)
+		os.Chmod(dst_submit_path, e.fileMode)
 
-		log.Debug().Timestamp().Str("id", ctx.ID).Str("submit_file", submit_path).Str("hash", hash).Msg("copied submit file")
+		log.Debug().Timestamp().Str("id", ctx.ID).Str("submit_file", submit_path).Str("hash", hash.MD5).Str("sha256", hash.SHA256).Msg("copied submit file")
 
 		ctx.SubmitsHashes = append(ctx.SubmitsHashes, types.SubmitHash{
-			Hash: hash,
-			Path: submit_path,
+			Hash:   hash.MD5,
+			SHA256: hash.SHA256,
+			Path:   submit_path,
 		})
 
-		ctx.Userface.Println("	*", aurora.Yellow(submit_path), ":", aurora.Blue(hash))
+		ctx.Userface.Println("	*", aurora.Yellow(submit_path), ":", aurora.Blue(hash.SHA256))
 	}
 
 	return nil
 }
 
-// ColoredIO 彩色IO包装器
+// openSubmitted 打开用户提交目录中的普通文件
+// 提交目录中的文件可能是用户创建的符号链接，解析后仍需位于提交目录内；FIFO、设备等特殊文件被拒绝
+func openSubmitted(submit_dir string, submit_path string) (*os.File, error) {
+	src_submit_path, err := resolveWithin(submit_dir, submit_path)
+	if err != nil {
+		return nil, err
+	}
+
+	real_src, err := filepath.EvalSymlinks(src_submit_path)
+	if err != nil {
+		return nil, err
+	}
+	real_submit_dir, err := filepath.EvalSymlinks(submit_dir)
+	if err != nil {
+		return nil, err
+	}
+	if !isWithin(real_submit_dir, real_src) {
+		return nil, errors.New("submit file " + submit_path + " escapes the submit directory")
+	}
+
+	// 非阻塞打开，FIFO没有写端时也不会阻塞
+	f, err := os.OpenFile(real_src, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, errors.New("submit file " + submit_path + " is not a regular file")
+	}
+	return f, nil
+}
+
+// resolveWithin 拼接base与rel，拒绝清理后逃逸出base的路径
+func resolveWithin(base string, rel string) (string, error) {
+	var target = filepath.Join(base, rel)
+	if !isWithin(base, target) {
+		return "", errors.New("path " + strconv.Quote(rel) + " escapes " + strconv.Quote(base))
+	}
+	return target, nil
+}
+
+// isWithin 判断target是否位于base目录内
+func isWithin(base string, target string) bool {
+	rel, err := filepath.Rel(filepath.Clean(base), filepath.Clean(target))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, "../") && !filepath.IsAbs(rel)
+}
+
+// maxColoredLine 未遇到换行时最多缓冲的字节数，超过后在UTF-8字符边界处输出
+const maxColoredLine = 4096
+
+// ColoredIO 彩色IO包装器，按行缓冲输出，避免分块写入时颜色控制码插入到一行或一个UTF-8字符中间
+// 同一个ColoredIO可同时作为stdout和stderr使用，此时两个流合并为同一颜色
 type ColoredIO struct {
 	io.Writer
 	aurora.Color
+
+	mu  sync.Mutex
+	buf []byte
 }
 
 func (c *ColoredIO) Write(p []byte) (n int, err error) {
-	_, err = c.Writer.Write([]byte(aurora.Colorize(string(p), c.Color).String()))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf = append(c.buf, p...)
+	end := bytes.LastIndexByte(c.buf, '\n') + 1
+	if end == 0 && len(c.buf) >= maxColoredLine {
+		end = runeBoundary(c.buf)
+	}
+	if end > 0 {
+		err = c.emit(c.buf[:end])
+		c.buf = append(c.buf[:0], c.buf[end:]...)
+	}
+	return len(p), err
+}
+
+// Flush 输出缓冲中尚未换行的内容
+func (c *ColoredIO) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.emit(c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+func (c *ColoredIO) emit(p []byte) error {
+	_, err := c.Writer.Write([]byte(aurora.Colorize(string(p), c.Color).String()))
+	return err
+}
+
+// runeBoundary 返回b中最后一个完整UTF-8字符之后的位置
+func runeBoundary(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// ShownLimit 显示输出的剩余字节配额，stdout与stderr共享
+type ShownLimit struct {
+	Remaining int
+	Truncated bool
+	Userface  types.Userface
+}
+
+// LimitedIO 超过配额后截断输出的IO包装器
+type LimitedIO struct {
+	io.Writer
+	*ShownLimit
+}
+
+func (l *LimitedIO) Write(p []byte) (n int, err error) {
+	if l.Truncated {
+		return len(p), nil
+	}
+	if len(p) <= l.Remaining {
+		l.Remaining -= len(p)
+		return l.Writer.Write(p)
+	}
+	if cut := runeBoundary(p[:l.Remaining]); cut > 0 {
+		_, err = l.Writer.Write(p[:cut])
+	}
+	l.Remaining = 0
+	l.Truncated = true
+	if f, ok := l.Writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	l.Userface.Println()
+	l.Userface.Println(aurora.Gray(15, "... output truncated, remaining output is hidden"))
 	return len(p), err
 }
+
+// compileLogLimit 编译错误时保存的stderr最大字节数
+const compileLogLimit = 16 << 10
+
+// cappedBuffer 只保存前Max字节的缓冲区，超出部分丢弃
+type cappedBuffer struct {
+	bytes.Buffer
+	Max       int
+	Truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (n int, err error) {
+	remaining := c.Max - c.Len()
+	if len(p) > remaining {
+		c.Truncated = true
+		c.Buffer.Write(p[:runeBoundary(p[:max(remaining, 0)])])
+		return len(p), nil
+	}
+	return c.Buffer.Write(p)
+}
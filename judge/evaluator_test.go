@@ -0,0 +1,353 @@
+package judge
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/pkg/errors"
+)
+
+func TestResolveWithin(t *testing.T) {
+	base := "/srv/submits/user/p"
+	tests := []struct {
+		rel  string
+		want string
+		ok   bool
+	}{
+		{"main.c", base + "/main.c", true},
+		{"src/main.c", base + "/src/main.c", true},
+		{"src/../main.c", base + "/main.c", true},
+		{"..", "", false},
+		{"../other/main.c", "", false},
+		{"src/../../other", "", false},
+		{"../../../../etc/passwd", "", false},
+		// 绝对路径拼接到base下，而不是指向宿主机上的文件，但仍不能借助..逃逸
+		{"/etc/passwd", base + "/etc/passwd", true},
+		{"/../../etc/passwd", "", false},
+	}
+	for _, tt := range tests {
+		got, err := resolveWithin(base, tt.rel)
+		if (err == nil) != tt.ok {
+			t.Errorf("resolveWithin(%q) error = %v, want ok=%v", tt.rel, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("resolveWithin(%q) = %q, want %q", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		base, target string
+		want         bool
+	}{
+		{"/a/b", "/a/b", true},
+		{"/a/b", "/a/b/c", true},
+		{"/a/b", "/a/b/../b/c", true},
+		{"/a/b", "/a/bc", false},
+		{"/a/b", "/a", false},
+		{"/a/b", "/a/b/../c", false},
+		{"/a/b", "/etc/passwd", false},
+		{"/a/b", "a/b", false},
+	}
+	for _, tt := range tests {
+		if got := isWithin(tt.base, tt.target); got != tt.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", tt.base, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestParseProblemRejectsEscapingSubmitPath(t *testing.T) {
+	for _, p := range []string{"../main.c", "src/../../main.c", "/etc/passwd", ".."} {
+		data := "id: p\nsubmits:\n- path: " + p + "\nworkflow:\n- image: x\n"
+		if _, err := ParseProblem([]byte(data), 1, 0); err == nil {
+			t.Errorf("ParseProblem accepted submit path %q", p)
+		}
+	}
+}
+
+// newSubmitFileEnv 创建提交目录、评测目录和提交目录外的秘密文件
+func newSubmitFileEnv(t *testing.T) (e *Evaluator, ctx *types.SubmitCtx, submitsDir string, secret string) {
+	t.Helper()
+	root := t.TempDir()
+
+	secret = path.Join(root, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	submitDir := path.Join(root, "submit")
+	submitsDir = path.Join(root, "judge")
+	for _, d := range []string{submitDir, submitsDir} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &types.Config{SubmitUid: os.Getuid(), SubmitGid: os.Getgid()}
+	e = &Evaluator{cfg: cfg, fileMode: 0400, dirMode: 0700}
+	ctx = newTestCtx(t, cfg)
+	ctx.SubmitDir = submitDir
+	return e, ctx, submitsDir, secret
+}
+
+func TestSubmitFileRejectsMaliciousPaths(t *testing.T) {
+	e, ctx, submitsDir, secret := newSubmitFileEnv(t)
+
+	for _, p := range []string{"../secret", "src/../../secret", "../judge/x"} {
+		if err := e.submitFile(ctx, submitsDir, p); err == nil {
+			t.Errorf("submitFile accepted %q", p)
+		}
+	}
+
+	// 指向提交目录外的文件的符号链接
+	if err := os.Symlink(secret, path.Join(ctx.SubmitDir, "main.c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.submitFile(ctx, submitsDir, "main.c"); err == nil || !strings.Contains(err.Error(), "escapes") {
+		t.Errorf("submitFile followed a symlink outside the submit directory: %v", err)
+	}
+
+	// 指向提交目录外的目录的符号链接
+	if err := os.Symlink(path.Dir(secret), path.Join(ctx.SubmitDir, "src")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.submitFile(ctx, submitsDir, "src/secret"); err == nil || !strings.Contains(err.Error(), "escapes") {
+		t.Errorf("submitFile followed a directory symlink outside the submit directory: %v", err)
+	}
+
+	// FIFO和指向FIFO的符号链接，打开时不能阻塞
+	if err := syscall.Mkfifo(path.Join(ctx.SubmitDir, "pipe.c"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.submitFile(ctx, submitsDir, "pipe.c"); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("submitFile accepted a FIFO: %v", err)
+	}
+
+	// 指向提交目录外（如其他用户）的压缩包的符号链接
+	outside := path.Join(path.Dir(secret), "other.tar.gz")
+	writeTarGz(t, outside, map[string]string{"main.c": "stolen"})
+	if err := os.Symlink(outside, path.Join(ctx.SubmitDir, "code.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := e.submitArchive(ctx, submitsDir, "code", 0, &count); err == nil || !strings.Contains(err.Error(), "escapes") {
+		t.Errorf("submitArchive followed a symlink outside the submit directory: %v", err)
+	}
+	if err := os.Symlink("pipe.c", path.Join(ctx.SubmitDir, "fifo.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.submitArchive(ctx, submitsDir, "fifo", 0, &count); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("submitArchive accepted a FIFO: %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(submitsDir, "main.c")); !os.IsNotExist(err) {
+		t.Errorf("rejected file was copied: %v", err)
+	}
+	if _, err := os.Stat(path.Join(submitsDir, "code")); !os.IsNotExist(err) {
+		t.Errorf("rejected archive was extracted: %v", err)
+	}
+	if _, err := os.Stat(path.Join(submitsDir, "src", "secret")); !os.IsNotExist(err) {
+		t.Errorf("rejected file was copied: %v", err)
+	}
+}
+
+func TestSubmitFileCopiesFilesInside(t *testing.T) {
+	e, ctx, submitsDir, _ := newSubmitFileEnv(t)
+
+	if err := os.MkdirAll(path.Join(ctx.SubmitDir, "src"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(ctx.SubmitDir, "src", "main.c"), []byte("int main;"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 指向提交目录内的符号链接是允许的
+	if err := os.Symlink("src/main.c", path.Join(ctx.SubmitDir, "link.c")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"src/main.c", "link.c"} {
+		if err := e.submitFile(ctx, submitsDir, p); err != nil {
+			t.Fatalf("submitFile(%q): %v", p, err)
+		}
+		data, err := os.ReadFile(path.Join(submitsDir, p))
+		if err != nil || string(data) != "int main;" {
+			t.Errorf("copied %q = %q, %v", p, data, err)
+		}
+	}
+	if len(ctx.SubmitsHashes) != 2 {
+		t.Errorf("recorded %d hashes, want 2", len(ctx.SubmitsHashes))
+	}
+}
+
+// writeTarGz 将files写入tar.gz压缩包
+func writeTarGz(t *testing.T, file string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitArchiveCountsFiles(t *testing.T) {
+	e, ctx, submitsDir, _ := newSubmitFileEnv(t)
+	writeTarGz(t, path.Join(ctx.SubmitDir, "code.tar.gz"), map[string]string{"a.c": "a", "b.c": "b", "src/c.c": "c"})
+
+	// 解压出的文件与之前提交的文件一起计数
+	count := 1
+	if err := e.submitArchive(ctx, submitsDir, "code", 3, &count); !errors.Is(err, errTooManyFiles) {
+		t.Errorf("submitArchive with 4 files and max 3 = %v, want errTooManyFiles", err)
+	}
+
+	e, ctx, submitsDir, _ = newSubmitFileEnv(t)
+	writeTarGz(t, path.Join(ctx.SubmitDir, "code.tar.gz"), map[string]string{"a.c": "a", "b.c": "b", "src/c.c": "c"})
+	count = 1
+	if err := e.submitArchive(ctx, submitsDir, "code", 4, &count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || len(ctx.SubmitsHashes) != 3 {
+		t.Errorf("count = %d, hashes = %d, want 4 and 3", count, len(ctx.SubmitsHashes))
+	}
+	data, err := os.ReadFile(path.Join(submitsDir, "code", "src", "c.c"))
+	if err != nil || string(data) != "c" {
+		t.Errorf("extracted code/src/c.c = %q, %v", data, err)
+	}
+}
+
+func TestWorkflowNetwork(t *testing.T) {
+	e := &Evaluator{cfg: &types.Config{}}
+	a := &types.SubmitCtx{ID: "a"}
+	b := &types.SubmitCtx{ID: "b"}
+	restricted := &types.Workflow{AllowedHosts: []string{"license"}}
+
+	if got := e.workflowNetwork(a, 0, &types.Workflow{}); got != "" {
+		t.Errorf("workflow without allowedhosts uses network %q", got)
+	}
+	// 不同提交和同一提交的不同工作流不能共用网络
+	names := map[string]bool{}
+	for _, n := range []string{e.workflowNetwork(a, 0, restricted), e.workflowNetwork(a, 1, restricted), e.workflowNetwork(b, 0, restricted)} {
+		if names[n] {
+			t.Errorf("network %q is shared", n)
+		}
+		names[n] = true
+	}
+	if got := e.workflowNetwork(a, 0, restricted); got != "soj-restricted-a-1" {
+		t.Errorf("workflowNetwork = %q, want soj-restricted-a-1", got)
+	}
+
+	e.cfg.RestrictedNetwork = "judge-net"
+	if got := e.workflowNetwork(b, 2, restricted); got != "judge-net-b-3" {
+		t.Errorf("workflowNetwork with prefix = %q, want judge-net-b-3", got)
+	}
+}
+
+// fakeDocker 按设定返回结果的Docker接口，记录启动容器和执行步骤的次数
+type fakeDocker struct {
+	startFailures int // 前startFailures次启动容器失败
+	exitCode      int
+	execErr       error
+
+	runs  int
+	execs int
+}
+
+func (f *fakeDocker) RunImage(name string, user string, hostname string, image string, workdir string, mounts []mount.Mount, mask bool, ReadonlyRootfs bool, networkdisabled bool, timeout int, networkhosted bool, env []string, restrictedNetwork string, entrypoint []string, securityOpts []string) (bool, string) {
+	f.runs++
+	if f.runs <= f.startFailures {
+		return false, ""
+	}
+	return true, "container-" + strconv.Itoa(f.runs)
+}
+
+func (f *fakeDocker) CleanContainer(id string) {}
+
+func (f *fakeDocker) ExecContainer(id string, cmd string, timeout int, stdout, stderr io.Writer, env []string, privileged bool) (int, string, error) {
+	f.execs++
+	return f.exitCode, "", f.execErr
+}
+
+func (f *fakeDocker) GetContainerLogs(id string) (string, error) { return "", nil }
+
+func (f *fakeDocker) CreateRestrictedNetwork(name string, hosts []string) error { return nil }
+
+func (f *fakeDocker) RemoveNetwork(name string) {}
+
+func TestRetryWorkflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		docker  fakeDocker
+		runs    int
+		execs   int
+		ok      bool
+		infra   bool
+		results int
+	}{
+		{"container starts", fakeDocker{}, 1, 1, true, false, 1},
+		{"container starts on retry", fakeDocker{startFailures: 2}, 3, 1, true, false, 1},
+		{"container never starts", fakeDocker{startFailures: 5}, 3, 0, false, true, 0},
+		{"non-zero exit code", fakeDocker{exitCode: 1}, 1, 1, false, false, 1},
+		{"step timeout", fakeDocker{exitCode: -1, execErr: context.DeadlineExceeded}, 1, 1, false, false, 1},
+		// 步骤已经运行后执行环境出错，工作目录可能已被修改，不重试
+		{"exec error after start", fakeDocker{exitCode: -1, execErr: errors.New("connection reset")}, 1, 1, false, false, 1},
+	}
+
+	noLogs := false
+	for _, tt := range tests {
+		cfg := &types.Config{}
+		docker := tt.docker
+		e := &Evaluator{cfg: cfg, docker: &docker}
+		ctx := newTestCtx(t, cfg)
+		workflow := &types.Workflow{Image: "judge", Steps: []string{"run"}, Retries: 2, CaptureContainerLogs: &noLogs}
+		problem := &types.Problem{Id: "p", Workflow: []types.Workflow{*workflow}}
+		dir := t.TempDir()
+
+		_, _, err := e.retryWorkflow(ctx, &activeJudge{}, problem, 0, workflow, dir, dir, dir, dir, dir)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+		if errors.Is(err, errJudgeInfra) != tt.infra {
+			t.Errorf("%s: err = %v, want infra = %v", tt.name, err, tt.infra)
+		}
+		if docker.runs != tt.runs || docker.execs != tt.execs {
+			t.Errorf("%s: started %d containers and ran %d steps, want %d and %d", tt.name, docker.runs, docker.execs, tt.runs, tt.execs)
+		}
+		if len(ctx.WorkflowResults) != tt.results {
+			t.Errorf("%s: %d workflow results, want %d", tt.name, len(ctx.WorkflowResults), tt.results)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package judge
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/pkg/errors"
+)
+
+// fileHashes 文件的MD5和SHA-256哈希
+type fileHashes struct {
+	MD5    string
+	SHA256 string
+}
+
+// copyHashed 将r复制到w，同时计算MD5和SHA-256
+func copyHashed(w io.Writer, r io.Reader) (fileHashes, error) {
+	m, s := md5.New(), sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(r, io.MultiWriter(m, s))); err != nil {
+		return fileHashes{}, err
+	}
+	return fileHashes{
+		MD5:    hex.EncodeToString(m.Sum(nil)),
+		SHA256: hex.EncodeToString(s.Sum(nil)),
+	}, nil
+}
+
+// SubmitFileHash 提交前计算的文件哈希
+type SubmitFileHash struct {
+	types.SubmitHash
+	Optional bool
+	Invalid  bool // 文件解析后位于提交目录外或不是普通文件，评测时会被拒绝
+}
+
+// hashSubmitted 计算提交目录中文件的哈希，与评测时复制文件一样拒绝逃逸的符号链接和特殊文件
+// 文件不存在时返回空哈希，其他错误时invalid为true
+func hashSubmitted(submitDir string, rel string, optional bool) SubmitFileHash {
+	var h = SubmitFileHash{SubmitHash: types.SubmitHash{Path: rel}, Optional: optional}
+
+	f, err := openSubmitted(submitDir, rel)
+	if os.IsNotExist(err) {
+		return h
+	}
+	if err != nil {
+		h.Invalid = true
+		return h
+	}
+	defer f.Close()
+
+	hash, err := copyHashed(io.Discard, f)
+	if err != nil {
+		h.Invalid = true
+		return h
+	}
+	h.Hash, h.SHA256 = hash.MD5, hash.SHA256
+	return h
+}
+
+// HashSubmitFiles 计算提交目录中将被提交的文件的哈希，不复制文件
+// 缺失的文件以空哈希返回，不能提交的文件标记为Invalid
+func HashSubmitFiles(submitDir string, problem *types.Problem) []SubmitFileHash {
+	var hashes []SubmitFileHash
+
+	for _, submit := range problem.Submits {
+		switch {
+		case submit.Archive:
+			hashes = append(hashes, hashSubmitted(submitDir, submit.SourcePath(), submit.Optional))
+		case submit.IsDir:
+			dir_path := path.Join(submitDir, submit.Path)
+			found := false
+			filepath.WalkDir(dir_path, func(p string, info fs.DirEntry, err error) error {
+				if err != nil || info.IsDir() {
+					return nil
+				}
+				rel, err := filepath.Rel(dir_path, p)
+				if err != nil {
+					return nil
+				}
+				hashes = append(hashes, hashSubmitted(submitDir, submit.Path+"/"+rel, submit.Optional))
+				found = true
+				return nil
+			})
+			if !found {
+				hashes = append(hashes, SubmitFileHash{types.SubmitHash{Path: submit.Path + "/"}, submit.Optional, false})
+			}
+		default:
+			hashes = append(hashes, hashSubmitted(submitDir, submit.Path, submit.Optional))
+		}
+	}
+
+	return hashes
+}
+
+// checkManifest 校验提交文件的SHA-256是否与问题的清单一致，manifest为空时不校验
+func checkManifest(manifest map[string]string, hashes []types.SubmitHash) error {
+	if len(manifest) == 0 {
+		return nil
+	}
+
+	var got = make(map[string]string, len(hashes))
+	for _, h := range hashes {
+		got[h.Path] = h.SHA256
+	}
+
+	var paths = make([]string, 0, len(manifest))
+	for p := range manifest {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		sum, ok := got[path.Clean(p)]
+		if !ok {
+			return errors.New("manifest file " + p + " was not submitted")
+		}
+		if !strings.EqualFold(sum, manifest[p]) {
+			return errors.New("file " + p + " does not match manifest")
+		}
+	}
+	return nil
+}
+
+// checkManifestEntries 校验清单中的路径和SHA-256格式
+func checkManifestEntries(manifest map[string]string) error {
+	for p, sum := range manifest {
+		if p == "" || filepath.IsAbs(p) || !isWithin(".", p) {
+			return errors.New("invalid manifest path " + strconv.Quote(p))
+		}
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return errors.New("invalid sha256 for manifest path " + p)
+		}
+	}
+	return nil
+}
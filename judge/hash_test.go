@@ -0,0 +1,83 @@
+package judge
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+func TestHashSubmitFiles(t *testing.T) {
+	root := t.TempDir()
+	secret := path.Join(root, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	submitDir := path.Join(root, "submit")
+	if err := os.MkdirAll(path.Join(submitDir, "src"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path.Join(submitDir, "main.c"), []byte("int main;"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("main.c", path.Join(submitDir, "inside.c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, path.Join(submitDir, "shadow.c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(path.Join(submitDir, "pipe.c"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, path.Join(submitDir, "code.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(submitDir, "src", "a.c"), []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, path.Join(submitDir, "src", "b.c")); err != nil {
+		t.Fatal(err)
+	}
+
+	problem := &types.Problem{Submits: []types.Submit{
+		{Path: "main.c"},
+		{Path: "inside.c"},
+		{Path: "shadow.c"},
+		{Path: "pipe.c"},
+		{Path: "missing.c"},
+		{Path: "code", Archive: true},
+		{Path: "src", IsDir: true},
+	}}
+
+	want := map[string]struct {
+		hashed  bool
+		invalid bool
+	}{
+		"main.c":      {true, false},
+		"inside.c":    {true, false},
+		"shadow.c":    {false, true},
+		"pipe.c":      {false, true},
+		"missing.c":   {false, false},
+		"code.tar.gz": {false, true},
+		"src/a.c":     {true, false},
+		"src/b.c":     {false, true},
+	}
+
+	hashes := HashSubmitFiles(submitDir, problem)
+	if len(hashes) != len(want) {
+		t.Fatalf("HashSubmitFiles returned %d entries, want %d: %+v", len(hashes), len(want), hashes)
+	}
+	for _, h := range hashes {
+		w, ok := want[h.Path]
+		if !ok {
+			t.Errorf("unexpected entry %q", h.Path)
+			continue
+		}
+		if (h.SHA256 != "") != w.hashed || h.Invalid != w.invalid {
+			t.Errorf("%s: sha256 = %q, invalid = %v, want hashed = %v, invalid = %v", h.Path, h.SHA256, h.Invalid, w.hashed, w.invalid)
+		}
+	}
+}
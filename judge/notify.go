@@ -0,0 +1,59 @@
+package judge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog/log"
+)
+
+// notifyTimeout 通知请求的超时时间
+const notifyTimeout = 5 * time.Second
+
+// NotifyPayload 评测完成通知内容
+type NotifyPayload struct {
+	ID      string  `json:"id"`
+	User    string  `json:"user"`
+	Problem string  `json:"problem"`
+	Status  string  `json:"status"`
+	Message string  `json:"message"`
+	Success bool    `json:"success"`
+	Score   float64 `json:"score"`
+}
+
+// notify 异步向NotifyURL发送评测完成通知，失败只记录日志
+func (e *Evaluator) notify(ctx *types.SubmitCtx) {
+	if e.cfg.NotifyURL == "" {
+		return
+	}
+
+	payload, err := json.Marshal(NotifyPayload{
+		ID:      ctx.ID,
+		User:    ctx.User,
+		Problem: ctx.Problem,
+		Status:  ctx.Status,
+		Message: ctx.Msg,
+		Success: ctx.JudgeResult.Success,
+		Score:   ctx.JudgeResult.Score,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("id", ctx.ID).Msg("failed to marshal notify payload")
+		return
+	}
+
+	go func(id string) {
+		client := http.Client{Timeout: notifyTimeout}
+		resp, err := client.Post(e.cfg.NotifyURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("failed to send judge notification")
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Warn().Int("status", resp.StatusCode).Str("id", id).Msg("judge notification rejected")
+		}
+	}(ctx.ID)
+}
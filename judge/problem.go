@@ -3,6 +3,12 @@ package judge
 import (
 	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/mrhaoxx/SOJ/types"
 	"github.com/pkg/errors"
@@ -11,40 +17,278 @@ import (
 
 // ProblemManager 问题管理器
 type ProblemManager struct {
-	problems map[string]types.Problem
-	pblms    []string
+	mu            sync.RWMutex
+	problems      map[string]types.Problem
+	pblms         []string
+	files         map[string]string
+	defaultWeight float64
+	maxWorkflows  int
 }
 
 // NewProblemManager 创建新的问题管理器
-func NewProblemManager() *ProblemManager {
+func NewProblemManager(cfg *types.Config) *ProblemManager {
+	defaultWeight := cfg.DefaultWeight
+	if defaultWeight <= 0 {
+		defaultWeight = 1.0
+	}
 	return &ProblemManager{
-		problems: make(map[string]types.Problem),
-		pblms:    make([]string, 0),
+		problems:      make(map[string]types.Problem),
+		pblms:         make([]string, 0),
+		files:         make(map[string]string),
+		defaultWeight: defaultWeight,
+		maxWorkflows:  cfg.MaxWorkflows,
 	}
 }
 
-// LoadProblem 加载单个问题
-func (pm *ProblemManager) LoadProblem(file string) types.Problem {
-	_f, err := os.ReadFile(file)
+// ParseProblem 解析并校验问题定义，未设置权重时使用defaultWeight，maxWorkflows大于0时限制工作流数量
+func ParseProblem(data []byte, defaultWeight float64, maxWorkflows int) (types.Problem, error) {
+	var _p types.Problem
 
+	err := yaml.Unmarshal(data, &_p)
 	if err != nil {
-		panic(err)
+		return _p, errors.Wrap(err, "failed to unmarshal problem")
 	}
 
-	var _p types.Problem
+	if _p.Id == "" {
+		return _p, errors.New("problem id is empty")
+	}
+	if strings.ContainsAny(_p.Id, "/\\") || _p.Id == "." || _p.Id == ".." {
+		return _p, errors.New("problem id " + _p.Id + " is not a valid file name")
+	}
+	if len(_p.Workflow) == 0 {
+		return _p, errors.New("problem " + _p.Id + " has no workflow")
+	}
+	if maxWorkflows > 0 && len(_p.Workflow) > maxWorkflows {
+		return _p, errors.Errorf("problem %s has %d workflows, more than MaxWorkflows %d", _p.Id, len(_p.Workflow), maxWorkflows)
+	}
+	if _, ok := resultFiles[_p.ResultFormat]; _p.ResultFormat != "" && !ok {
+		return _p, errors.New("problem " + _p.Id + " has unknown result format " + _p.ResultFormat)
+	}
+	for _, sub := range _p.Submits {
+		if sub.Path == "" || filepath.IsAbs(sub.Path) || !isWithin(".", sub.Path) {
+			return _p, errors.New("problem " + _p.Id + " has invalid submit path " + sub.Path)
+		}
+		if sub.MaxFileSize < 0 {
+			return _p, errors.New("problem " + _p.Id + " submit " + sub.Path + " has negative maxfilesize")
+		}
+	}
+	if !_p.OpenTime.IsZero() && !_p.CloseTime.IsZero() && !_p.OpenTime.Before(_p.CloseTime) {
+		return _p, errors.New("problem " + _p.Id + " opentime is not before closetime")
+	}
+	if err := checkEnv(_p.Env); err != nil {
+		return _p, errors.Wrapf(err, "problem %s", _p.Id)
+	}
+	if err := checkManifestEntries(_p.Manifest); err != nil {
+		return _p, errors.Wrapf(err, "problem %s", _p.Id)
+	}
+	if _p.Reference != "" && !filepath.IsAbs(_p.Reference) {
+		return _p, errors.New("problem " + _p.Id + " reference must be an absolute path")
+	}
+	if _p.Cooldown != nil && *_p.Cooldown < 0 {
+		return _p, errors.New("problem " + _p.Id + " cooldown must not be negative")
+	}
+	if err := checkScoreAdjust(_p.ScoreAdjust); err != nil {
+		return _p, errors.Wrapf(err, "problem %s scoreadjust", _p.Id)
+	}
+	var testWorkflows, resultWorkflows, graderWorkflows int
+	for i, w := range _p.Workflow {
+		if w.Image == "" {
+			return _p, errors.Errorf("problem %s workflow %d has no image", _p.Id, i+1)
+		}
+		if err := checkEnv(w.Env); err != nil {
+			return _p, errors.Wrapf(err, "problem %s workflow %d", _p.Id, i+1)
+		}
+		if err := checkWorkflowPaths(&w); err != nil {
+			return _p, errors.Wrapf(err, "problem %s workflow %d", _p.Id, i+1)
+		}
+		if w.Grader {
+			if w.TestCases != nil {
+				return _p, errors.Errorf("problem %s workflow %d: grader workflow cannot have test cases", _p.Id, i+1)
+			}
+			graderWorkflows++
+		} else if graderWorkflows > 0 {
+			return _p, errors.Errorf("problem %s workflow %d: grader workflows must come after all other workflows", _p.Id, i+1)
+		}
+		if writesResult(&_p, &w) {
+			resultWorkflows++
+			if workflowUsesPath(&w, resultDir) {
+				return _p, errors.Errorf("problem %s workflow %d: %s is reserved for the protected result", _p.Id, i+1, resultDir)
+			}
+		}
+		if err := checkAllowedHosts(&w); err != nil {
+			return _p, errors.Wrapf(err, "problem %s workflow %d", _p.Id, i+1)
+		}
+		if w.Retries < 0 || w.Retries > maxWorkflowRetries {
+			return _p, errors.Errorf("problem %s workflow %d retries must be between 0 and %d", _p.Id, i+1, maxWorkflowRetries)
+		}
+		if w.TestCases != nil {
+			if testWorkflows++; testWorkflows > 1 {
+				return _p, errors.Errorf("problem %s has test cases in more than one workflow", _p.Id)
+			}
+			if err := checkTestCases(w.TestCases); err != nil {
+				return _p, errors.Wrapf(err, "problem %s workflow %d", _p.Id, i+1)
+			}
+		}
+	}
+
+	if _p.ProtectedResult && testWorkflows == 0 && resultWorkflows == 0 {
+		return _p, errors.New("problem " + _p.Id + " protectedresult requires a root workflow to write the result")
+	}
+
+	if _p.Weight == 0 {
+		_p.Weight = defaultWeight
+	}
+
+	return _p, nil
+}
+
+// containerNamePattern Docker容器名称的格式
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
 
-	err = yaml.Unmarshal(_f, &_p)
+// checkAllowedHosts 校验allowedhosts，每项必须是容器名称，且不能与禁用网络或主机网络同时使用
+func checkAllowedHosts(w *types.Workflow) error {
+	if len(w.AllowedHosts) == 0 {
+		return nil
+	}
+	if w.DisableNetwork || w.NetworkHostMode {
+		return errors.New("allowedhosts cannot be used with disablenetwork or networkhostmode")
+	}
+	for _, host := range w.AllowedHosts {
+		if !containerNamePattern.MatchString(host) {
+			return errors.New("allowedhosts entry " + strconv.Quote(host) + " is not a container name")
+		}
+	}
+	return nil
+}
+
+// checkScoreAdjust 校验分数调整的阈值和扣分比例
+func checkScoreAdjust(sa *types.ScoreAdjust) error {
+	if sa == nil {
+		return nil
+	}
+	penalties := []struct {
+		name    string
+		penalty *types.ResourcePenalty
+	}{
+		{"time", sa.Time},
+		{"memory", sa.Memory},
+	}
+	for _, p := range penalties {
+		if p.penalty == nil {
+			continue
+		}
+		if p.penalty.Limit <= p.penalty.Threshold {
+			return errors.Errorf("%s limit must be greater than threshold", p.name)
+		}
+		if p.penalty.Penalty < 0 || p.penalty.Penalty > 1 {
+			return errors.Errorf("%s penalty must be between 0 and 1", p.name)
+		}
+	}
+	return nil
+}
+
+// maxWorkflowRetries 工作流重试次数上限
+const maxWorkflowRetries = 5
+
+// 提交文件和工作目录在容器中的默认挂载点
+const (
+	defaultSubmitsMount = "/submits"
+	defaultWorkDirMount = "/work"
+)
+
+// workflowPaths 获取工作流的提交文件挂载点、工作目录挂载点和容器工作目录，未配置时使用默认值
+func workflowPaths(w *types.Workflow) (submits, work, workdir string) {
+	submits, work, workdir = w.SubmitsMount, w.WorkDirMount, w.ContainerWorkdir
+	if submits == "" {
+		submits = defaultSubmitsMount
+	}
+	if work == "" {
+		work = defaultWorkDirMount
+	}
+	if workdir == "" {
+		workdir = work
+	}
+	return submits, work, workdir
+}
+
+// checkWorkflowPaths 校验工作流的挂载点和容器工作目录
+func checkWorkflowPaths(w *types.Workflow) error {
+	submits, work, workdir := workflowPaths(w)
+	for _, p := range []string{submits, work, workdir} {
+		if !path.IsAbs(p) || path.Clean(p) != p {
+			return errors.New("container path " + strconv.Quote(p) + " must be a clean absolute path")
+		}
+	}
+	if submits == "/" || work == "/" {
+		return errors.New("submitsmount and workdirmount cannot be /")
+	}
+	if submits == work {
+		return errors.New("submitsmount and workdirmount must be different")
+	}
+	if w.TestCases != nil && (submits == testsDir || work == testsDir) {
+		return errors.New(testsDir + " is reserved for test cases")
+	}
+	return nil
+}
+
+// workflowUsesPath 工作流的挂载点是否占用了容器中的路径p
+func workflowUsesPath(w *types.Workflow, p string) bool {
+	submits, work, _ := workflowPaths(w)
+	if submits == p || work == p {
+		return true
+	}
+	for _, mnt := range w.Mounts {
+		if path.Clean(mnt.Target) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEnv 校验自定义环境变量名，不允许覆盖保留的 SOJ_ 变量
+func checkEnv(env map[string]string) error {
+	for k := range env {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			return errors.New("invalid env name " + strconv.Quote(k))
+		}
+		if strings.HasPrefix(k, reservedEnvPrefix) {
+			return errors.New("env " + k + " uses reserved prefix " + reservedEnvPrefix)
+		}
+	}
+	return nil
+}
+
+// ReadProblem 读取并解析问题文件，不加入问题列表
+func (pm *ProblemManager) ReadProblem(file string) (types.Problem, error) {
+	_f, err := os.ReadFile(file)
 
 	if err != nil {
-		panic(errors.Wrap(err, "failed to unmarshal problem "+file))
+		return types.Problem{}, err
 	}
 
-	if _p.Weight == 0 {
-		_p.Weight = 1.0
+	_p, err := ParseProblem(_f, pm.defaultWeight, pm.maxWorkflows)
+
+	if err != nil {
+		return _p, errors.Wrap(err, "failed to load problem "+file)
 	}
 
+	return _p, nil
+}
+
+// LoadProblem 加载单个问题
+func (pm *ProblemManager) LoadProblem(file string) types.Problem {
+	_p, err := pm.ReadProblem(file)
+
+	if err != nil {
+		panic(err)
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
 	pm.pblms = append(pm.pblms, _p.Id)
 	pm.problems[_p.Id] = _p
+	pm.files[_p.Id] = file
 	return _p
 }
 
@@ -56,30 +300,125 @@ func (pm *ProblemManager) LoadProblemDir(dir string) map[string]types.Problem {
 		panic(err)
 	}
 
+	pm.mu.Lock()
 	pm.problems = make(map[string]types.Problem)
 	pm.pblms = make([]string, 0)
+	pm.files = make(map[string]string)
+	pm.mu.Unlock()
 
 	for _, f := range _f {
 		var _pf = pm.LoadProblem(dir + "/" + f.Name())
-		pm.problems[_pf.Id] = _pf
 		log.Println("loaded problem", _pf.Id)
 	}
 
-	return pm.problems
+	return pm.GetAllProblems()
+}
+
+// ReloadProblemDir 重新加载目录中的所有问题，任一问题无效时保持当前问题集不变
+func (pm *ProblemManager) ReloadProblemDir(dir string) (map[string]types.Problem, error) {
+	_f, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make(map[string]types.Problem)
+	pblms := make([]string, 0)
+	files := make(map[string]string)
+
+	for _, f := range _f {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		file := filepath.Join(dir, f.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		_p, err := ParseProblem(data, pm.defaultWeight, pm.maxWorkflows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load problem "+file)
+		}
+		pblms = append(pblms, _p.Id)
+		problems[_p.Id] = _p
+		files[_p.Id] = file
+	}
+
+	pm.mu.Lock()
+	pm.problems = problems
+	pm.pblms = pblms
+	pm.files = files
+	pm.mu.Unlock()
+
+	return problems, nil
+}
+
+// InstallProblem 校验问题定义并原子地写入问题目录，随后重新加载
+func (pm *ProblemManager) InstallProblem(dir string, data []byte) (types.Problem, map[string]types.Problem, error) {
+	_p, err := ParseProblem(data, pm.defaultWeight, pm.maxWorkflows)
+	if err != nil {
+		return _p, nil, err
+	}
+
+	pm.mu.RLock()
+	target, ok := pm.files[_p.Id]
+	pm.mu.RUnlock()
+	if !ok {
+		target = filepath.Join(dir, _p.Id+".yaml")
+	}
+
+	tmp, err := os.CreateTemp(dir, ".putproblem-*")
+	if err != nil {
+		return _p, nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return _p, nil, err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return _p, nil, err
+	}
+
+	err = os.Rename(tmp.Name(), target)
+	if err != nil {
+		return _p, nil, err
+	}
+
+	problems, err := pm.ReloadProblemDir(dir)
+	if err != nil {
+		return _p, nil, errors.Wrap(err, "problem installed but reload failed")
+	}
+
+	return _p, problems, nil
 }
 
 // GetProblem 获取问题
 func (pm *ProblemManager) GetProblem(id string) (types.Problem, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 	p, ok := pm.problems[id]
 	return p, ok
 }
 
 // GetAllProblems 获取所有问题
 func (pm *ProblemManager) GetAllProblems() map[string]types.Problem {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 	return pm.problems
 }
 
 // GetProblemList 获取问题列表
 func (pm *ProblemManager) GetProblemList() []string {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 	return pm.pblms
 }
@@ -0,0 +1,137 @@
+package judge
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+// newTestProblemDir 创建包含一个问题的问题目录并加载
+func newTestProblemDir(t *testing.T) (*ProblemManager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("id: a\nversion: 1\nworkflow:\n- image: judge-a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := NewProblemManager(&types.Config{})
+	if _, err := pm.ReloadProblemDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return pm, dir
+}
+
+// listDir 列出目录中的文件名
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestInstallProblemValid(t *testing.T) {
+	pm, dir := newTestProblemDir(t)
+
+	data := []byte("id: b\nversion: 3\nweight: 2\nworkflow:\n- image: judge-b\n")
+	pb, problems, err := pm.InstallProblem(dir, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.Id != "b" || pb.Weight != 2 {
+		t.Errorf("installed problem = %+v", pb)
+	}
+	if len(problems) != 2 {
+		t.Errorf("problem set has %d problems, want 2", len(problems))
+	}
+	if got, ok := pm.GetProblem("b"); !ok || got.Version != 3 {
+		t.Errorf("GetProblem(b) = %+v, %v", got, ok)
+	}
+	written, err := os.ReadFile(filepath.Join(dir, "b.yaml"))
+	if err != nil || string(written) != string(data) {
+		t.Errorf("b.yaml = %q, %v", written, err)
+	}
+
+	// 更新已有问题时写回原文件
+	_, _, err = pm.InstallProblem(dir, []byte("id: a\nversion: 2\nworkflow:\n- image: judge-a2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := pm.GetProblem("a"); got.Version != 2 || got.Workflow[0].Image != "judge-a2" {
+		t.Errorf("GetProblem(a) after update = %+v", got)
+	}
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"a.yaml", "b.yaml"}) {
+		t.Errorf("problem dir contains %v", names)
+	}
+}
+
+func TestInstallProblemRejectsInvalid(t *testing.T) {
+	pm, dir := newTestProblemDir(t)
+	before := pm.GetAllProblems()
+	beforeData, err := os.ReadFile(filepath.Join(dir, "a.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []string{
+		"id: b\nworkflow: []\n",
+		"id: a\nversion: 9\nworkflow:\n- image: \"\"\n",
+		"id: ../evil\nworkflow:\n- image: x\n",
+		"workflow:\n- image: x\n",
+		"not: [valid yaml",
+	}
+	for _, data := range invalid {
+		if _, _, err := pm.InstallProblem(dir, []byte(data)); err == nil {
+			t.Errorf("InstallProblem accepted %q", data)
+		}
+	}
+
+	if after := pm.GetAllProblems(); !reflect.DeepEqual(after, before) {
+		t.Errorf("problem set changed after rejected installs: %+v", after)
+	}
+	if got, _ := pm.GetProblem("a"); got.Version != 1 {
+		t.Errorf("problem a was replaced: %+v", got)
+	}
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{"a.yaml"}) {
+		t.Errorf("problem dir contains %v after rejected installs", names)
+	}
+	afterData, err := os.ReadFile(filepath.Join(dir, "a.yaml"))
+	if err != nil || string(afterData) != string(beforeData) {
+		t.Errorf("a.yaml changed after rejected installs: %q, %v", afterData, err)
+	}
+}
+
+func TestParseProblemAllowedHosts(t *testing.T) {
+	valid := []string{
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [license-server, db.internal_1]\n",
+		"id: a\nworkflow:\n- image: x\n  disablenetwork: true\n",
+	}
+	for _, data := range valid {
+		if _, err := ParseProblem([]byte(data), 1, 0); err != nil {
+			t.Errorf("ParseProblem(%q): %v", data, err)
+		}
+	}
+
+	invalid := []string{
+		// 内部网络无法访问IP地址
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [\"license:10.0.0.5\"]\n",
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [10.0.0.5/8]\n",
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [\"\"]\n",
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [-flag]\n",
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [license]\n  disablenetwork: true\n",
+		"id: a\nworkflow:\n- image: x\n  allowedhosts: [license]\n  networkhostmode: true\n",
+	}
+	for _, data := range invalid {
+		if _, err := ParseProblem([]byte(data), 1, 0); err == nil {
+			t.Errorf("ParseProblem accepted %q", data)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package judge
+
+import (
+	"sync"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+// Queue 评测队列，限制同时运行的评测数量
+// 设置了perUser时每个用户最多同时占用perUser个槽位，超出的提交让给其他用户，被豁免的用户不受限制
+type Queue struct {
+	mu        sync.Mutex
+	workers   int
+	perUser   int
+	exempt    func(user string) bool
+	running   int
+	runningBy map[string]int
+	waiting   []*queueItem
+}
+
+type queueItem struct {
+	ctx      *types.SubmitCtx
+	exempt   bool
+	ready    chan struct{}
+	moved    chan int // 最新的排队位置，只保留一个未读的值
+	position int
+}
+
+// NewQueue 创建新的评测队列，perUser<=0表示不限制单个用户，exempt可为nil
+func NewQueue(workers int, perUser int, exempt func(user string) bool) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Queue{
+		workers:   workers,
+		perUser:   perUser,
+		exempt:    exempt,
+		runningBy: make(map[string]int),
+	}
+}
+
+// Acquire 获取一个评测槽位，排队期间每当位置变化时调用notify（位置从1开始）
+// notify在调用Acquire的goroutine中执行，Acquire返回后不会再被调用
+func (q *Queue) Acquire(ctx *types.SubmitCtx, notify func(position int)) {
+	item := &queueItem{
+		ctx:    ctx,
+		exempt: q.exempt != nil && q.exempt(ctx.User),
+		ready:  make(chan struct{}),
+		moved:  make(chan int, 1),
+	}
+
+	q.mu.Lock()
+	q.waiting = append(q.waiting, item)
+	q.dispatch()
+	q.mu.Unlock()
+
+	for {
+		select {
+		case <-item.ready:
+			return
+		case position := <-item.moved:
+			// 同时获得槽位时不再通知过期的位置
+			select {
+			case <-item.ready:
+				return
+			default:
+			}
+			notify(position)
+		}
+	}
+}
+
+// Release 释放提交占用的评测槽位，并唤醒可以运行的排队提交
+func (q *Queue) Release(ctx *types.SubmitCtx) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running--
+	q.runningBy[ctx.User]--
+	if q.runningBy[ctx.User] <= 0 {
+		delete(q.runningBy, ctx.User)
+	}
+	q.dispatch()
+}
+
+// eligible 判断排队的提交是否可以运行，调用时需持有锁
+func (q *Queue) eligible(item *queueItem) bool {
+	return q.perUser <= 0 || item.exempt || q.runningBy[item.ctx.User] < q.perUser
+}
+
+// dispatch 按先后顺序启动可以运行的提交，跳过已达到并发上限的用户
+// 排队位置发生变化的提交会收到新位置，调用时需持有锁
+func (q *Queue) dispatch() {
+	var remaining []*queueItem
+	for _, item := range q.waiting {
+		if q.running < q.workers && q.eligible(item) {
+			q.running++
+			q.runningBy[item.ctx.User]++
+			close(item.ready)
+			continue
+		}
+		remaining = append(remaining, item)
+	}
+	q.waiting = remaining
+
+	for i, item := range q.waiting {
+		if item.position != i+1 {
+			item.position = i + 1
+			// 丢弃尚未读取的旧位置，只保留最新的
+			select {
+			case <-item.moved:
+			default:
+			}
+			item.moved <- item.position
+		}
+	}
+}
+
+// Workers 获取评测槽位总数
+func (q *Queue) Workers() int {
+	return q.workers
+}
+
+// Running 获取正在运行的评测数量
+func (q *Queue) Running() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running
+}
+
+// Waiting 获取排队中的提交数量
+func (q *Queue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting)
+}
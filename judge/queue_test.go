@@ -0,0 +1,172 @@
+package judge
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+// queuedJob 在后台排队的提交，started在获得槽位时关闭
+type queuedJob struct {
+	ctx     *types.SubmitCtx
+	started chan struct{}
+}
+
+// enqueue 在后台为user排队一个提交，返回时提交已进入队列（排队或运行）
+func enqueue(t *testing.T, q *Queue, user string) *queuedJob {
+	t.Helper()
+	job := &queuedJob{
+		ctx:     &types.SubmitCtx{User: user},
+		started: make(chan struct{}),
+	}
+	before := q.Running() + q.Waiting()
+	go func() {
+		q.Acquire(job.ctx, func(int) {})
+		close(job.started)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for q.Running()+q.Waiting() == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("job of %s did not enter the queue", user)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return job
+}
+
+// isStarted 等待一小段时间判断提交是否已获得槽位
+func (j *queuedJob) isStarted() bool {
+	select {
+	case <-j.started:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestQueuePerUserFairness(t *testing.T) {
+	q := NewQueue(2, 1, nil)
+
+	var a []*queuedJob
+	for i := 0; i < 4; i++ {
+		a = append(a, enqueue(t, q, "alice"))
+	}
+	if !a[0].isStarted() {
+		t.Fatal("first job of alice did not start")
+	}
+	for i, job := range a[1:] {
+		if job.isStarted() {
+			t.Fatalf("job %d of alice started beyond the per-user limit", i+2)
+		}
+	}
+
+	// bob排在alice的所有提交之后，但alice已达到上限，空闲的槽位让给bob
+	b := enqueue(t, q, "bob")
+	if !b.isStarted() {
+		t.Fatal("job of bob did not start while a slot was free")
+	}
+	if a[1].isStarted() {
+		t.Fatal("second job of alice started while she was at the per-user limit")
+	}
+
+	// alice的提交结束后按顺序运行她的下一个提交
+	q.Release(a[0].ctx)
+	if !a[1].isStarted() {
+		t.Fatal("second job of alice did not start after her first job finished")
+	}
+	if a[2].isStarted() {
+		t.Fatal("third job of alice started beyond the per-user limit")
+	}
+
+	q.Release(b.ctx)
+	if a[2].isStarted() {
+		t.Fatal("third job of alice started while she was at the per-user limit")
+	}
+	if got := q.Waiting(); got != 2 {
+		t.Errorf("Waiting() = %d, want 2", got)
+	}
+
+	q.Release(a[1].ctx)
+	if !a[2].isStarted() {
+		t.Fatal("third job of alice did not start")
+	}
+	q.Release(a[2].ctx)
+	if !a[3].isStarted() {
+		t.Fatal("fourth job of alice did not start")
+	}
+	q.Release(a[3].ctx)
+	if q.Running() != 0 || q.Waiting() != 0 {
+		t.Errorf("queue not empty: running %d waiting %d", q.Running(), q.Waiting())
+	}
+}
+
+func TestQueueAdminExempt(t *testing.T) {
+	q := NewQueue(3, 1, func(user string) bool { return user == "admin" })
+
+	var jobs []*queuedJob
+	for i := 0; i < 3; i++ {
+		jobs = append(jobs, enqueue(t, q, "admin"))
+	}
+	for i, job := range jobs {
+		if !job.isStarted() {
+			t.Fatalf("job %d of admin was limited by the per-user limit", i+1)
+		}
+	}
+
+	// 槽位总数仍然限制被豁免的用户
+	extra := enqueue(t, q, "admin")
+	if extra.isStarted() {
+		t.Fatal("admin job started beyond the worker limit")
+	}
+	q.Release(jobs[0].ctx)
+	if !extra.isStarted() {
+		t.Fatal("admin job did not start after a slot was released")
+	}
+}
+
+func TestQueueNotifyConcurrentRelease(t *testing.T) {
+	q := NewQueue(3, 0, nil)
+
+	const n = 64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := &types.SubmitCtx{User: "user" + strconv.Itoa(i%5), Status: "init"}
+			// 与评测goroutine一样，通知和获得槽位后的处理都修改ctx，-race下可以发现通知晚于Acquire返回
+			var started bool
+			var positions []int
+			<-start
+			q.Acquire(ctx, func(position int) {
+				if started {
+					t.Errorf("job %d notified of position %d after it started", i, position)
+				}
+				positions = append(positions, position)
+				ctx.SetStatus("queued-" + strconv.Itoa(position))
+			})
+			started = true
+			ctx.SetStatus("prep_dirs")
+
+			for j := 1; j < len(positions); j++ {
+				if positions[j] >= positions[j-1] {
+					t.Errorf("job %d positions out of order: %v", i, positions)
+					break
+				}
+			}
+			time.Sleep(time.Duration(i%3) * time.Millisecond)
+			q.Release(ctx)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if q.Running() != 0 || q.Waiting() != 0 {
+		t.Errorf("queue not empty after all jobs finished: running %d, waiting %d", q.Running(), q.Waiting())
+	}
+}
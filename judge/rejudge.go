@@ -0,0 +1,119 @@
+package judge
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/pkg/errors"
+)
+
+// Rejudge 使用保存的提交文件重新评测提交，结果覆盖原提交记录
+// 评测经过评测队列，在新的工作目录中进行，阻塞直到评测结束
+func (e *Evaluator) Rejudge(prev *types.SubmitCtx, problem *types.Problem) (*types.SubmitCtx, error) {
+	ctx, err := e.rejudgeCtx(prev, problem, "rejudge")
+	if err != nil {
+		return nil, err
+	}
+
+	e.RunJudge(ctx, problem)
+
+	return ctx, nil
+}
+
+// DryRejudge 使用另一份问题定义试评测提交，评测输出写入w，结果不写入数据库，也不影响用户成绩
+func (e *Evaluator) DryRejudge(prev *types.SubmitCtx, problem *types.Problem, w io.Writer) (*types.SubmitCtx, error) {
+	ctx, err := e.rejudgeCtx(prev, problem, "dry")
+	if err != nil {
+		return nil, err
+	}
+	ctx.DryRun = true
+	ctx.Userface.Writer = w
+
+	e.RunJudge(ctx, problem)
+
+	return ctx, nil
+}
+
+// ValidateReference 试评测问题的参考解答，评测输出写入w，结果不写入数据库
+func (e *Evaluator) ValidateReference(problem *types.Problem, user string, w io.Writer) (*types.SubmitCtx, error) {
+	if problem.Reference == "" {
+		return nil, errors.New("problem " + problem.Id + " has no reference solution")
+	}
+	if _, err := os.Stat(problem.Reference); err != nil {
+		return nil, errors.Wrap(err, "reference solution of problem "+problem.Id+" is not available")
+	}
+
+	now := time.Now()
+	id := types.NewSubmitID(now)
+	dir := id + "-validate"
+
+	ctx := &types.SubmitCtx{
+		ID:      id,
+		Problem: problem.Id,
+		User:    user,
+
+		ProblemVersion: problem.Version,
+
+		SubmitTime: now.UnixNano(),
+
+		Status: "init",
+
+		SubmitDir:   problem.Reference,
+		Workdir:     path.Join(e.cfg.SubmitWorkDir, dir),
+		RealWorkdir: path.Join(e.cfg.RealSubmitWorkDir, dir),
+
+		DryRun: true,
+
+		Userface: types.Userface{
+			Buffer: bytes.NewBuffer(nil),
+			Writer: w,
+		},
+		Running: make(chan struct{}),
+	}
+
+	e.RunJudge(ctx, problem)
+
+	return ctx, nil
+}
+
+// rejudgeCtx 创建使用保存的提交文件重新评测的上下文，工作目录以kind区分
+func (e *Evaluator) rejudgeCtx(prev *types.SubmitCtx, problem *types.Problem, kind string) (*types.SubmitCtx, error) {
+	storedDir := path.Join(prev.Workdir, "submits")
+	if _, err := os.Stat(storedDir); err != nil {
+		return nil, errors.New("files of submit " + prev.ID + " are no longer available")
+	}
+
+	dir := prev.ID + "-" + kind + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	return &types.SubmitCtx{
+		ID:      prev.ID,
+		Problem: prev.Problem,
+		User:    prev.User,
+		UserSeq: prev.UserSeq,
+
+		ProblemVersion: problem.Version,
+
+		SubmitTime: prev.SubmitTime,
+
+		Status: "init",
+
+		IdempotencyKey: prev.IdempotencyKey,
+
+		SubmitDir:   storedDir,
+		StoredFiles: true,
+		Workdir:     path.Join(e.cfg.SubmitWorkDir, dir),
+
+		RealWorkdir: path.Join(e.cfg.RealSubmitWorkDir, dir),
+
+		Userface: types.Userface{
+			Buffer: bytes.NewBuffer(nil),
+			Writer: io.Discard,
+		},
+		Running: make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,186 @@
+package judge
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// 评测结果格式
+const (
+	ResultFormatJSON  = "json"
+	ResultFormatYAML  = "yaml"
+	ResultFormatScore = "score"
+)
+
+// resultFiles 各结果格式在工作目录中对应的文件名
+var resultFiles = map[string]string{
+	ResultFormatJSON:  "result.json",
+	ResultFormatYAML:  "result.yaml",
+	ResultFormatScore: "result.txt",
+}
+
+// resultDir 受保护的结果目录在root工作流容器中的挂载点
+const resultDir = "/result"
+
+// protectedResult 问题的结果文件是否从受保护的结果目录读取
+func protectedResult(problem *types.Problem) bool {
+	if problem.ProtectedResult {
+		return true
+	}
+	for i := range problem.Workflow {
+		if problem.Workflow[i].Grader {
+			return true
+		}
+	}
+	return false
+}
+
+// writesResult 工作流是否挂载受保护的结果目录
+// 问题有评测工作流时只挂载到评测工作流，否则挂载到设置了protectedresult的问题的root工作流
+func writesResult(problem *types.Problem, w *types.Workflow) bool {
+	if !protectedResult(problem) {
+		return false
+	}
+	if w.Grader {
+		return true
+	}
+	for i := range problem.Workflow {
+		if problem.Workflow[i].Grader {
+			return false
+		}
+	}
+	return w.Root
+}
+
+// resultFormat 获取问题的结果格式，默认为json
+func resultFormat(problem *types.Problem) string {
+	if problem.ResultFormat == "" {
+		return ResultFormatJSON
+	}
+	return problem.ResultFormat
+}
+
+// errNonFiniteScore 评测结果中的分数为NaN或Inf
+var errNonFiniteScore = errors.New("non-finite score")
+
+// checkFiniteScores 检查评测结果和子任务的分数都是有限值，NaN或Inf会破坏最佳分数和排名
+func checkFiniteScores(res *types.JudgeResult) error {
+	finite := func(f float64) bool {
+		return !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	if !finite(res.Score) {
+		return errors.Wrap(errNonFiniteScore, "score")
+	}
+	for _, st := range res.Subtasks {
+		if !finite(st.Score) || !finite(st.MaxScore) {
+			return errors.Wrap(errNonFiniteScore, "subtask "+st.Name)
+		}
+	}
+	return nil
+}
+
+// hasNonFiniteLiteral 判断数据中字符串以外是否出现NaN或Infinity
+// Python的json.dumps等会输出这些标准JSON不允许的值，解析失败时据此区分分数异常和格式错误
+func hasNonFiniteLiteral(data []byte) bool {
+	var inString, escaped bool
+	for i, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			continue
+		}
+		if bytes.HasPrefix(data[i:], []byte("NaN")) || bytes.HasPrefix(data[i:], []byte("Infinity")) {
+			return true
+		}
+	}
+	return false
+}
+
+// clampScore 将分数截断到[lo, hi]，返回原始分数和是否超出范围
+func clampScore(res *types.JudgeResult, lo, hi float64) (float64, bool) {
+	orig := res.Score
+	if orig < lo {
+		res.Score = lo
+	} else if orig > hi {
+		res.Score = hi
+	}
+	return orig, res.Score != orig
+}
+
+// parseResult 按格式解析评测结果
+func parseResult(format string, data []byte) (types.JudgeResult, error) {
+	var res types.JudgeResult
+
+	switch format {
+	case ResultFormatJSON:
+		err := json.Unmarshal(data, &res)
+		if err != nil && hasNonFiniteLiteral(data) {
+			return res, errors.Wrap(errNonFiniteScore, err.Error())
+		}
+		normalizeVerdict(&res)
+		return res, err
+	case ResultFormatYAML:
+		err := yaml.Unmarshal(data, &res)
+		if err != nil && hasNonFiniteLiteral(data) {
+			return res, errors.Wrap(errNonFiniteScore, err.Error())
+		}
+		normalizeVerdict(&res)
+		return res, err
+	case ResultFormatScore:
+		score, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		if err != nil {
+			return res, err
+		}
+		res.Success = true
+		res.Score = score
+		return res, nil
+	default:
+		return res, errors.New("unknown result format " + strconv.Quote(format))
+	}
+}
+
+// normalizeVerdict 将评测结论转为大写，并由结论决定Success
+func normalizeVerdict(res *types.JudgeResult) {
+	res.Verdict = strings.ToUpper(strings.TrimSpace(res.Verdict))
+	if res.Verdict != "" {
+		res.Success = types.VerdictSuccess(res.Verdict)
+	}
+}
+
+// adjustScore 按问题的ScoreAdjust根据资源占用调整评测成功的结果的分数，返回调整前的分数和是否调整
+func adjustScore(problem *types.Problem, res *types.JudgeResult) (float64, bool) {
+	if problem.ScoreAdjust == nil || !res.Success {
+		return res.Score, false
+	}
+	factor := problem.ScoreAdjust.Factor(*res)
+	if factor >= 1 {
+		return res.Score, false
+	}
+
+	base := res.Score
+	res.Score = base * factor
+	note := "score adjusted for resource usage: " + types.FormatScore(base) + " -> " + types.FormatScore(res.Score)
+	if res.Msg == "" {
+		res.Msg = note
+	} else {
+		res.Msg += "\n" + note
+	}
+	return base, true
+}
@@ -0,0 +1,130 @@
+package judge
+
+import (
+	"io"
+	"math"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+// newTestCtx 创建不写入数据库的提交
+func newTestCtx(t *testing.T, cfg *types.Config) *types.SubmitCtx {
+	t.Helper()
+	ctx := NewSubmitCtx(cfg, "user", &types.Problem{Id: "p"}, t.TempDir(), "", false, io.Discard)
+	ctx.DryRun = true
+	return ctx
+}
+
+func TestLoadResultNonFinite(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"json NaN", ResultFormatJSON, `{"success": true, "score": NaN}`},
+		{"json Infinity", ResultFormatJSON, `{"success": true, "score": Infinity}`},
+		{"json -Infinity", ResultFormatJSON, `{"success": true, "score": -Infinity}`},
+		{"json subtask NaN", ResultFormatJSON, `{"success": true, "score": 10, "subtasks": [{"name": "a", "score": NaN, "max_score": 10}]}`},
+		{"yaml NaN", ResultFormatYAML, "success: true\nscore: NaN\n"},
+		{"yaml Infinity", ResultFormatYAML, "success: true\nscore: Infinity\n"},
+		{"yaml -Infinity", ResultFormatYAML, "success: true\nscore: -Infinity\n"},
+		{"yaml .nan", ResultFormatYAML, "success: true\nscore: .nan\n"},
+		{"yaml .inf", ResultFormatYAML, "success: true\nscore: .inf\n"},
+		{"yaml -.inf", ResultFormatYAML, "success: true\nscore: -.inf\n"},
+		{"score NaN", ResultFormatScore, "NaN\n"},
+		{"score Infinity", ResultFormatScore, "Infinity\n"},
+		{"score -Infinity", ResultFormatScore, "-Infinity\n"},
+	}
+
+	cfg := &types.Config{}
+	e := &Evaluator{cfg: cfg}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestCtx(t, cfg)
+			file := path.Join(t.TempDir(), resultFiles[tt.format])
+			if err := os.WriteFile(file, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if e.loadResult(ctx, tt.format, file) {
+				t.Fatalf("loadResult accepted %q", tt.data)
+			}
+			if ctx.Status != "failed" || ctx.Msg != "invalid score from grader" {
+				t.Errorf("got status %q message %q, want failed / invalid score from grader", ctx.Status, ctx.Msg)
+			}
+			if ctx.JudgeResult.Score != 0 {
+				t.Errorf("judge result not cleared: %+v", ctx.JudgeResult)
+			}
+		})
+	}
+}
+
+func TestLoadResultValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		score  float64
+	}{
+		{"json", ResultFormatJSON, `{"success": true, "score": 87.5, "message": "NaN is fine in a string"}`, 87.5},
+		{"yaml", ResultFormatYAML, "success: true\nscore: 42\nmessage: Infinity\n", 42},
+		{"score", ResultFormatScore, "60\n", 60},
+	}
+
+	cfg := &types.Config{}
+	e := &Evaluator{cfg: cfg}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestCtx(t, cfg)
+			file := path.Join(t.TempDir(), resultFiles[tt.format])
+			if err := os.WriteFile(file, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if !e.loadResult(ctx, tt.format, file) {
+				t.Fatalf("loadResult rejected %q: %s", tt.data, ctx.Msg)
+			}
+			if ctx.JudgeResult.Score != tt.score {
+				t.Errorf("score = %v, want %v", ctx.JudgeResult.Score, tt.score)
+			}
+		})
+	}
+}
+
+func TestLoadResultMalformed(t *testing.T) {
+	cfg := &types.Config{}
+	e := &Evaluator{cfg: cfg}
+	ctx := newTestCtx(t, cfg)
+	file := path.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(file, []byte(`{"score": }`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.loadResult(ctx, ResultFormatJSON, file) {
+		t.Fatal("loadResult accepted malformed JSON")
+	}
+	if ctx.Msg != "failed to parse result file" {
+		t.Errorf("message = %q, want failed to parse result file", ctx.Msg)
+	}
+}
+
+func TestCheckFiniteScores(t *testing.T) {
+	tests := []struct {
+		res types.JudgeResult
+		ok  bool
+	}{
+		{types.JudgeResult{Score: 100}, true},
+		{types.JudgeResult{Score: math.NaN()}, false},
+		{types.JudgeResult{Score: math.Inf(1)}, false},
+		{types.JudgeResult{Score: math.Inf(-1)}, false},
+		{types.JudgeResult{Score: 10, Subtasks: []types.SubtaskResult{{Name: "a", Score: 5, MaxScore: math.Inf(1)}}}, false},
+	}
+	for i, tt := range tests {
+		if err := checkFiniteScores(&tt.res); (err == nil) != tt.ok {
+			t.Errorf("case %d: checkFiniteScores(%+v) = %v", i, tt.res, err)
+		}
+	}
+}
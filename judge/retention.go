@@ -0,0 +1,64 @@
+package judge
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// retentionInterval 按保留期限清理旧提交的间隔
+const retentionInterval = time.Hour
+
+// StartRetention 启动保留期限清理，定期删除早于SubmitRetentionDays天的提交及其工作目录
+// 各用户当前的最佳提交和未结束的提交不会被删除，删除后重新计算受影响用户的成绩
+func (e *Evaluator) StartRetention(pm *ProblemManager) {
+	if e.cfg.SubmitRetentionDays <= 0 {
+		return
+	}
+	retention := time.Duration(e.cfg.SubmitRetentionDays) * 24 * time.Hour
+
+	log.Info().Int("days", e.cfg.SubmitRetentionDays).Msg("submit retention started")
+
+	go func() {
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+		for {
+			e.pruneSubmits(pm, time.Now().Add(-retention))
+			<-ticker.C
+		}
+	}()
+}
+
+// pruneSubmits 删除before之前的提交，清理其工作目录并重新计算受影响用户的成绩
+func (e *Evaluator) pruneSubmits(pm *ProblemManager, before time.Time) {
+	deleted, err := e.dbService.DeleteOldSubmits(before)
+	if err != nil {
+		log.Error().Err(err).Msg("retention: failed to delete old submits")
+	}
+	if len(deleted) == 0 {
+		return
+	}
+
+	users := make(map[string]bool)
+	for _, s := range deleted {
+		users[s.User] = true
+		// 只删除评测目录下的工作目录
+		if s.Workdir != "" && strings.HasPrefix(path.Clean(s.Workdir), path.Clean(e.cfg.SubmitWorkDir)+"/") {
+			if err := os.RemoveAll(path.Clean(s.Workdir)); err != nil {
+				log.Warn().Err(err).Str("id", s.ID).Str("workdir", s.Workdir).Msg("retention: failed to remove workdir")
+			}
+		}
+	}
+
+	problems := pm.GetAllProblems()
+	for user := range users {
+		if err := e.dbService.RecalculateUserBestScoresWithProblems(user, problems); err != nil {
+			log.Error().Err(err).Str("user", user).Msg("retention: failed to recalculate user")
+		}
+	}
+
+	log.Info().Int("submits", len(deleted)).Int("users", len(users)).Time("before", before).Msg("retention: pruned old submits")
+}
@@ -0,0 +1,63 @@
+package judge
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"time"
+
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog/log"
+)
+
+// NewSubmitCtx 创建新的提交，SSH和HTTP提交共用；评测输出写入w
+// stored为true时submitDir是之前评测保存的提交文件
+func NewSubmitCtx(cfg *types.Config, user string, pb *types.Problem, submitDir string, key string, stored bool, w io.Writer) *types.SubmitCtx {
+	subtime := time.Now()
+
+	id := types.NewSubmitID(subtime)
+	return &types.SubmitCtx{
+		ID:      id,
+		Problem: pb.Id,
+		User:    user,
+
+		ProblemVersion: pb.Version,
+
+		SubmitTime: subtime.UnixNano(),
+
+		Status: "init",
+
+		IdempotencyKey: key,
+
+		SubmitDir:   submitDir,
+		StoredFiles: stored,
+		Workdir:     path.Join(cfg.SubmitWorkDir, id),
+
+		RealWorkdir: path.Join(cfg.RealSubmitWorkDir, id),
+
+		Userface: types.Userface{
+			Buffer: bytes.NewBuffer(nil),
+			Writer: w,
+		},
+		Running: make(chan struct{}),
+	}
+}
+
+// Submit 在后台评测提交，返回时提交已写入数据库（pending状态）并分配了用户序号，
+// 评测结束后与SSH提交一样更新用户成绩
+// 返回的是启动评测前的提交ID、用户序号和状态，之后ctx由评测协程修改，调用方不能再读取
+func (e *Evaluator) Submit(ctx *types.SubmitCtx, problem *types.Problem) (id string, userSeq int, status string) {
+	e.markPending(ctx)
+	id, userSeq, status = ctx.ID, ctx.UserSeq, ctx.Status
+
+	go func() {
+		e.runJudge(ctx, problem)
+
+		err := e.dbService.UpdateUserSubmitResult(ctx.User, ctx, problem)
+		if err != nil {
+			log.Error().Err(err).Str("user", ctx.User).Str("id", ctx.ID).Msg("failed to update user submit result")
+		}
+	}()
+
+	return id, userSeq, status
+}
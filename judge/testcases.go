@@ -0,0 +1,277 @@
+package judge
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// testsDir 测试数据在容器中的挂载点
+const testsDir = "/tests"
+
+// listTestCases 列出测试数据目录中的测试点名（<name>.in），按名称排序
+func listTestCases(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var cases []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".in") {
+			continue
+		}
+		cases = append(cases, strings.TrimSuffix(entry.Name(), ".in"))
+	}
+	sort.Strings(cases)
+
+	if len(cases) == 0 {
+		return nil, errors.New("no test cases found in " + dir)
+	}
+	return cases, nil
+}
+
+// groupSubtasks 将测试点分配到子任务，未配置子任务时每个测试点单独成为子任务并平分满分full
+func groupSubtasks(tc *types.TestCases, cases []string, full float64) ([]types.SubtaskResult, error) {
+	var subtasks []types.SubtaskResult
+
+	if len(tc.Subtasks) == 0 {
+		for _, name := range cases {
+			subtasks = append(subtasks, types.SubtaskResult{
+				Name:     name,
+				MaxScore: full / float64(len(cases)),
+				Cases:    []types.CaseResult{{Name: name}},
+			})
+		}
+		return subtasks, nil
+	}
+
+	for i, st := range tc.Subtasks {
+		var name = st.Name
+		if name == "" {
+			name = "subtask " + strconv.Itoa(i+1)
+		}
+
+		res := types.SubtaskResult{Name: name, MaxScore: st.Score}
+		for _, c := range cases {
+			for _, pattern := range st.Cases {
+				if ok, _ := path.Match(pattern, c); ok {
+					res.Cases = append(res.Cases, types.CaseResult{Name: c})
+					break
+				}
+			}
+		}
+		if len(res.Cases) == 0 {
+			return nil, errors.New(name + " matches no test cases")
+		}
+		subtasks = append(subtasks, res)
+	}
+	return subtasks, nil
+}
+
+// runTestCases 在容器中按子任务顺序运行测试点，同一测试点只运行一次
+// 依赖的子任务未全部通过时，该子任务的测试点不运行并记为跳过
+// 测试点失败或超时不返回错误，只在无法列出或分配测试点时返回错误
+func (e *Evaluator) runTestCases(ctx *types.SubmitCtx, cid string, tc *types.TestCases, timeout int, envs []string) ([]types.SubtaskResult, error) {
+	cases, err := listTestCases(tc.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	subtasks, err := groupSubtasks(tc, cases, e.cfg.FullScore())
+	if err != nil {
+		return nil, err
+	}
+
+	if tc.Timeout > 0 {
+		timeout = tc.Timeout
+	}
+
+	ctx.Userface.Println(types.GetTime(time.Now()), "running", aurora.Bold(len(cases)), "test cases")
+
+	results := make(map[string]types.CaseResult, len(cases))
+	passedSubtasks := make([]bool, len(subtasks))
+	for i := range subtasks {
+		st := &subtasks[i]
+
+		var deps []int
+		if i < len(tc.Subtasks) {
+			deps = tc.Subtasks[i].DependsOn
+		}
+		for _, d := range deps {
+			if !passedSubtasks[d-1] {
+				st.Skipped = true
+				break
+			}
+		}
+
+		if st.Skipped {
+			for j := range st.Cases {
+				st.Cases[j].Skipped = true
+			}
+			ctx.Userface.Println("	*", aurora.Yellow(st.Name), ":", aurora.Gray(15, "skipped (dependency failed)"))
+			log.Debug().Timestamp().Str("id", ctx.ID).Str("subtask", st.Name).Ints("depends_on", deps).Msg("skipped subtask")
+			continue
+		}
+
+		var depStrs []string
+		for _, d := range deps {
+			depStrs = append(depStrs, strconv.Itoa(d))
+		}
+		subtaskEnvs := append(envs[:len(envs):len(envs)],
+			"SOJ_SUBTASK="+strconv.Itoa(i+1),
+			"SOJ_SUBTASK_DEPENDS="+strings.Join(depStrs, ","),
+		)
+
+		passed := true
+		for j, c := range st.Cases {
+			res, ok := results[c.Name]
+			if !ok {
+				res = e.runTestCase(ctx, cid, tc, c.Name, timeout, subtaskEnvs)
+				results[c.Name] = res
+			}
+			st.Cases[j] = res
+			passed = passed && res.Passed
+		}
+		if passed {
+			st.Score = st.MaxScore
+		}
+		passedSubtasks[i] = passed
+	}
+
+	return subtasks, nil
+}
+
+// runTestCase 运行单个测试点
+func (e *Evaluator) runTestCase(ctx *types.SubmitCtx, cid string, tc *types.TestCases, name string, timeout int, envs []string) types.CaseResult {
+	caseEnvs := append(envs[:len(envs):len(envs)],
+		"SOJ_TEST_NAME="+name,
+		"SOJ_TEST_INPUT="+path.Join(testsDir, name+".in"),
+		"SOJ_TEST_ANSWER="+path.Join(testsDir, name+".ans"),
+	)
+
+	start := time.Now()
+	ec, _, err := e.docker.ExecContainer(cid, tc.Run, timeout, nil, nil, caseEnvs, false)
+	duration := time.Since(start)
+
+	res := types.CaseResult{
+		Name:       name,
+		Passed:     ec == 0 && err == nil,
+		Verdict:    caseVerdict(ec, err),
+		ExitCode:   ec,
+		DurationNs: duration.Nanoseconds(),
+	}
+
+	if res.Passed {
+		ctx.Userface.Println("	*", aurora.Yellow(name), ":", aurora.Green("passed"), aurora.Gray(15, duration.Round(time.Millisecond)))
+	} else {
+		ctx.Userface.Println("	*", aurora.Yellow(name), ":", aurora.Red("failed"), types.ColorizeVerdict(res.Verdict), aurora.Gray(15, duration.Round(time.Millisecond)))
+	}
+	log.Debug().Timestamp().Str("id", ctx.ID).Str("case", name).Int("exitcode", ec).AnErr("err", err).Dur("duration", duration).Msg("ran test case")
+
+	return res
+}
+
+// caseVerdict 根据测试点命令的退出码推断评测结论
+// 超时为TLE，被SIGKILL终止（137，通常是内存超限被OOM killer终止）为MLE，其他非零退出码为WA
+func caseVerdict(ec int, err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return types.VerdictTimeLimitExceeded
+	case err != nil:
+		return types.VerdictRuntimeError
+	case ec == 0:
+		return types.VerdictAccepted
+	case ec == 137:
+		return types.VerdictMemoryLimitExceeded
+	default:
+		return types.VerdictWrongAnswer
+	}
+}
+
+// testCasesResult 根据子任务结果生成评测结果，用时取最慢的测试点
+func testCasesResult(subtasks []types.SubtaskResult) types.JudgeResult {
+	res := types.JudgeResult{Success: true, Subtasks: subtasks}
+
+	// 结论取第一个未通过测试点的结论，部分得分时为PC
+	var firstFailed string
+	var passed, total, skipped int
+	seen := make(map[string]bool)
+	for _, st := range subtasks {
+		res.Score += st.Score
+		for _, c := range st.Cases {
+			if seen[c.Name] || c.Skipped {
+				continue
+			}
+			seen[c.Name] = true
+			total++
+			if c.Passed {
+				passed++
+			} else if firstFailed == "" {
+				firstFailed = c.Verdict
+			}
+			res.Time = max(res.Time, uint64(c.DurationNs))
+		}
+	}
+	switch {
+	case firstFailed == "":
+		res.Verdict = types.VerdictAccepted
+	case res.Score > 0:
+		res.Verdict = types.VerdictPartiallyCorrect
+	default:
+		res.Verdict = firstFailed
+	}
+	res.Success = types.VerdictSuccess(res.Verdict)
+	for _, st := range subtasks {
+		for _, c := range st.Cases {
+			if c.Skipped && !seen[c.Name] {
+				seen[c.Name] = true
+				skipped++
+			}
+		}
+	}
+
+	res.Msg = "passed " + strconv.Itoa(passed) + "/" + strconv.Itoa(total) + " test cases"
+	if skipped > 0 {
+		res.Msg += ", " + strconv.Itoa(skipped) + " skipped"
+	}
+	return res
+}
+
+// checkTestCases 校验工作流的测试点配置
+func checkTestCases(tc *types.TestCases) error {
+	if tc.Dir == "" || !path.IsAbs(tc.Dir) {
+		return errors.New("testcases dir must be an absolute path")
+	}
+	if tc.Run == "" {
+		return errors.New("testcases has no run command")
+	}
+	for i, st := range tc.Subtasks {
+		if st.Score < 0 {
+			return errors.Errorf("subtask %d has negative score", i+1)
+		}
+		if len(st.Cases) == 0 {
+			return errors.Errorf("subtask %d has no cases", i+1)
+		}
+		for _, d := range st.DependsOn {
+			if d < 1 || d > i {
+				return errors.Errorf("subtask %d can only depend on earlier subtasks, got %d", i+1, d)
+			}
+		}
+		for _, pattern := range st.Cases {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Errorf("subtask %d has invalid case pattern %s", i+1, strconv.Quote(pattern))
+			}
+		}
+	}
+	return nil
+}
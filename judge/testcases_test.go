@@ -0,0 +1,21 @@
+package judge
+
+import (
+	"testing"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+func TestGroupSubtasksSplitsFullScore(t *testing.T) {
+	subtasks, err := groupSubtasks(&types.TestCases{}, []string{"1", "2", "3", "4"}, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, st := range subtasks {
+		total += st.MaxScore
+	}
+	if len(subtasks) != 4 || total != 150 {
+		t.Errorf("got %d subtasks with %v points, want 4 with 150", len(subtasks), total)
+	}
+}
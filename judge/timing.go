@@ -0,0 +1,60 @@
+package judge
+
+import (
+	"strings"
+	"time"
+
+	"github.com/logrusorgru/aurora/v4"
+)
+
+// phaseTimer 记录评测各阶段的耗时，用于在评测输出中显示
+type phaseTimer struct {
+	start  time.Time
+	phase  string
+	since  time.Time
+	phases []phaseDuration
+}
+
+// phaseDuration 一个已结束阶段的耗时
+type phaseDuration struct {
+	name     string
+	duration time.Duration
+}
+
+// newPhaseTimer 创建从start开始、当前处于phase阶段的计时器
+func newPhaseTimer(start time.Time, phase string) *phaseTimer {
+	return &phaseTimer{start: start, phase: phase, since: start}
+}
+
+// enter 结束当前阶段并进入新阶段，返回刚结束阶段的耗时；phase为空表示不处于任何阶段
+func (t *phaseTimer) enter(phase string) time.Duration {
+	now := time.Now()
+	d := now.Sub(t.since)
+	if t.phase != "" {
+		t.phases = append(t.phases, phaseDuration{name: t.phase, duration: d})
+	}
+	t.phase, t.since = phase, now
+	return d
+}
+
+// summary 结束当前阶段，返回各阶段耗时和总耗时的汇总
+func (t *phaseTimer) summary() string {
+	t.enter("")
+
+	var parts []string
+	for _, p := range t.phases {
+		parts = append(parts, p.name+" "+formatElapsed(p.duration))
+	}
+	parts = append(parts, "total "+formatElapsed(time.Since(t.start)))
+	return strings.Join(parts, ", ")
+}
+
+// formatElapsed 格式化耗时，精确到毫秒
+func formatElapsed(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// elapsed 评测输出中阶段耗时的显示形式
+func elapsed(d time.Duration) aurora.Value {
+	return aurora.Gray(15, "(+"+formatElapsed(d)+")")
+}
@@ -0,0 +1,111 @@
+package judge
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog/log"
+)
+
+// watchdogInterval 看门狗扫描的最长间隔
+const watchdogInterval = 30 * time.Second
+
+// stalledMessage 被看门狗终止的评测的提示信息
+const stalledMessage = "judge stalled and was killed by the watchdog"
+
+// activeJudge 正在进行的评测，供看门狗清理
+type activeJudge struct {
+	mu        sync.Mutex
+	container string
+	stalled   atomic.Bool
+}
+
+// setContainer 记录当前运行的评测容器
+func (aj *activeJudge) setContainer(id string) {
+	aj.mu.Lock()
+	aj.container = id
+	aj.mu.Unlock()
+}
+
+// track 登记正在进行的评测
+func (e *Evaluator) track(id string) *activeJudge {
+	aj := &activeJudge{}
+	e.activeMu.Lock()
+	e.active[id] = aj
+	e.activeMu.Unlock()
+	return aj
+}
+
+// untrack 取消登记评测
+func (e *Evaluator) untrack(id string) {
+	e.activeMu.Lock()
+	delete(e.active, id)
+	e.activeMu.Unlock()
+}
+
+// StartWatchdog 启动看门狗，定期将LastUpdate超过StuckJudgeTimeout未更新的评测标记为dead并清理其容器
+// 排队中的提交不受影响；阈值应大于工作流单步的最长超时时间
+func (e *Evaluator) StartWatchdog() {
+	if e.cfg.StuckJudgeTimeout <= 0 {
+		return
+	}
+	threshold := time.Duration(e.cfg.StuckJudgeTimeout) * time.Second
+	interval := min(threshold/2, watchdogInterval)
+
+	log.Info().Dur("threshold", threshold).Msg("judge watchdog started")
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.killStalled(threshold)
+		}
+	}()
+}
+
+// killStalled 清理超过阈值未更新的评测
+func (e *Evaluator) killStalled(threshold time.Duration) {
+	submits, err := e.dbService.GetStalledSubmits(time.Now().Add(-threshold))
+	if err != nil {
+		log.Error().Err(err).Msg("watchdog: failed to get stalled submits")
+		return
+	}
+
+	for _, submit := range submits {
+		if submit.Status == "init" || submit.Status == "pending" || strings.HasPrefix(submit.Status, "queued-") {
+			continue
+		}
+
+		e.activeMu.Lock()
+		aj, ok := e.active[submit.ID]
+		e.activeMu.Unlock()
+
+		if ok {
+			aj.stalled.Store(true)
+			aj.mu.Lock()
+			container := aj.container
+			aj.mu.Unlock()
+			if container != "" {
+				e.docker.CleanContainer(container)
+			}
+		}
+
+		log.Warn().Str("id", submit.ID).Str("status", submit.Status).Int64("last_update", submit.LastUpdate).Bool("active", ok).Msg("watchdog: killing stalled judge")
+
+		submit.SetStatus("dead").SetMsg(stalledMessage)
+		err := e.dbService.UpdateSubmit(&submit)
+		if err != nil {
+			log.Error().Err(err).Str("id", submit.ID).Msg("watchdog: failed to mark submit dead")
+		}
+	}
+}
+
+// checkStalled 评测结束时若已被看门狗终止则标记为dead
+func checkStalled(aj *activeJudge, ctx *types.SubmitCtx) {
+	if aj.stalled.Load() {
+		ctx.SetStatus("dead").SetMsg(stalledMessage)
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mrhaoxx/SOJ/file_transfer"
@@ -24,16 +26,38 @@ import (
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
+	configFlag := flag.String("config", "", "path to the config file (default $SOJ_CONFIG or config.yaml)")
+	checkFlag := flag.Bool("check-config", false, "validate the config, problems and docker connectivity, then exit")
+	flag.Parse()
+
 	// 读取配置
 	var cfg types.Config
-	_cfg, err := os.ReadFile("config.yaml")
+	configFile := configPath(*configFlag)
+	_cfg, err := os.ReadFile(configFile)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to read config file")
+		log.Fatal().Err(err).Str("config", configFile).Msg("failed to read config file")
 	}
 
 	err = yaml.Unmarshal(_cfg, &cfg)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to parse config file")
+		log.Fatal().Err(err).Str("config", configFile).Msg("failed to parse config file")
+	}
+
+	if *checkFlag {
+		os.Exit(checkConfig(&cfg))
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+
+	err = types.SetDisplayTimezone(cfg.DisplayTimezone)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load display timezone")
+	}
+	if cfg.ScorePrecision != nil {
+		types.SetScorePrecision(*cfg.ScorePrecision)
 	}
 
 	// 解析SSH公钥
@@ -66,33 +90,50 @@ func main() {
 	}
 
 	// 初始化问题管理器
-	problemManager := judge.NewProblemManager()
+	problemManager := judge.NewProblemManager(&cfg)
 	problems := problemManager.LoadProblemDir(cfg.ProblemsDir)
 
+	err = dbService.SetProblems(problems)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to recalculate total scores")
+	}
+
 	// 执行全量用户扫描
-	err = dbService.DoFullUserScan(problems)
+	_, err = dbService.DoFullUserScan(problems)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to perform full user scan")
 	}
 
 	// 初始化评测器
 	evaluator := judge.NewEvaluator(&cfg, dockerService, dbService)
+	evaluator.StartWatchdog()
+	evaluator.StartRetention(problemManager)
+
+	// 运行时状态（维护模式等）
+	state := types.NewRuntimeState(&cfg, dbService)
 
 	// 初始化HTTP服务器
-	httpServer := ui.NewHTTPServer(dbService)
+	httpServer := ui.NewHTTPServer(dbService, problemManager, evaluator, &cfg, state)
 	httpServer.ServeHTTP(cfg.APIAddr)
 
 	// 初始化SSH处理器
-	sshHandler := ui.NewSSHHandler(dbService, &cfg, problems)
+	sshHandler := ui.NewSSHHandler(dbService, &cfg, problemManager, evaluator, state)
 
 	// 设置SSH服务器
 	s := &ssh.Server{
 		Addr: cfg.ListenAddr,
 		Handler: func(s ssh.Session) {
+			defer sshHandler.TrackSession(s)()
+
 			// 处理特殊的submit命令
 			cmds := s.Command()
+			if len(cmds) >= 1 && !sshHandler.CheckCommand(s, cmds[0]) {
+				return
+			}
 			if len(cmds) >= 2 && (cmds[0] == "submit" || cmds[0] == "sub") {
-				handleSubmit(s, &cfg, evaluator, problemManager, dbService, cmds)
+				s.Exit(handleSubmit(s, &cfg, state, evaluator, problemManager, dbService, cmds))
+			} else if len(cmds) >= 1 && cmds[0] == "resubmit" {
+				s.Exit(handleResubmit(s, &cfg, state, evaluator, problemManager, dbService, cmds))
 			} else {
 				sshHandler.HandleSession(s)
 			}
@@ -115,106 +156,467 @@ func main() {
 	}
 }
 
+// checkConfig 校验配置、所有问题和Docker连接，不启动服务，全部通过时返回0
+func checkConfig(cfg *types.Config) int {
+	var failed bool
+
+	if err := cfg.Validate(); err != nil {
+		for _, e := range strings.Split(err.Error(), "\n") {
+			log.Error().Str("check", "config").Msg(e)
+		}
+		failed = true
+	}
+
+	if _, err := gossh.ParsePrivateKey([]byte(cfg.HostKey)); err != nil {
+		log.Error().Str("check", "config").Err(err).Msg("invalid HostKey")
+		failed = true
+	}
+	if cfg.AllowedSSHPubkey != "" {
+		if _, _, _, _, err := gossh.ParseAuthorizedKey([]byte(cfg.AllowedSSHPubkey)); err != nil {
+			log.Error().Str("check", "config").Err(err).Msg("invalid AllowedSSHPubkey")
+			failed = true
+		}
+	}
+
+	problemManager := judge.NewProblemManager(cfg)
+	entries, err := os.ReadDir(cfg.ProblemsDir)
+	if err != nil {
+		log.Error().Str("check", "problems").Err(err).Msg("failed to read problems dir")
+		failed = true
+	}
+	var loaded int
+	var ids = make(map[string]bool)
+	for _, f := range entries {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		p, err := problemManager.ReadProblem(path.Join(cfg.ProblemsDir, f.Name()))
+		if err != nil {
+			log.Error().Str("check", "problems").Str("file", f.Name()).Err(err).Msg("invalid problem")
+			failed = true
+			continue
+		}
+		log.Info().Str("check", "problems").Str("problem", p.Id).Msg("ok")
+		ids[p.Id] = true
+		loaded++
+	}
+
+	for group, problems := range cfg.ProblemGroups {
+		for _, id := range problems {
+			if !ids[id] {
+				log.Warn().Str("check", "config").Str("group", group).Str("problem", id).Msg("problem group references unknown problem")
+			}
+		}
+	}
+
+	dockerService, err := file_transfer.NewDockerService()
+	if err == nil {
+		err = dockerService.Ping(5 * time.Second)
+	}
+	if err != nil {
+		log.Error().Str("check", "docker").Err(err).Msg("docker is not reachable")
+		failed = true
+	}
+
+	if failed {
+		log.Error().Msg("config check failed")
+		return 1
+	}
+	log.Info().Int("problems", loaded).Msg("config check passed")
+	return 0
+}
+
+// configPath 获取配置文件路径，优先级: -config 参数 > SOJ_CONFIG 环境变量 > config.yaml
+func configPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if env := os.Getenv("SOJ_CONFIG"); env != "" {
+		return env
+	}
+	return "config.yaml"
+}
+
 // handleSubmit 处理提交命令
-func handleSubmit(s ssh.Session, cfg *types.Config, evaluator *judge.Evaluator, problemManager *judge.ProblemManager, dbService *types.DatabaseService, cmds []string) {
+func handleSubmit(s ssh.Session, cfg *types.Config, state *types.RuntimeState, evaluator *judge.Evaluator, problemManager *judge.ProblemManager, dbService *types.DatabaseService, cmds []string) int {
 	uf := types.Userface{
 		Buffer: bytes.NewBuffer(nil),
 		Writer: s,
 	}
 
-	uf.Println(aurora.Yellow(time.Now().Format(time.DateTime + " MST")))
-
-	if len(cmds) != 2 {
+	uf.Println(aurora.Yellow(types.FormatTime(time.Now())))
+
+	var key string
+	var yes bool
+	var badArgs = len(cmds) < 2
+	for i := 2; i < len(cmds) && !badArgs; i++ {
+		switch cmds[i] {
+		case "--key":
+			if i+1 >= len(cmds) || cmds[i+1] == "" {
+				badArgs = true
+				break
+			}
+			key = cmds[i+1]
+			i++
+		case "--yes", "-y":
+			yes = true
+		default:
+			badArgs = true
+		}
+	}
+	if badArgs {
 		uf.Println(aurora.Red("error:"), "invalid arguments")
-		uf.Println("usage: submit <problem_id>")
-		return
+		uf.Println("usage: submit <problem_id> [--key <idempotency_key>] [--yes]")
+		return exitRejected
 	}
 
 	pid := cmds[1]
 
 	pb, ok := problemManager.GetProblem(pid)
-	if !ok {
+	if !ok || !dbService.CanAccessProblem(s.User(), pid) {
 		uf.Println(aurora.Red("error:"), "problem", aurora.Yellow(strconv.Quote(pid)), "not found")
-		return
+		return exitRejected
+	}
+
+	// 相同幂等键的重复提交直接返回已有的提交
+	if existing := existingKeyedSubmit(dbService, s.User(), key); existing != nil {
+		if existing.Problem != pid {
+			uf.Println(aurora.Red("error:"), "key", aurora.Yellow(strconv.Quote(key)), "is already used by submit", aurora.Magenta(existing.ID), "for problem", aurora.Bold(existing.Problem))
+			return exitRejected
+		}
+		uf.Println(aurora.Yellow("Duplicate submit key"), aurora.Yellow(strconv.Quote(key)), "returning existing submit", aurora.Magenta(existing.ID))
+		uf.Println("Submit", "is", types.ColorizeStatus(existing.Status))
+		uf.Println("Message:\n	", aurora.Blue(existing.Msg))
+		writeResult(uf, cfg, *existing)
+		return submitExitCode(cfg, *existing)
+	}
+
+	if checkMaintenance(uf, state) || checkPaused(uf, state) || checkProblemOpen(uf, &pb) {
+		return exitRejected
+	}
+
+	if checkBanned(uf, s.User(), dbService) {
+		return exitRejected
 	}
 
 	// 检查用户是否已有运行中的提交
-	hasRunning, err := dbService.HasUserRunningSubmit(s.User())
+	if checkRunningSubmit(uf, s.User(), dbService) {
+		return exitRejected
+	}
+
+	if checkCooldown(uf, s.User(), &pb, dbService) {
+		return exitRejected
+	}
+
+	submitDir := path.Join(cfg.SubmitsDir, s.User(), pid)
+
+	if !checkSubmitFiles(uf, submitDir, &pb) {
+		return exitRejected
+	}
+
+	// 交互式会话在提交前确认文件
+	if _, _, isPty := s.Pty(); isPty && !yes {
+		if !confirmSubmit(s, uf, submitDir, &pb) {
+			uf.Println(aurora.Yellow("Submit cancelled"))
+			return exitRejected
+		}
+	}
+
+	uf.Println(aurora.Green("Submitting"), aurora.Bold(pid))
+
+	return runSubmit(uf, s.User(), cfg, evaluator, dbService, &pb, submitDir, key, false)
+}
+
+// existingKeyedSubmit 获取用户使用该幂等键的已有提交，未设置键或不存在时返回nil
+func existingKeyedSubmit(dbService *types.DatabaseService, user string, key string) *types.SubmitCtx {
+	if key == "" {
+		return nil
+	}
+	existing, err := dbService.GetSubmitByUserAndKey(user, key)
+	if err != nil {
+		return nil
+	}
+	return existing
+}
+
+// checkSubmitFiles 检查提交目录及所需文件是否存在，缺失则输出期望的路径并返回false
+func checkSubmitFiles(uf types.Userface, submitDir string, pb *types.Problem) bool {
+	_, err := os.Stat(submitDir)
+	dirMissing := err != nil
+
+	type expected struct {
+		path     string
+		missing  bool
+		optional bool
+	}
+	var paths []expected
+	var anyMissing bool
+	for _, submit := range pb.Submits {
+		p := submit.SourcePath()
+		missing := dirMissing
+		if !missing {
+			info, err := os.Stat(path.Join(submitDir, p))
+			missing = err != nil || (submit.IsDir && !submit.Archive && !info.IsDir())
+		}
+		if submit.IsDir && !submit.Archive {
+			p += "/"
+		}
+		paths = append(paths, expected{path: "/" + pb.Id + "/" + p, missing: missing, optional: submit.Optional})
+		anyMissing = anyMissing || (missing && !submit.Optional)
+	}
+
+	if !anyMissing {
+		return true
+	}
+
+	uf.Println(aurora.Red("error:"), "no files found — upload via SFTP first")
+	uf.Println("Expected paths:")
+	for _, e := range paths {
+		if e.optional {
+			uf.Println("	*", aurora.Yellow(e.path), aurora.Gray(15, "(optional)"))
+		} else if e.missing {
+			uf.Println("	*", aurora.Yellow(e.path), aurora.Red("(missing)"))
+		} else {
+			uf.Println("	*", aurora.Yellow(e.path))
+		}
+	}
+	return false
+}
+
+// confirmSubmit 显示将要提交的文件及其哈希，并等待用户输入y/n确认
+func confirmSubmit(s ssh.Session, uf types.Userface, submitDir string, pb *types.Problem) bool {
+	uf.Println("Files to submit for", aurora.Bold(pb.Id+":"))
+	for _, h := range judge.HashSubmitFiles(submitDir, pb) {
+		if h.Invalid {
+			uf.Println("	*", aurora.Yellow(h.Path), ":", aurora.Red("invalid"))
+		} else if h.Hash == "" && h.Optional {
+			uf.Println("	*", aurora.Yellow(h.Path), ":", aurora.Gray(15, "skipped (optional)"))
+		} else if h.Hash == "" {
+			uf.Println("	*", aurora.Yellow(h.Path), ":", aurora.Red("missing"))
+		} else {
+			uf.Println("	*", aurora.Yellow(h.Path), ":", aurora.Blue(h.Display()))
+		}
+	}
+	return ui.Confirm(s, uf, "Submit?")
+}
+
+// handleResubmit 处理重新提交命令，使用已保存的提交文件创建新的提交
+func handleResubmit(s ssh.Session, cfg *types.Config, state *types.RuntimeState, evaluator *judge.Evaluator, problemManager *judge.ProblemManager, dbService *types.DatabaseService, cmds []string) int {
+	uf := types.Userface{
+		Buffer: bytes.NewBuffer(nil),
+		Writer: s,
+	}
+
+	uf.Println(aurora.Yellow(types.FormatTime(time.Now())))
+
+	if len(cmds) != 2 {
+		uf.Println(aurora.Red("error:"), "invalid arguments")
+		uf.Println("usage: resubmit <submit_id|#seq>")
+		return exitRejected
+	}
+
+	var prev *types.SubmitCtx
+	var err error
+	if seq, ok := types.ParseUserSeq(cmds[1]); ok {
+		prev, err = dbService.GetSubmitByUserSeq(s.User(), seq)
+	} else {
+		prev, err = dbService.GetSubmitByID(cmds[1])
+	}
+	if err != nil || prev.User != s.User() {
+		uf.Println(aurora.Red("error:"), "submit", aurora.Yellow(strconv.Quote(cmds[1])), "not found")
+		return exitRejected
+	}
+
+	pb, ok := problemManager.GetProblem(prev.Problem)
+	if !ok || !dbService.CanAccessProblem(s.User(), prev.Problem) {
+		uf.Println(aurora.Red("error:"), "problem", aurora.Yellow(strconv.Quote(prev.Problem)), "not found")
+		return exitRejected
+	}
+
+	storedDir := path.Join(prev.Workdir, "submits")
+	if _, err := os.Stat(storedDir); err != nil {
+		uf.Println(aurora.Red("error:"), "files of submit", aurora.Magenta(prev.ID), "are no longer available")
+		return exitRejected
+	}
+
+	if checkMaintenance(uf, state) || checkPaused(uf, state) || checkProblemOpen(uf, &pb) {
+		return exitRejected
+	}
+
+	if checkBanned(uf, s.User(), dbService) {
+		return exitRejected
+	}
+
+	if checkRunningSubmit(uf, s.User(), dbService) {
+		return exitRejected
+	}
+
+	if checkCooldown(uf, s.User(), &pb, dbService) {
+		return exitRejected
+	}
+
+	if prev.ProblemVersion != pb.Version {
+		uf.Println(aurora.Yellow("warning:"), "problem", aurora.Bold(pb.Id), "has changed since submit", aurora.Magenta(prev.ID),
+			"(version", aurora.Cyan(prev.ProblemVersion), "->", aurora.Cyan(pb.Version).String()+")")
+	}
+
+	uf.Println(aurora.Green("Resubmitting"), aurora.Magenta(prev.ID), "for", aurora.Bold(prev.Problem))
+
+	return runSubmit(uf, s.User(), cfg, evaluator, dbService, &pb, storedDir, "", true)
+}
+
+// checkMaintenance 检查是否处于维护模式，是则输出提示并返回true
+func checkMaintenance(uf types.Userface, state *types.RuntimeState) bool {
+	if state.Maintenance() {
+		uf.Println(aurora.Red("error:"), "the judge is under maintenance, new submissions are not accepted. Please try again later")
+		return true
+	}
+	return false
+}
+
+// checkPaused 检查提交是否被暂停，是则输出提示并返回true
+func checkPaused(uf types.Userface, state *types.RuntimeState) bool {
+	if state.Paused() {
+		uf.Println(aurora.Red("error:"), "submit is paused. Please try again later")
+		return true
+	}
+	return false
+}
+
+// checkProblemOpen 检查问题是否在开放提交的时间窗口内，不在则输出提示并返回true
+func checkProblemOpen(uf types.Userface, pb *types.Problem) bool {
+	now := time.Now()
+	if pb.NotYetOpen(now) {
+		uf.Println(aurora.Red("error:"), "problem", aurora.Bold(pb.Id), "is not open yet, it opens at", aurora.Yellow(types.FormatTime(pb.OpenTime)))
+		return true
+	}
+	if pb.Closed(now) {
+		uf.Println(aurora.Red("error:"), "problem", aurora.Bold(pb.Id), "was closed at", aurora.Yellow(types.FormatTime(pb.CloseTime)))
+		return true
+	}
+	return false
+}
+
+// checkBanned 检查用户是否被封禁，被封禁则输出提示并返回true
+func checkBanned(uf types.Userface, user string, dbService *types.DatabaseService) bool {
+	u, err := dbService.GetUserByID(user)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get user:", err)
+		log.Error().Err(err).Str("user", user).Msg("failed to get user")
+		return true
+	}
+	if u.Banned {
+		uf.Println(aurora.Red("error:"), "your account is suspended")
+		if u.BanReason != "" {
+			uf.Println("	Reason:", aurora.Cyan(u.BanReason))
+		}
+		return true
+	}
+	return false
+}
+
+// checkRunningSubmit 检查用户是否已有运行中的提交，有则输出提示并返回true
+func checkRunningSubmit(uf types.Userface, user string, dbService *types.DatabaseService) bool {
+	hasRunning, err := dbService.HasUserRunningSubmit(user)
 	if err != nil {
 		uf.Println(aurora.Red("error:"), "failed to check running submissions:", err)
-		log.Error().Err(err).Str("user", s.User()).Msg("failed to check running submissions")
-		return
+		log.Error().Err(err).Str("user", user).Msg("failed to check running submissions")
+		return true
 	}
 
 	if hasRunning {
-		runningSubmit, err := dbService.GetUserRunningSubmit(s.User())
+		runningSubmit, err := dbService.GetUserRunningSubmit(user)
 		if err != nil {
 			uf.Println(aurora.Red("error:"), "failed to get running submission details:", err)
-			log.Error().Err(err).Str("user", s.User()).Msg("failed to get running submission details")
-			return
+			log.Error().Err(err).Str("user", user).Msg("failed to get running submission details")
+			return true
 		}
 		uf.Println(aurora.Red("error:"), "you have a running submission")
 		uf.Println("	Running submit:", aurora.Magenta(runningSubmit.ID))
 		uf.Println("	Problem:", aurora.Bold(runningSubmit.Problem))
 		uf.Println("	Status:", types.ColorizeStatus(runningSubmit.Status))
-		uf.Println("	Started:", aurora.Yellow(time.Unix(0, runningSubmit.SubmitTime).Format(time.DateTime+" MST")))
+		uf.Println("	Started:", aurora.Yellow(types.FormatUnixNano(runningSubmit.SubmitTime)))
 		uf.Println("Please wait for the current submission to finish before submitting again.")
-		return
+		return true
 	}
+	return false
+}
 
-	uf.Println(aurora.Green("Submitting"), aurora.Bold(pid))
-	subtime := time.Now()
-
-	id := strconv.Itoa(int(subtime.UnixNano()))
-	ctx := types.SubmitCtx{
-		ID:      id,
-		Problem: pid,
-		User:    s.User(),
-
-		SubmitTime: subtime.UnixNano(),
-
-		Status: "init",
-
-		SubmitDir: path.Join(cfg.SubmitsDir, s.User(), pid),
-		Workdir:   path.Join(cfg.SubmitWorkDir, id),
-
-		RealWorkdir: path.Join(cfg.RealSubmitWorkDir, id),
-
-		Userface: types.Userface{
-			Buffer: bytes.NewBuffer(nil),
-			Writer: uf,
-		},
-		Running: make(chan struct{}),
+// checkCooldown 检查用户是否仍处于该问题的提交冷却时间内，是则输出剩余时间并返回true
+func checkCooldown(uf types.Userface, user string, pb *types.Problem, dbService *types.DatabaseService) bool {
+	remaining, err := dbService.SubmitCooldown(user, pb, time.Now())
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to check submit cooldown:", err)
+		log.Error().Err(err).Str("user", user).Str("problem", pb.Id).Msg("failed to check submit cooldown")
+		return true
 	}
+	if remaining > 0 {
+		uf.Println(aurora.Red("error:"), "you submitted", aurora.Bold(pb.Id), "recently, please wait", aurora.Yellow(types.FormatCooldown(remaining)), "before submitting it again")
+		return true
+	}
+	return false
+}
 
-	go evaluator.RunJudge(&ctx, &pb)
+// runSubmit 创建提交并等待评测完成，随后更新用户数据
+func runSubmit(uf types.Userface, user string, cfg *types.Config, evaluator *judge.Evaluator, dbService *types.DatabaseService, pb *types.Problem, submitDir string, key string, stored bool) int {
+	ctx := judge.NewSubmitCtx(cfg, user, pb, submitDir, key, stored, uf)
+
+	go evaluator.RunJudge(ctx, pb)
 
 	<-ctx.Running
 
 	uf.Println("Submit", "is", types.ColorizeStatus(ctx.Status))
 	uf.Println("Message:\n	", aurora.Blue(ctx.Msg))
 
-	writeResult(uf, ctx)
+	writeResult(uf, cfg, *ctx)
 
 	// 更新用户数据
-	err = dbService.UpdateUserSubmitResult(s.User(), &ctx, &pb)
+	err := dbService.UpdateUserSubmitResult(user, ctx, pb)
 	if err != nil {
-		log.Error().Err(err).Str("user", s.User()).Msg("failed to update user submit result")
+		log.Error().Err(err).Str("user", user).Msg("failed to update user submit result")
+	}
+
+	return submitExitCode(cfg, *ctx)
+}
+
+// submit和resubmit命令的SSH退出码，便于脚本和CI判断评测结果
+const (
+	exitAccepted = 0 // 评测完成且分数不低于SubmitPassScore
+	exitFailed   = 1 // 评测失败或评测结果为失败
+	exitPartial  = 2 // 评测完成但分数低于SubmitPassScore
+	exitRejected = 3 // 提交未被受理（参数错误、问题未开放、维护中等）
+)
+
+// submitExitCode 根据提交的评测结果获取退出码
+func submitExitCode(cfg *types.Config, res types.SubmitCtx) int {
+	if res.Status != "completed" || !res.JudgeResult.Success {
+		return exitFailed
+	}
+	pass := cfg.SubmitPassScore
+	if pass <= 0 {
+		pass = cfg.FullScore()
 	}
+	if res.JudgeResult.Score < pass {
+		return exitPartial
+	}
+	return exitAccepted
 }
 
 // writeResult 写入结果
-func writeResult(uf types.Userface, res types.SubmitCtx) {
-	if res.Status != "completed" {
+func writeResult(uf types.Userface, cfg *types.Config, res types.SubmitCtx) {
+	if !types.HasJudgeResult(res.Status) {
 		uf.Println(aurora.Italic(aurora.Underline(aurora.Bold(aurora.Gray(15, "No judgement result")))))
 		uf.Println()
 		return
 	}
 	if res.JudgeResult.Success {
-		uf.Printf("Score %.2f %s\n", aurora.Underline(aurora.Bold(types.ColorizeScore(res.JudgeResult))), aurora.Italic(aurora.Gray(15, "max.100 (Unweighted)")))
+		uf.Printf("Score "+types.ScoreFormat()+" %s\n", aurora.Underline(aurora.Bold(types.ColorizeScore(res.JudgeResult))), aurora.Italic(aurora.Gray(15, "max."+types.FormatScore(cfg.FullScore())+" (Unweighted)")))
 	} else {
 		uf.Println(aurora.Red("Judgement is Failed"))
 	}
+	if res.JudgeResult.Verdict != "" {
+		uf.Println("Verdict:", aurora.Bold(types.ColorizeVerdict(res.JudgeResult.Verdict)))
+	}
 
 	uf.Println("Judgement Message:")
 
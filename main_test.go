@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"path"
+	"testing"
+
+	"github.com/mrhaoxx/SOJ/judge"
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+// newTestDB 在临时目录中创建sqlite数据库
+func newTestDB(t *testing.T) (*types.Config, *types.DatabaseService) {
+	t.Helper()
+	cfg := &types.Config{
+		SqlitePath:    path.Join(t.TempDir(), "soj.db"),
+		SubmitWorkDir: t.TempDir(),
+	}
+	ds, err := types.NewDatabaseService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, ds
+}
+
+// submitWithKey 按submit命令的流程处理带幂等键的提交：已有相同键的提交时返回它，否则创建新提交
+func submitWithKey(t *testing.T, cfg *types.Config, ds *types.DatabaseService, user string, pb *types.Problem, key string) *types.SubmitCtx {
+	t.Helper()
+	if existing := existingKeyedSubmit(ds, user, key); existing != nil {
+		return existing
+	}
+	ctx := judge.NewSubmitCtx(cfg, user, pb, t.TempDir(), key, false, io.Discard)
+	if err := ds.CreateSubmit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestIdempotencyKeyRepeatReturnsSameSubmit(t *testing.T) {
+	cfg, ds := newTestDB(t)
+	pb := &types.Problem{Id: "p"}
+
+	first := submitWithKey(t, cfg, ds, "alice", pb, "retry-1")
+	again := submitWithKey(t, cfg, ds, "alice", pb, "retry-1")
+	if again.ID != first.ID {
+		t.Errorf("repeated key created submit %s, want existing %s", again.ID, first.ID)
+	}
+	if again.UserSeq != first.UserSeq {
+		t.Errorf("repeated key user_seq = %d, want %d", again.UserSeq, first.UserSeq)
+	}
+
+	_, total, err := ds.GetSubmitsByUser("alice", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Errorf("alice has %d submits, want 1", total)
+	}
+}
+
+func TestIdempotencyKeyDistinctKeysCreateDistinctSubmits(t *testing.T) {
+	cfg, ds := newTestDB(t)
+	pb := &types.Problem{Id: "p"}
+
+	a := submitWithKey(t, cfg, ds, "alice", pb, "k1")
+	b := submitWithKey(t, cfg, ds, "alice", pb, "k2")
+	if a.ID == b.ID {
+		t.Fatalf("distinct keys returned the same submit %s", a.ID)
+	}
+
+	// 没有键的提交每次都是新提交
+	c := submitWithKey(t, cfg, ds, "alice", pb, "")
+	d := submitWithKey(t, cfg, ds, "alice", pb, "")
+	if c.ID == d.ID || c.ID == a.ID || c.ID == b.ID {
+		t.Errorf("submits without a key were merged: %s %s", c.ID, d.ID)
+	}
+
+	// 幂等键只在同一用户内生效
+	other := submitWithKey(t, cfg, ds, "bob", pb, "k1")
+	if other.ID == a.ID {
+		t.Errorf("bob's submit with alice's key returned alice's submit %s", a.ID)
+	}
+
+	_, total, err := ds.GetSubmitsByUser("alice", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 {
+		t.Errorf("alice has %d submits, want 4", total)
+	}
+}
+
+func TestSubmitExitCodeUsesFullScore(t *testing.T) {
+	fifty := 50.0
+	tests := []struct {
+		cfg   types.Config
+		score float64
+		want  int
+	}{
+		{types.Config{}, 100, exitAccepted},
+		{types.Config{}, 99, exitPartial},
+		{types.Config{MaxScore: &fifty}, 50, exitAccepted},
+		{types.Config{MaxScore: &fifty}, 49, exitPartial},
+		{types.Config{MaxScore: &fifty, SubmitPassScore: 30}, 30, exitAccepted},
+	}
+	for _, tt := range tests {
+		res := types.SubmitCtx{Status: "completed"}
+		res.JudgeResult.Success = true
+		res.JudgeResult.Score = tt.score
+		if got := submitExitCode(&tt.cfg, res); got != tt.want {
+			t.Errorf("submitExitCode(MaxScore %v, pass %v, score %v) = %d, want %d", tt.cfg.MaxScore, tt.cfg.SubmitPassScore, tt.score, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,13 @@
 package types
 
 import (
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +20,19 @@ import (
 type DatabaseService struct {
 	db  *gorm.DB
 	cfg *Config
+
+	// totalWeightBits 所有问题权重之和（float64的位），reload时写入，请求处理时并发读取
+	totalWeightBits atomic.Uint64
+
+	// seqMu 保证同一时刻只有一个提交在分配用户序号
+	seqMu sync.Mutex
+
+	pseudonymSalt string
+
+	// rankMu 保护排行榜缓存，rankVersion在每次失效时递增
+	rankMu      sync.Mutex
+	rankUsers   []User
+	rankVersion uint64
 }
 
 // NewDatabaseService 创建新的数据库服务
@@ -25,14 +45,118 @@ func NewDatabaseService(cfg *Config) (*DatabaseService, error) {
 	// 自动迁移数据库结构
 	db.AutoMigrate(&SubmitCtx{})
 	db.AutoMigrate(&User{})
+	db.AutoMigrate(&Setting{})
+	db.AutoMigrate(&SubmitEvent{})
 
 	// 清理未完成的提交
-	db.Model(&SubmitCtx{}).Where("status != ? AND status != ? AND status != ?", "completed", "dead", "failed").Update("status", "dead")
+	markDeadSubmits(db)
+
+	// 回填旧记录的资源占用列
+	backfillResourceColumns(db)
+
+	// 为旧记录按提交时间分配用户序号
+	backfillUserSeq(db)
 
-	return &DatabaseService{
+	// 区分旧记录中评测结果为失败的提交
+	backfillRejectedStatus(db)
+
+	ds := &DatabaseService{
 		db:  db,
 		cfg: cfg,
-	}, nil
+	}
+	ds.pseudonymSalt = ds.loadPseudonymSalt()
+
+	return ds, nil
+}
+
+// markDeadSubmits 将上次运行时未完成的提交标记为dead，并记录状态变化
+func markDeadSubmits(db *gorm.DB) {
+	var submits []SubmitCtx
+	db.Select("id", "status").
+		Where("status NOT IN ?", FinalStatuses).
+		Find(&submits)
+	if len(submits) == 0 {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	var events []SubmitEvent
+	for _, s := range submits {
+		events = append(events, SubmitEvent{SubmitID: s.ID, From: s.Status, To: "dead", Time: now})
+	}
+	db.Create(&events)
+
+	db.Model(&SubmitCtx{}).Where("status NOT IN ?", FinalStatuses).Update("status", "dead")
+}
+
+// backfillRejectedStatus 将旧版本中评测结果为失败的completed提交改为rejected
+func backfillRejectedStatus(db *gorm.DB) {
+	var submits []SubmitCtx
+	db.Select("id", "judge_result").
+		Where("status = ?", "completed").
+		Find(&submits)
+
+	var ids []string
+	for _, s := range submits {
+		if !s.JudgeResult.Success {
+			ids = append(ids, s.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	db.Model(&SubmitCtx{}).Where("id IN ?", ids).Update("status", "rejected")
+	log.Info().Int("submits", len(ids)).Msg("Marked failed judgements as rejected")
+}
+
+// backfillUserSeq 为没有用户序号的旧提交按提交时间依次分配序号
+func backfillUserSeq(db *gorm.DB) {
+	var submits []SubmitCtx
+	db.Select("id", "user").
+		Where("user_seq = 0").
+		Order("submit_time asc, id asc").
+		Find(&submits)
+	if len(submits) == 0 {
+		return
+	}
+
+	next := make(map[string]int)
+	for _, s := range submits {
+		if _, ok := next[s.User]; !ok {
+			var last int
+			db.Model(&SubmitCtx{}).Where("user = ?", s.User).Select("COALESCE(MAX(user_seq), 0)").Scan(&last)
+			next[s.User] = last
+		}
+		next[s.User]++
+		db.Model(&SubmitCtx{}).Where("id = ?", s.ID).Update("user_seq", next[s.User])
+	}
+
+	log.Info().Int("submits", len(submits)).Msg("Backfilled submit user sequence numbers")
+}
+
+// backfillResourceColumns 从judge_result中回填result_memory和result_time列
+func backfillResourceColumns(db *gorm.DB) {
+	var submits []SubmitCtx
+	db.Select("id", "judge_result").
+		Where("status IN ? AND result_memory = 0 AND result_time = 0", []string{"completed", "rejected"}).
+		Find(&submits)
+
+	var filled int
+	for _, s := range submits {
+		if s.JudgeResult.Memory == 0 && s.JudgeResult.Time == 0 {
+			continue
+		}
+		db.Model(&SubmitCtx{}).Where("id = ?", s.ID).Updates(map[string]interface{}{
+			"result_memory": s.JudgeResult.Memory,
+			"result_time":   s.JudgeResult.Time,
+		})
+		filled++
+	}
+
+	if filled > 0 {
+		log.Info().Int("submits", filled).Msg("Backfilled submit resource columns")
+	}
 }
 
 // GetDB 获取数据库实例
@@ -44,11 +168,41 @@ func (ds *DatabaseService) GetDB() *gorm.DB {
 // 用户操作
 // ===============================
 
+// GetSetting 获取设置，不存在时返回ok=false
+func (ds *DatabaseService) GetSetting(key string) (string, bool, error) {
+	var setting Setting
+	result := ds.db.Where("key = ?", key).Limit(1).Find(&setting)
+	if result.Error != nil {
+		return "", false, result.Error
+	}
+	return setting.Value, result.RowsAffected > 0, nil
+}
+
+// GetAllSettings 获取所有设置
+func (ds *DatabaseService) GetAllSettings() ([]Setting, error) {
+	var settings []Setting
+	result := ds.db.Order("key asc").Find(&settings)
+	return settings, result.Error
+}
+
+// SetSetting 写入设置
+func (ds *DatabaseService) SetSetting(key, value string) error {
+	return ds.db.Save(&Setting{Key: key, Value: value}).Error
+}
+
 // CreateUser 创建新用户
 func (ds *DatabaseService) CreateUser(userID string) (*User, error) {
+	return ds.CreateUserWithToken(userID, "")
+}
+
+// CreateUserWithToken 使用指定的Token创建新用户，token为空时随机生成
+func (ds *DatabaseService) CreateUserWithToken(userID, token string) (*User, error) {
+	if token == "" {
+		token = uuid.New().String()
+	}
 	user := &User{
 		ID:             userID,
-		Token:          uuid.New().String(),
+		Token:          token,
 		BestScores:     make(map[string]float64),
 		BestSubmits:    make(map[string]string),
 		BestSubmitDate: make(map[string]int64),
@@ -59,6 +213,7 @@ func (ds *DatabaseService) CreateUser(userID string) (*User, error) {
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	ds.invalidateRank()
 
 	log.Info().Str("user", userID).Msg("Created new user")
 	return user, nil
@@ -71,13 +226,31 @@ func (ds *DatabaseService) GetUserByID(userID string) (*User, error) {
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			// 用户不存在，创建新用户
-			return ds.CreateUser(userID)
+			created, err := ds.CreateUser(userID)
+			if err != nil {
+				// 同一用户并发首次连接时另一方可能已创建成功，此时返回已存在的用户
+				if existing, lerr := ds.LookupUser(userID); lerr == nil {
+					return existing, nil
+				}
+				return nil, err
+			}
+			return created, nil
 		}
 		return nil, result.Error
 	}
 	return &user, nil
 }
 
+// LookupUser 根据ID获取用户，用户不存在时返回gorm.ErrRecordNotFound而不是创建
+func (ds *DatabaseService) LookupUser(userID string) (*User, error) {
+	var user User
+	result := ds.db.Where("id = ?", userID).First(&user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
 // GetUserByToken 根据Token获取用户
 func (ds *DatabaseService) GetUserByToken(token string) (*User, error) {
 	var user User
@@ -88,13 +261,53 @@ func (ds *DatabaseService) GetUserByToken(token string) (*User, error) {
 	return &user, nil
 }
 
+// RotateUserToken 为用户生成新的Token，旧Token立即失效
+func (ds *DatabaseService) RotateUserToken(userID string) (*User, error) {
+	user, err := ds.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Token = uuid.New().String()
+	result := ds.db.Model(user).Update("token", user.Token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	log.Info().Str("user", userID).Msg("Rotated user token")
+	return user, nil
+}
+
 // UpdateUser 更新用户信息
 func (ds *DatabaseService) UpdateUser(user *User) error {
-	user.CalculateTotalScore()
+	ds.calculateTotalScore(user)
 	result := ds.db.Save(user)
+	ds.invalidateRank()
 	return result.Error
 }
 
+// SetUserBanned 封禁或解封用户，记录原因和操作者
+func (ds *DatabaseService) SetUserBanned(userID string, banned bool, reason string, by string) (*User, error) {
+	user, err := ds.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Banned = banned
+	user.BanReason = reason
+	user.BannedBy = by
+	user.BannedAt = time.Now().UnixNano()
+
+	result := ds.db.Model(user).Select("banned", "ban_reason", "banned_by", "banned_at").Updates(user)
+	ds.invalidateRank()
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	log.Info().Str("user", userID).Bool("banned", banned).Str("reason", reason).Str("by", by).Msg("Updated user ban")
+	return user, nil
+}
+
 // GetAllUsersOrderedByScore 获取按分数排序的所有用户
 func (ds *DatabaseService) GetAllUsersOrderedByScore() ([]User, error) {
 	var users []User
@@ -109,20 +322,13 @@ func (ds *DatabaseService) UpdateUserSubmitResult(userID string, submit *SubmitC
 		return err
 	}
 
-	if submit.Status == "completed" && submit.JudgeResult.Success {
-		newScore := submit.JudgeResult.Score * problem.Weight
-		if user.BestScores[submit.Problem] < newScore {
-			user.BestScores[submit.Problem] = newScore
-			user.BestSubmits[submit.Problem] = submit.ID
-			user.BestSubmitDate[submit.Problem] = submit.SubmitTime
-		}
-	}
+	user.applyBestSubmit(submit, problem)
 
 	return ds.UpdateUser(user)
 }
 
-// DoFullUserScan 全量用户扫描和重计算
-func (ds *DatabaseService) DoFullUserScan(problems map[string]Problem) error {
+// DoFullUserScan 全量用户扫描和重计算，返回更新的用户数
+func (ds *DatabaseService) DoFullUserScan(problems map[string]Problem) (int, error) {
 	var submits []SubmitCtx
 	ds.db.Find(&submits)
 
@@ -140,33 +346,142 @@ func (ds *DatabaseService) DoFullUserScan(problems map[string]Problem) error {
 			log.Fatal().Msg("Encountered corrupted data, submitted user does not exist in User table")
 		}
 
-		if s.Status == "completed" && s.JudgeResult.Success {
-			problem, exists := problems[s.Problem]
-			if exists {
-				newScore := s.JudgeResult.Score * problem.Weight
-				if u.BestScores[s.Problem] < newScore {
-					u.BestScores[s.Problem] = newScore
-					u.BestSubmits[s.Problem] = s.ID
-					u.BestSubmitDate[s.Problem] = s.SubmitTime
-				}
-			}
+		if problem, exists := problems[s.Problem]; exists {
+			u.applyBestSubmit(&s, &problem)
 		}
 
 		userMap[s.User] = u
 	}
 
+	defer ds.invalidateRank()
+
+	var updated int
 	for _, u := range userMap {
-		u.CalculateTotalScore()
-		ds.db.Save(&u)
+		ds.calculateTotalScore(&u)
+		result := ds.db.Save(&u)
+		if result.Error != nil {
+			return updated, result.Error
+		}
+		updated++
 	}
 
+	return updated, nil
+}
+
+// SetProblems 设置当前问题集，用于总分归一化，并重新计算所有用户的总分
+func (ds *DatabaseService) SetProblems(problems map[string]Problem) error {
+	var total float64
+	for _, p := range problems {
+		total += p.Weight
+	}
+	ds.totalWeightBits.Store(math.Float64bits(total))
+
+	var users []User
+	result := ds.db.Find(&users)
+	if result.Error != nil {
+		return result.Error
+	}
+	for i := range users {
+		ds.calculateTotalScore(&users[i])
+		ds.db.Model(&users[i]).Update("total_score", users[i].TotalScore)
+	}
+	ds.invalidateRank()
 	return nil
 }
 
-// IsAdmin 检查用户是否为管理员
+// totalWeight 获取所有问题权重之和
+func (ds *DatabaseService) totalWeight() float64 {
+	return math.Float64frombits(ds.totalWeightBits.Load())
+}
+
+// ScoreFactor 获取总分归一化系数，未启用归一化时为1
+func (ds *DatabaseService) ScoreFactor() float64 {
+	full := ds.cfg.FullScore()
+	total := ds.totalWeight()
+	if ds.cfg.NormalizeTotalTo <= 0 || total <= 0 || full <= 0 {
+		return 1
+	}
+	return ds.cfg.NormalizeTotalTo / (full * total)
+}
+
+// MaxTotalScore 所有问题满分时的总分（已按ScoreFactor缩放）
+func (ds *DatabaseService) MaxTotalScore() float64 {
+	return RoundScore(ds.cfg.FullScore() * ds.totalWeight() * ds.ScoreFactor())
+}
+
+// calculateTotalScore 计算用户总分并按配置归一化，缩放后再舍入
+func (ds *DatabaseService) calculateTotalScore(u *User) {
+	u.TotalScore = RoundScore(u.sumBestScores() * ds.ScoreFactor())
+}
+
+// GetUsersOrderedByScoreBefore 只统计指定时间之前的提交，重新计算并按分数排序所有用户（不写回数据库）
+func (ds *DatabaseService) GetUsersOrderedByScoreBefore(before time.Time, problems map[string]Problem) ([]User, error) {
+	var users []User
+	result := ds.db.Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var submits []SubmitCtx
+	result = ds.db.Select("id", "user", "problem", "submit_time", "status", "judge_result").
+		Where("status = ? AND submit_time < ?", "completed", before.UnixNano()).
+		Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	userMap := make(map[string]*User)
+	for i := range users {
+		users[i].BestScores = make(map[string]float64)
+		users[i].BestSubmits = make(map[string]string)
+		users[i].BestSubmitDate = make(map[string]int64)
+		userMap[users[i].ID] = &users[i]
+	}
+
+	for _, s := range submits {
+		u, ok := userMap[s.User]
+		if !ok {
+			continue
+		}
+		if problem, exists := problems[s.Problem]; exists {
+			u.applyBestSubmit(&s, &problem)
+		}
+	}
+
+	for i := range users {
+		ds.calculateTotalScore(&users[i])
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].TotalScore > users[j].TotalScore
+	})
+
+	return users, nil
+}
+
+// IsAdmin 检查用户是否为管理员（拥有任意管理员角色）
 func (ds *DatabaseService) IsAdmin(userID string) bool {
+	return ds.GetRole(userID) != ""
+}
+
+// GetRole 获取用户的管理员角色，非管理员返回空字符串
+func (ds *DatabaseService) GetRole(userID string) string {
 	for _, admin := range ds.cfg.Admins {
 		if admin == userID {
+			return RoleSuperAdmin
+		}
+	}
+	role := ds.cfg.Roles[userID]
+	if _, ok := RoleCapabilities[role]; !ok {
+		return ""
+	}
+	return role
+}
+
+// HasCapability 检查用户是否拥有指定的管理员能力
+func (ds *DatabaseService) HasCapability(userID, capability string) bool {
+	for _, c := range RoleCapabilities[ds.GetRole(userID)] {
+		if c == capability {
 			return true
 		}
 	}
@@ -179,16 +494,70 @@ func (ds *DatabaseService) IsAdmin(userID string) bool {
 
 // CreateSubmit 创建新提交
 func (ds *DatabaseService) CreateSubmit(submit *SubmitCtx) error {
+	if submit.UserSeq == 0 {
+		ds.seqMu.Lock()
+		defer ds.seqMu.Unlock()
+		submit.UserSeq = ds.nextUserSeq(submit.User)
+	}
 	submit.LastUpdate = time.Now().UnixNano()
+	submit.ResultMemory = submit.JudgeResult.Memory
+	submit.ResultTime = submit.JudgeResult.Time
 	result := ds.db.Create(submit)
-	return result.Error
+	if result.Error != nil {
+		return result.Error
+	}
+	ds.recordStatus(submit)
+	return nil
 }
 
 // UpdateSubmit 更新提交记录
 func (ds *DatabaseService) UpdateSubmit(submit *SubmitCtx) error {
+	// 首次写入的提交在此分配序号，持锁直到写入完成
+	if submit.UserSeq == 0 {
+		ds.seqMu.Lock()
+		defer ds.seqMu.Unlock()
+		submit.UserSeq = ds.nextUserSeq(submit.User)
+	}
 	submit.LastUpdate = time.Now().UnixNano()
+	submit.ResultMemory = submit.JudgeResult.Memory
+	submit.ResultTime = submit.JudgeResult.Time
 	result := ds.db.Save(submit)
-	return result.Error
+	if result.Error != nil {
+		return result.Error
+	}
+	ds.recordStatus(submit)
+	return nil
+}
+
+// AfterFind 从数据库读取的提交以当前状态作为已记录的状态
+func (submit *SubmitCtx) AfterFind(tx *gorm.DB) error {
+	submit.recordedStatus = submit.Status
+	return nil
+}
+
+// recordStatus 状态与上次记录不同时写入状态变化事件
+func (ds *DatabaseService) recordStatus(submit *SubmitCtx) {
+	if submit.Status == submit.recordedStatus {
+		return
+	}
+	event := SubmitEvent{
+		SubmitID: submit.ID,
+		From:     submit.recordedStatus,
+		To:       submit.Status,
+		Time:     submit.LastUpdate,
+	}
+	if err := ds.db.Create(&event).Error; err != nil {
+		log.Error().Err(err).Str("id", submit.ID).Msg("failed to record submit event")
+		return
+	}
+	submit.recordedStatus = submit.Status
+}
+
+// GetSubmitEvents 获取提交的状态变化事件，按时间排序
+func (ds *DatabaseService) GetSubmitEvents(submitID string) ([]SubmitEvent, error) {
+	var events []SubmitEvent
+	result := ds.db.Where("submit_id = ?", submitID).Order("time asc, id asc").Find(&events)
+	return events, result.Error
 }
 
 // GetSubmitByID 根据ID获取提交记录
@@ -201,16 +570,61 @@ func (ds *DatabaseService) GetSubmitByID(submitID string) (*SubmitCtx, error) {
 	return &submit, nil
 }
 
+// GetSubmitByUserAndKey 根据用户和幂等键获取提交记录
+func (ds *DatabaseService) GetSubmitByUserAndKey(userID, key string) (*SubmitCtx, error) {
+	var submit SubmitCtx
+	result := ds.db.Where("user = ? AND idempotency_key = ?", userID, key).First(&submit)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &submit, nil
+}
+
+// FindIdenticalSubmit 查找用户在同一问题上提交文件完全相同的最近一次已评测提交，不存在时返回nil
+func (ds *DatabaseService) FindIdenticalSubmit(ctx *SubmitCtx) (*SubmitCtx, error) {
+	if len(ctx.SubmitsHashes) == 0 {
+		return nil, nil
+	}
+
+	var submits []SubmitCtx
+	result := ds.db.Where("user = ? AND problem = ? AND id <> ? AND status IN ?", ctx.User, ctx.Problem, ctx.ID, []string{"completed", "rejected"}).
+		Order("submit_time desc").
+		Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for i := range submits {
+		if submits[i].SubmitsHashes.SameFiles(ctx.SubmitsHashes) {
+			return &submits[i], nil
+		}
+	}
+	return nil, nil
+}
+
 // GetSubmitsByUser 获取用户的提交记录（分页）
 func (ds *DatabaseService) GetSubmitsByUser(userID string, page, limit int) ([]SubmitCtx, int64, error) {
+	return ds.GetSubmitsByUserFiltered(userID, "", "", page, limit)
+}
+
+// GetSubmitsByUserFiltered 分页获取用户的提交，status和problem非空时只返回匹配的提交
+func (ds *DatabaseService) GetSubmitsByUserFiltered(userID, status, problem string, page, limit int) ([]SubmitCtx, int64, error) {
 	var submits []SubmitCtx
 	var total int64
 
+	query := ds.db.Model(&SubmitCtx{}).Where("user = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if problem != "" {
+		query = query.Where("problem = ?", problem)
+	}
+
 	// 获取总数
-	ds.db.Model(&SubmitCtx{}).Where("user = ?", userID).Count(&total)
+	query.Count(&total)
 
 	// 获取分页数据
-	result := ds.db.Where("user = ?", userID).
+	result := query.
 		Order("id desc").
 		Offset((page - 1) * limit).
 		Limit(limit).
@@ -254,6 +668,76 @@ func (ds *DatabaseService) GetSubmitsForAPI(page, limit int) ([]SubmitCtx, int64
 	return submits, total, result.Error
 }
 
+// GetCompletedSubmitsByProblem 获取某问题所有有评测结果的提交（completed和rejected）
+func (ds *DatabaseService) GetCompletedSubmitsByProblem(problemID string) ([]SubmitCtx, error) {
+	var submits []SubmitCtx
+	result := ds.db.Where("problem = ? AND status IN ?", problemID, []string{"completed", "rejected"}).
+		Order("id asc").
+		Find(&submits)
+	return submits, result.Error
+}
+
+// GetStalledSubmits 获取未结束且LastUpdate早于before的提交
+func (ds *DatabaseService) GetStalledSubmits(before time.Time) ([]SubmitCtx, error) {
+	var submits []SubmitCtx
+	result := ds.db.Where("status NOT IN ? AND last_update < ?", FinalStatuses, before.UnixNano()).
+		Find(&submits)
+	return submits, result.Error
+}
+
+// GetWorkdirStatuses 获取所有提交的工作目录名及其状态
+func (ds *DatabaseService) GetWorkdirStatuses() (map[string]string, error) {
+	var submits []SubmitCtx
+	result := ds.db.Select("id", "status", "workdir").Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	dirs := make(map[string]string, len(submits))
+	for _, s := range submits {
+		if s.Workdir != "" {
+			dirs[path.Base(s.Workdir)] = s.Status
+		}
+	}
+	return dirs, nil
+}
+
+// GetRecentFinishedSubmits 获取最近评测结束的提交，只包含计算耗时所需的列
+func (ds *DatabaseService) GetRecentFinishedSubmits(limit int) ([]SubmitCtx, error) {
+	var submits []SubmitCtx
+	result := ds.db.Select("id", "status", "submit_time", "last_update", "workflow_results").
+		Where("status IN ?", []string{"completed", "rejected", "failed"}).
+		Order("id desc").
+		Limit(limit).
+		Find(&submits)
+	return submits, result.Error
+}
+
+// nextUserSeq 获取用户的下一个提交序号，调用时需持有seqMu
+func (ds *DatabaseService) nextUserSeq(userID string) int {
+	var last int
+	ds.db.Model(&SubmitCtx{}).Where("user = ?", userID).Select("COALESCE(MAX(user_seq), 0)").Scan(&last)
+	return last + 1
+}
+
+// GetSubmitByUserSeq 根据用户和用户提交序号获取提交
+func (ds *DatabaseService) GetSubmitByUserSeq(userID string, seq int) (*SubmitCtx, error) {
+	var submit SubmitCtx
+	result := ds.db.Where("user = ? AND user_seq = ?", userID, seq).First(&submit)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &submit, nil
+}
+
+// FindUserSubmit 根据用户提交序号（如 #5）或ID片段查找用户的提交
+func (ds *DatabaseService) FindUserSubmit(userID, ref string) (*SubmitCtx, error) {
+	if seq, ok := ParseUserSeq(ref); ok {
+		return ds.GetSubmitByUserSeq(userID, seq)
+	}
+	return ds.FindSubmitsByUserAndPattern(userID, ref)
+}
+
 // FindSubmitsByUserAndPattern 根据用户和模式查找提交（用于模糊搜索）
 func (ds *DatabaseService) FindSubmitsByUserAndPattern(userID, pattern string) (*SubmitCtx, error) {
 	var submit SubmitCtx
@@ -284,7 +768,7 @@ func (ds *DatabaseService) GetUserSubmitCount(userID string) (int64, error) {
 func (ds *DatabaseService) HasUserRunningSubmit(userID string) (bool, error) {
 	var count int64
 	result := ds.db.Model(&SubmitCtx{}).
-		Where("user = ? AND status != ? AND status != ? AND status != ?", userID, "completed", "failed", "dead").
+		Where("user = ? AND status NOT IN ?", userID, FinalStatuses).
 		Count(&count)
 	if result.Error != nil {
 		return false, result.Error
@@ -295,7 +779,7 @@ func (ds *DatabaseService) HasUserRunningSubmit(userID string) (bool, error) {
 // GetUserRunningSubmit 获取用户当前运行中的提交
 func (ds *DatabaseService) GetUserRunningSubmit(userID string) (*SubmitCtx, error) {
 	var submit SubmitCtx
-	result := ds.db.Where("user = ? AND status != ? AND status != ? AND status != ?", userID, "completed", "failed", "dead").
+	result := ds.db.Where("user = ? AND status NOT IN ?", userID, FinalStatuses).
 		Order("id desc").
 		First(&submit)
 	if result.Error != nil {
@@ -304,16 +788,87 @@ func (ds *DatabaseService) GetUserRunningSubmit(userID string) (*SubmitCtx, erro
 	return &submit, nil
 }
 
-// DeleteOldSubmits 删除旧的提交记录（可选功能）
-func (ds *DatabaseService) DeleteOldSubmits(beforeTime time.Time) error {
-	result := ds.db.Where("submit_time < ?", beforeTime.UnixNano()).Delete(&SubmitCtx{})
-	return result.Error
+// SubmitCooldown 获取用户距离可以再次提交该问题还需等待的时间，不需要等待时返回0
+// 冷却时间由问题的cooldown或全局的PerProblemCooldownSeconds决定，被看门狗终止的提交不计入
+func (ds *DatabaseService) SubmitCooldown(userID string, problem *Problem, now time.Time) (time.Duration, error) {
+	seconds := ds.cfg.PerProblemCooldownSeconds
+	if problem.Cooldown != nil {
+		seconds = *problem.Cooldown
+	}
+	if seconds <= 0 {
+		return 0, nil
+	}
+
+	var last *int64
+	result := ds.db.Model(&SubmitCtx{}).
+		Where("user = ? AND problem = ? AND status <> ?", userID, problem.Id, "dead").
+		Select("MAX(submit_time)").
+		Scan(&last)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if last == nil {
+		return 0, nil
+	}
+
+	remaining := time.Unix(0, *last).Add(time.Duration(seconds) * time.Second).Sub(now)
+	return max(remaining, 0), nil
+}
+
+// deleteBatchSize 批量删除提交时每条语句的ID数量，避免超过SQLite的参数数量限制
+const deleteBatchSize = 500
+
+// DeleteOldSubmits 删除beforeTime之前提交且已结束评测的提交记录，保留用户当前的最佳提交
+// 返回被删除的提交（只包含id、user和workdir列），便于清理工作目录和重新计算受影响的用户
+func (ds *DatabaseService) DeleteOldSubmits(beforeTime time.Time) ([]SubmitCtx, error) {
+	var users []User
+	if err := ds.db.Select("id", "best_submits").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	best := make(map[string]bool)
+	for _, u := range users {
+		for _, id := range u.BestSubmits {
+			best[id] = true
+		}
+	}
+
+	var candidates []SubmitCtx
+	result := ds.db.Select("id", "user", "workdir").
+		Where("submit_time < ? AND status IN ?", beforeTime.UnixNano(), FinalStatuses).
+		Find(&candidates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var deleted []SubmitCtx
+	for _, s := range candidates {
+		if !best[s.ID] {
+			deleted = append(deleted, s)
+		}
+	}
+
+	for start := 0; start < len(deleted); start += deleteBatchSize {
+		var ids []string
+		for _, s := range deleted[start:min(start+deleteBatchSize, len(deleted))] {
+			ids = append(ids, s.ID)
+		}
+		if err := ds.db.Where("id IN ?", ids).Delete(&SubmitCtx{}).Error; err != nil {
+			return deleted[:start], err
+		}
+		if err := ds.db.Where("submit_id IN ?", ids).Delete(&SubmitEvent{}).Error; err != nil {
+			return deleted[:start], err
+		}
+	}
+	return deleted, nil
 }
 
 // DeleteSubmitByID 删除指定ID的提交记录（简单版本，不重新计算权重）
 func (ds *DatabaseService) DeleteSubmitByID(submitID string) error {
 	result := ds.db.Where("id = ?", submitID).Delete(&SubmitCtx{})
-	return result.Error
+	if result.Error != nil {
+		return result.Error
+	}
+	return ds.db.Where("submit_id = ?", submitID).Delete(&SubmitEvent{}).Error
 }
 
 // DeleteSubmitByIDWithProblems 删除指定ID的提交记录并更新用户加权分数
@@ -325,9 +880,9 @@ func (ds *DatabaseService) DeleteSubmitByIDWithProblems(submitID string, problem
 	}
 
 	// 删除提交记录
-	result := ds.db.Where("id = ?", submitID).Delete(&SubmitCtx{})
-	if result.Error != nil {
-		return result.Error
+	err = ds.DeleteSubmitByID(submitID)
+	if err != nil {
+		return err
 	}
 
 	// 重新计算受影响用户的最佳记录（包含权重计算）
@@ -353,26 +908,12 @@ func (ds *DatabaseService) RecalculateUserBestScoresWithProblems(userID string,
 
 	// 重新计算每个问题的最佳分数
 	for _, submit := range submits {
-		if submit.JudgeResult.Success {
-			problemID := submit.Problem
-			problem, exists := problems[problemID]
-			if !exists {
-				continue // 跳过不存在的问题
-			}
-
-			weightedScore := submit.JudgeResult.Score * problem.Weight
-			currentBest, exists := user.BestScores[problemID]
-
-			if !exists || weightedScore > currentBest {
-				user.BestScores[problemID] = weightedScore
-				user.BestSubmits[problemID] = submit.ID
-				user.BestSubmitDate[problemID] = submit.SubmitTime
-			}
+		// 跳过不存在的问题
+		if problem, exists := problems[submit.Problem]; exists {
+			user.applyBestSubmit(&submit, &problem)
 		}
 	}
 
-	user.CalculateTotalScore()
-
 	// 保存更新后的用户记录
 	return ds.UpdateUser(user)
 }
@@ -390,6 +931,9 @@ func (ds *DatabaseService) ModifySubmissionResult(submitID string, score float64
 	submit.JudgeResult.Success = score > 0
 	submit.Msg = message
 	submit.Status = "completed"
+	if !submit.JudgeResult.Success {
+		submit.Status = "rejected"
+	}
 
 	// 保存更新后的提交记录
 	err = ds.UpdateSubmit(submit)
@@ -401,6 +945,225 @@ func (ds *DatabaseService) ModifySubmissionResult(submitID string, score float64
 	return ds.RecalculateUserBestScoresWithProblems(submit.User, problems)
 }
 
+// GetAttempts 按提交时间统计每个用户在每个问题上首次通过前的尝试次数，userID为空时统计所有用户
+// 进行中和dead的提交不计入尝试次数
+func (ds *DatabaseService) GetAttempts(userID string) ([]ProblemAttempts, error) {
+	var submits []SubmitCtx
+	query := ds.db.Select("id", "user", "problem", "submit_time", "status", "judge_result").
+		Where("status IN ?", []string{"completed", "rejected", "failed"})
+	if userID != "" {
+		query = query.Where("user = ?", userID)
+	}
+	result := query.Order("submit_time asc").Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	attempts := make(map[[2]string]*ProblemAttempts)
+	var keys [][2]string
+
+	for _, s := range submits {
+		key := [2]string{s.User, s.Problem}
+		a, ok := attempts[key]
+		if !ok {
+			a = &ProblemAttempts{User: s.User, Problem: s.Problem}
+			attempts[key] = a
+			keys = append(keys, key)
+		}
+		if a.Solved {
+			continue
+		}
+		if s.Status == "completed" && s.JudgeResult.Success {
+			a.Solved = true
+			a.FirstSolveID = s.ID
+			a.FirstSolveTime = s.SubmitTime
+		} else {
+			a.Attempts++
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	res := make([]ProblemAttempts, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, *attempts[key])
+	}
+	return res, nil
+}
+
+// GetSolutionsForProblem 获取其他用户在某问题上的最佳提交，按加权分数从高到低排列
+func (ds *DatabaseService) GetSolutionsForProblem(problemID, excludeUser string, limit int) ([]SubmitCtx, error) {
+	users, err := ds.GetAllUsersOrderedByScore()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].BestScores[problemID] > users[j].BestScores[problemID]
+	})
+
+	var submits []SubmitCtx
+	for _, u := range users {
+		if len(submits) >= limit {
+			break
+		}
+		if u.ID == excludeUser {
+			continue
+		}
+		id, ok := u.BestSubmits[problemID]
+		if !ok {
+			continue
+		}
+		submit, err := ds.GetSubmitByID(id)
+		if err != nil {
+			continue
+		}
+		submits = append(submits, *submit)
+	}
+	return submits, nil
+}
+
+// GetUserBestSubmits 获取用户每个问题的最佳提交，按问题ID排序
+func (ds *DatabaseService) GetUserBestSubmits(userID string) ([]BestSubmit, error) {
+	user, err := ds.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(user.BestSubmits) == 0 {
+		return []BestSubmit{}, nil
+	}
+
+	var submits []SubmitCtx
+	result := ds.db.Select("id", "user_seq", "submit_time").
+		Where("id IN ?", slices.Collect(maps.Values(user.BestSubmits))).
+		Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	byID := make(map[string]*SubmitCtx, len(submits))
+	for i := range submits {
+		byID[submits[i].ID] = &submits[i]
+	}
+
+	factor := ds.ScoreFactor()
+	best := make([]BestSubmit, 0, len(user.BestSubmits))
+	for _, problem := range slices.Sorted(maps.Keys(user.BestSubmits)) {
+		id := user.BestSubmits[problem]
+		s, ok := byID[id]
+		if !ok {
+			continue
+		}
+		best = append(best, BestSubmit{
+			Problem:    problem,
+			SubmitID:   id,
+			UserSeq:    s.UserSeq,
+			Score:      RoundScore(user.BestScores[problem] * factor),
+			SubmitTime: s.SubmitTime,
+		})
+	}
+	return best, nil
+}
+
+// GetUserScoreHistory 按时间顺序计算用户每次成功提交后的最佳总分
+func (ds *DatabaseService) GetUserScoreHistory(userID string, problems map[string]Problem) ([]HistoryPoint, error) {
+	var submits []SubmitCtx
+	result := ds.db.Select("id", "user", "problem", "submit_time", "status", "judge_result").
+		Where("user = ? AND status = ?", userID, "completed").
+		Order("submit_time asc").
+		Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	best := make(map[string]float64)
+	factor := ds.ScoreFactor()
+	var history []HistoryPoint
+
+	for _, s := range submits {
+		if !s.JudgeResult.Success {
+			continue
+		}
+		problem, exists := problems[s.Problem]
+		if !exists {
+			continue
+		}
+
+		score := s.JudgeResult.Score * problem.Weight
+		if current, ok := best[s.Problem]; !ok || score > current {
+			best[s.Problem] = score
+		}
+
+		var total float64
+		for _, k := range slices.Sorted(maps.Keys(best)) {
+			total += best[k]
+		}
+
+		history = append(history, HistoryPoint{
+			Time:       s.SubmitTime,
+			SubmitID:   s.ID,
+			Problem:    s.Problem,
+			Score:      RoundScore(score * factor),
+			TotalScore: RoundScore(total * factor),
+		})
+	}
+
+	return history, nil
+}
+
+// GetResourceTrends 按天统计某问题已完成提交的平均内存和时间
+func (ds *DatabaseService) GetResourceTrends(problemID string) ([]ResourceTrend, error) {
+	var trends []ResourceTrend
+	result := ds.db.Model(&SubmitCtx{}).
+		Select("date(submit_time / 1000000000, 'unixepoch') AS day, count(*) AS count, avg(result_memory) AS avg_memory, avg(result_time) AS avg_time").
+		Where("problem = ? AND status IN ?", problemID, []string{"completed", "rejected"}).
+		Group("day").
+		Order("day asc").
+		Scan(&trends)
+	return trends, result.Error
+}
+
+// GetFirstSolves 获取每个问题最早的成功提交（一血），按问题ID排序
+func (ds *DatabaseService) GetFirstSolves() ([]FirstSolve, error) {
+	var submits []SubmitCtx
+	result := ds.db.Select("id", "user", "problem", "submit_time", "status", "judge_result").
+		Where("status = ?", "completed").
+		Order("submit_time asc").
+		Find(&submits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	firsts := make(map[string]FirstSolve)
+	for _, s := range submits {
+		if !s.JudgeResult.Success {
+			continue
+		}
+		if _, ok := firsts[s.Problem]; ok {
+			continue
+		}
+		firsts[s.Problem] = FirstSolve{
+			Problem:    s.Problem,
+			User:       s.User,
+			SubmitID:   s.ID,
+			SubmitTime: s.SubmitTime,
+		}
+	}
+
+	res := make([]FirstSolve, 0, len(firsts))
+	for _, f := range firsts {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Problem < res[j].Problem
+	})
+	return res, nil
+}
+
 // GetSubmitStatistics 获取提交统计信息
 func (ds *DatabaseService) GetSubmitStatistics() (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
@@ -420,10 +1183,20 @@ func (ds *DatabaseService) GetSubmitStatistics() (map[string]interface{}, error)
 	ds.db.Model(&SubmitCtx{}).Where("status = ?", "failed").Count(&failedSubmits)
 	stats["failed_submits"] = failedSubmits
 
+	// 评测结果为失败的提交数
+	var rejectedSubmits int64
+	ds.db.Model(&SubmitCtx{}).Where("status = ?", "rejected").Count(&rejectedSubmits)
+	stats["rejected_submits"] = rejectedSubmits
+
 	// 总用户数
 	var totalUsers int64
 	ds.db.Model(&User{}).Count(&totalUsers)
 	stats["total_users"] = totalUsers
 
+	// 最近24小时提交数
+	var recentSubmits int64
+	ds.db.Model(&SubmitCtx{}).Where("submit_time >= ?", time.Now().Add(-24*time.Hour).UnixNano()).Count(&recentSubmits)
+	stats["submits_24h"] = recentSubmits
+
 	return stats, nil
 }
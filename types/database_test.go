@@ -0,0 +1,292 @@
+package types
+
+import (
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDB 在临时目录中创建sqlite数据库
+func newTestDB(t *testing.T) *DatabaseService {
+	t.Helper()
+	ds, err := NewDatabaseService(&Config{SqlitePath: path.Join(t.TempDir(), "soj.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestGetUserByIDConcurrentFirstConnection(t *testing.T) {
+	ds := newTestDB(t)
+
+	const n = 16
+	var wg sync.WaitGroup
+	users := make([]*User, n)
+	errs := make([]error, n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			users[i], errs[i] = ds.GetUserByID("newcomer")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Fatalf("goroutine %d: %v", i, errs[i])
+		}
+		if users[i].Token == "" || users[i].Token != users[0].Token {
+			t.Errorf("goroutine %d got token %q, goroutine 0 got %q", i, users[i].Token, users[0].Token)
+		}
+	}
+
+	stored, err := ds.LookupUser("newcomer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Token != users[0].Token {
+		t.Errorf("stored token %q differs from returned token %q", stored.Token, users[0].Token)
+	}
+}
+
+// seedSubmit 写入一个已结束的提交，success表示评测结果是否成功
+func seedSubmit(t *testing.T, ds *DatabaseService, id, user, problem string, at int64, status string, success bool) {
+	t.Helper()
+	s := &SubmitCtx{
+		ID:         id,
+		User:       user,
+		Problem:    problem,
+		SubmitTime: at,
+		Status:     status,
+	}
+	s.JudgeResult.Success = success
+	if success {
+		s.JudgeResult.Score = 100
+	}
+	if err := ds.CreateSubmit(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetAttempts(t *testing.T) {
+	ds := newTestDB(t)
+
+	// alice: 编译错误、答案错误、失败和dead之后通过，通过后的提交不再计数
+	seedSubmit(t, ds, "a1", "alice", "p1", 1, "rejected", false)
+	seedSubmit(t, ds, "a2", "alice", "p1", 2, "completed", false)
+	seedSubmit(t, ds, "a3", "alice", "p1", 3, "failed", false)
+	seedSubmit(t, ds, "a4", "alice", "p1", 4, "dead", false)
+	seedSubmit(t, ds, "a5", "alice", "p1", 5, "completed", true)
+	seedSubmit(t, ds, "a6", "alice", "p1", 6, "rejected", false)
+	seedSubmit(t, ds, "a7", "alice", "p1", 7, "completed", true)
+	// alice 在p2上首次即通过
+	seedSubmit(t, ds, "a8", "alice", "p2", 8, "completed", true)
+	// bob 始终没有通过
+	seedSubmit(t, ds, "b1", "bob", "p1", 3, "completed", false)
+	seedSubmit(t, ds, "b2", "bob", "p1", 9, "rejected", false)
+	seedSubmit(t, ds, "b3", "bob", "p1", 10, "dead", false)
+	// 只有dead提交的问题不出现在结果中
+	seedSubmit(t, ds, "b4", "bob", "p2", 11, "dead", false)
+
+	want := []ProblemAttempts{
+		{User: "alice", Problem: "p1", Attempts: 3, Solved: true, FirstSolveID: "a5", FirstSolveTime: 5},
+		{User: "alice", Problem: "p2", Attempts: 0, Solved: true, FirstSolveID: "a8", FirstSolveTime: 8},
+		{User: "bob", Problem: "p1", Attempts: 2},
+	}
+
+	got, err := ds.GetAttempts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAttempts(\"\") =\n%+v\nwant\n%+v", got, want)
+	}
+
+	got, err = ds.GetAttempts("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want[2:]) {
+		t.Errorf("GetAttempts(bob) = %+v, want %+v", got, want[2:])
+	}
+}
+
+func TestScoreFactorUsesScoreRange(t *testing.T) {
+	for _, full := range []float64{50, 100, 150} {
+		ds, err := NewDatabaseService(&Config{
+			SqlitePath:       path.Join(t.TempDir(), "soj.db"),
+			NormalizeTotalTo: 1000,
+			MaxScore:         &full,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.SetProblems(map[string]Problem{"a": {Id: "a", Weight: 1}, "b": {Id: "b", Weight: 3}}); err != nil {
+			t.Fatal(err)
+		}
+
+		// 满分提交的总分等于NormalizeTotalTo
+		for _, p := range []struct {
+			id     string
+			weight float64
+		}{{"a", 1}, {"b", 3}} {
+			s := &SubmitCtx{ID: p.id, User: "alice", Problem: p.id, Status: "completed"}
+			s.JudgeResult.Success = true
+			s.JudgeResult.Score = full
+			if err := ds.UpdateUserSubmitResult("alice", s, &Problem{Id: p.id, Weight: p.weight}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		u, err := ds.GetUserByID("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.TotalScore != 1000 {
+			t.Errorf("MaxScore %v: full marks total = %v, want 1000", full, u.TotalScore)
+		}
+		if got := ds.MaxTotalScore(); got != 1000 {
+			t.Errorf("MaxScore %v: MaxTotalScore = %v, want 1000", full, got)
+		}
+	}
+}
+
+func TestSetProblemsConcurrentScoreFactor(t *testing.T) {
+	ds, err := NewDatabaseService(&Config{SqlitePath: path.Join(t.TempDir(), "soj.db"), NormalizeTotalTo: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if f := ds.ScoreFactor(); f != 1 && f != 0.25 {
+					t.Errorf("ScoreFactor = %v", f)
+					return
+				}
+				ds.MaxTotalScore()
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		problems := map[string]Problem{"a": {Id: "a", Weight: 1}}
+		if i%2 == 1 {
+			problems["b"] = Problem{Id: "b", Weight: 3}
+		}
+		if err := ds.SetProblems(problems); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestBestScoresConsistentAcrossRecalculations(t *testing.T) {
+	ds := newTestDB(t)
+	problems := map[string]Problem{"p1": {Id: "p1", Weight: 1}, "p2": {Id: "p2", Weight: 2}}
+	if err := ds.SetProblems(problems); err != nil {
+		t.Fatal(err)
+	}
+
+	submits := []struct {
+		id, user, problem string
+		status            string
+		success           bool
+		score             float64
+	}{
+		// 0分的成功提交不算通过
+		{"a1", "alice", "p1", "completed", true, 0},
+		{"a2", "alice", "p2", "completed", true, 40},
+		// 同分的后续提交不替换最佳提交
+		{"a3", "alice", "p2", "completed", true, 40},
+		{"a4", "alice", "p2", "completed", false, 90},
+		{"a5", "alice", "p2", "failed", true, 95},
+		{"b1", "bob", "p1", "completed", true, 70},
+		{"b2", "bob", "p1", "completed", true, 100},
+		{"b3", "bob", "p3", "completed", true, 100},
+	}
+
+	for i, sub := range submits {
+		s := &SubmitCtx{ID: sub.id, User: sub.user, Problem: sub.problem, SubmitTime: int64(i + 1), Status: sub.status}
+		s.JudgeResult.Success = sub.success
+		s.JudgeResult.Score = sub.score
+		if err := ds.CreateSubmit(s); err != nil {
+			t.Fatal(err)
+		}
+		if pb, ok := problems[sub.problem]; ok {
+			if err := ds.UpdateUserSubmitResult(sub.user, s, &pb); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	want := map[string]map[string]string{
+		"alice": {"p2": "a2"},
+		"bob":   {"p1": "b2"},
+	}
+
+	// check 比较用户的最佳提交
+	check := func(how string, users []User) {
+		t.Helper()
+		for _, u := range users {
+			if !reflect.DeepEqual(map[string]string(u.BestSubmits), want[u.ID]) {
+				t.Errorf("%s: %s best submits = %v, want %v", how, u.ID, u.BestSubmits, want[u.ID])
+			}
+			if len(u.BestScores) != len(want[u.ID]) {
+				t.Errorf("%s: %s solved %d problems, want %d", how, u.ID, len(u.BestScores), len(want[u.ID]))
+			}
+		}
+	}
+
+	live, err := ds.GetAllUsersOrderedByScore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("live", live)
+
+	frozen, err := ds.GetUsersOrderedByScoreBefore(time.Unix(0, 100), problems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("frozen", frozen)
+	for i := range live {
+		if live[i].ID != frozen[i].ID || live[i].TotalScore != frozen[i].TotalScore {
+			t.Errorf("rank %d: live %s %v, frozen %s %v", i+1, live[i].ID, live[i].TotalScore, frozen[i].ID, frozen[i].TotalScore)
+		}
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		if err := ds.RecalculateUserBestScoresWithProblems(user, problems); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recalculated, err := ds.GetAllUsersOrderedByScore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("recalculated", recalculated)
+
+	if _, err := ds.DoFullUserScan(problems); err != nil {
+		t.Fatal(err)
+	}
+	scanned, err := ds.GetAllUsersOrderedByScore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("full scan", scanned)
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"errors"
+	"slices"
+)
+
+// 未分组用户可访问的问题范围
+const (
+	UngroupedAll  = "all"
+	UngroupedNone = "none"
+)
+
+// GroupExists 判断配置中是否存在该问题分组
+func (cfg *Config) GroupExists(group string) bool {
+	_, ok := cfg.ProblemGroups[group]
+	return ok
+}
+
+// CanAccessProblem 判断用户能否查看和提交问题
+// 未配置ProblemGroups时所有用户均可访问，管理员不受分组限制
+// 未分组的用户按UngroupedAccess处理，默认可访问所有问题
+func (ds *DatabaseService) CanAccessProblem(userID, problemID string) bool {
+	if len(ds.cfg.ProblemGroups) == 0 || ds.IsAdmin(userID) {
+		return true
+	}
+
+	var group string
+	if user, err := ds.LookupUser(userID); err == nil {
+		group = user.Group
+	}
+
+	problems, ok := ds.cfg.ProblemGroups[group]
+	if !ok {
+		return ds.cfg.UngroupedAccess != UngroupedNone
+	}
+	return slices.Contains(problems, problemID)
+}
+
+// SetUserGroup 设置用户的问题分组，group为空时取消分组
+func (ds *DatabaseService) SetUserGroup(userID, group string) error {
+	if group != "" && !ds.cfg.GroupExists(group) {
+		return errors.New("unknown group " + group)
+	}
+	user, err := ds.LookupUser(userID)
+	if err != nil {
+		return err
+	}
+	defer ds.invalidateRank()
+	return ds.db.Model(user).Update("group", group).Error
+}
@@ -0,0 +1,79 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// pseudonymSaltKey 自动生成的化名盐值在设置表中的键，不通过 adm setting 暴露
+const pseudonymSaltKey = "pseudonym_salt"
+
+var pseudonymAdjectives = []string{
+	"Amber", "Brave", "Calm", "Clever", "Crimson", "Eager", "Gentle", "Golden",
+	"Happy", "Jolly", "Lucky", "Mellow", "Nimble", "Quiet", "Swift", "Witty",
+}
+
+var pseudonymAnimals = []string{
+	"Badger", "Crane", "Dolphin", "Falcon", "Fox", "Heron", "Koala", "Lynx",
+	"Otter", "Owl", "Panda", "Puffin", "Raven", "Seal", "Tiger", "Wombat",
+}
+
+// pseudonym 根据盐值和用户ID生成稳定的化名，如 SwiftOtter42
+func pseudonym(salt, userID string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(userID))
+	sum := mac.Sum(nil)
+
+	n := binary.BigEndian.Uint32(sum)
+	return pseudonymAdjectives[sum[4]%byte(len(pseudonymAdjectives))] +
+		pseudonymAnimals[sum[5]%byte(len(pseudonymAnimals))] +
+		strconv.Itoa(int(n%100))
+}
+
+// loadPseudonymSalt 获取化名盐值，优先使用配置，否则使用数据库中保存的随机值
+func (ds *DatabaseService) loadPseudonymSalt() string {
+	if ds.cfg.PseudonymSalt != "" {
+		return ds.cfg.PseudonymSalt
+	}
+	salt, ok, err := ds.GetSetting(pseudonymSaltKey)
+	if err == nil && ok && salt != "" {
+		return salt
+	}
+	salt = hex.EncodeToString([]byte(uuid.New().String()))
+	if err := ds.SetSetting(pseudonymSaltKey, salt); err != nil {
+		return ""
+	}
+	return salt
+}
+
+// Pseudonym 获取用户在公开排行榜上的稳定化名
+func (ds *DatabaseService) Pseudonym(userID string) string {
+	return pseudonym(ds.pseudonymSalt, userID)
+}
+
+// RankName 获取viewer在排行榜上看到的用户名
+// 开启AnonymizeRank或用户选择匿名时使用化名，管理员和用户本人看到真实用户名
+func (ds *DatabaseService) RankName(u User, viewer string) string {
+	if !ds.cfg.AnonymizeRank && !u.Anonymous {
+		return u.ID
+	}
+	if u.ID == viewer || ds.IsAdmin(viewer) {
+		return u.ID
+	}
+	return ds.Pseudonym(u.ID)
+}
+
+// SetUserAnonymous 设置用户是否在排行榜上匿名
+func (ds *DatabaseService) SetUserAnonymous(userID string, anonymous bool) error {
+	user, err := ds.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	defer ds.invalidateRank()
+	return ds.db.Model(user).Update("anonymous", anonymous).Error
+}
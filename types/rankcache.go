@@ -0,0 +1,27 @@
+package types
+
+import "slices"
+
+// GetRankSnapshot 获取按分数排序的用户列表及其版本号，结果在用户数据变化前保持缓存
+func (ds *DatabaseService) GetRankSnapshot() ([]User, uint64, error) {
+	ds.rankMu.Lock()
+	defer ds.rankMu.Unlock()
+
+	if ds.rankUsers == nil {
+		users, err := ds.GetAllUsersOrderedByScore()
+		if err != nil {
+			return nil, 0, err
+		}
+		ds.rankUsers = users
+	}
+
+	return slices.Clone(ds.rankUsers), ds.rankVersion, nil
+}
+
+// invalidateRank 用户数据变化后使排行榜缓存失效
+func (ds *DatabaseService) invalidateRank() {
+	ds.rankMu.Lock()
+	ds.rankUsers = nil
+	ds.rankVersion++
+	ds.rankMu.Unlock()
+}
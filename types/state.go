@@ -0,0 +1,146 @@
+package types
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 设置表中可修改的键
+const (
+	SettingPaused       = "paused"
+	SettingMaintenance  = "maintenance"
+	SettingContestStart = "contest_start"
+	SettingContestEnd   = "contest_end"
+	SettingFreezeTime   = "freeze_time"
+)
+
+// SettingKeys 所有可修改的设置
+var SettingKeys = []string{SettingPaused, SettingMaintenance, SettingContestStart, SettingContestEnd, SettingFreezeTime}
+
+// ErrUnknownSetting 未知的设置键
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// RuntimeState 运行时可切换的全局状态，由SSH和HTTP共享
+type RuntimeState struct {
+	cfg         *Config
+	dbService   *DatabaseService
+	maintenance atomic.Bool
+	paused      atomic.Bool
+}
+
+// NewRuntimeState 创建运行时状态，并从设置表恢复持久化的状态
+// 设置表中的比赛时间和封榜时间覆盖config.yaml中的值
+func NewRuntimeState(cfg *Config, dbService *DatabaseService) *RuntimeState {
+	st := &RuntimeState{cfg: cfg, dbService: dbService}
+
+	settings, err := dbService.GetAllSettings()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load settings")
+		return st
+	}
+	for _, s := range settings {
+		err := st.apply(s.Key, s.Value)
+		if err != nil {
+			log.Error().Err(err).Str("key", s.Key).Str("value", s.Value).Msg("ignored invalid setting")
+			continue
+		}
+		log.Info().Str("key", s.Key).Str("value", s.Value).Msg("loaded setting")
+	}
+
+	return st
+}
+
+// apply 将设置应用到内存状态
+func (st *RuntimeState) apply(key, value string) error {
+	switch key {
+	case SettingPaused, SettingMaintenance:
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		if key == SettingPaused {
+			st.paused.Store(on)
+		} else {
+			st.maintenance.Store(on)
+		}
+	case SettingContestStart, SettingContestEnd, SettingFreezeTime:
+		var t time.Time
+		if value != "" {
+			var err error
+			t, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return err
+			}
+		}
+		switch key {
+		case SettingContestStart:
+			st.cfg.ContestStart = t
+		case SettingContestEnd:
+			st.cfg.ContestEnd = t
+		default:
+			st.cfg.FreezeTime = t
+		}
+	default:
+		return ErrUnknownSetting
+	}
+	return nil
+}
+
+// Get 获取设置的当前值
+func (st *RuntimeState) Get(key string) (string, error) {
+	switch key {
+	case SettingPaused:
+		return strconv.FormatBool(st.Paused()), nil
+	case SettingMaintenance:
+		return strconv.FormatBool(st.Maintenance()), nil
+	case SettingContestStart:
+		return formatSettingTime(st.cfg.ContestStart), nil
+	case SettingContestEnd:
+		return formatSettingTime(st.cfg.ContestEnd), nil
+	case SettingFreezeTime:
+		return formatSettingTime(st.cfg.FreezeTime), nil
+	}
+	return "", ErrUnknownSetting
+}
+
+// Set 校验并应用设置，随后持久化到设置表
+// 时间使用RFC3339格式，空字符串表示不限制
+func (st *RuntimeState) Set(key, value string) error {
+	err := st.apply(key, value)
+	if err != nil {
+		return err
+	}
+	return st.dbService.SetSetting(key, value)
+}
+
+// formatSettingTime 格式化时间设置，零值为空字符串
+func formatSettingTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Maintenance 是否处于维护模式
+func (st *RuntimeState) Maintenance() bool {
+	return st.maintenance.Load()
+}
+
+// SetMaintenance 设置维护模式并持久化，维护期间API对非管理员返回503，SSH拒绝新的提交，运行中的评测不受影响
+func (st *RuntimeState) SetMaintenance(on bool) error {
+	return st.Set(SettingMaintenance, strconv.FormatBool(on))
+}
+
+// Paused 是否暂停接受提交
+func (st *RuntimeState) Paused() bool {
+	return st.paused.Load()
+}
+
+// SetPaused 设置暂停状态并持久化
+func (st *RuntimeState) SetPaused(paused bool) error {
+	return st.Set(SettingPaused, strconv.FormatBool(paused))
+}
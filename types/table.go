@@ -0,0 +1,130 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/logrusorgru/aurora/v4"
+)
+
+// Align 表格列的对齐方式
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignRight
+)
+
+// Column 表格列
+type Column struct {
+	Title    string
+	Color    aurora.Color // 单元格没有颜色时使用的颜色
+	Align    Align
+	Format   string // 单元格值的格式，默认为%v
+	MaxWidth int    // 大于0时超出部分被截断并以...结尾
+}
+
+// Table 按列宽对齐输出的终端表格，列宽按去掉颜色后的字符数计算
+type Table struct {
+	Columns []Column
+	rows    [][]tableCell
+}
+
+type tableCell struct {
+	text  string
+	color aurora.Color
+}
+
+// NewTable 创建表格
+func NewTable(cols ...Column) *Table {
+	return &Table{Columns: cols}
+}
+
+// AddRow 添加一行，单元格为aurora.Value时使用其颜色并按列的Format格式化其中的值
+func (t *Table) AddRow(cells ...interface{}) {
+	row := make([]tableCell, len(t.Columns))
+	for i, col := range t.Columns {
+		if i >= len(cells) {
+			break
+		}
+
+		var raw = cells[i]
+		var color = col.Color
+		if v, ok := raw.(aurora.Value); ok {
+			raw = v.Value()
+			if v.Color() != 0 {
+				color = v.Color()
+			}
+		}
+
+		format := col.Format
+		if format == "" {
+			format = "%v"
+		}
+		row[i] = tableCell{text: truncate(fmt.Sprintf(format, raw), col.MaxWidth), color: color}
+	}
+	t.rows = append(t.rows, row)
+}
+
+// Len 获取表格行数
+func (t *Table) Len() int {
+	return len(t.rows)
+}
+
+// Render 输出表头和所有行
+func (t *Table) Render(w io.Writer) {
+	widths := make([]int, len(t.Columns))
+	for i, col := range t.Columns {
+		widths[i] = utf8.RuneCountInString(col.Title)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell.text))
+		}
+	}
+
+	var b strings.Builder
+	for i, col := range t.Columns {
+		b.WriteString(pad(col.Title, widths[i], col.Align))
+		b.WriteByte(' ')
+	}
+	b.WriteByte('\n')
+
+	for _, row := range t.rows {
+		for i, cell := range row {
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell.text))
+			text := aurora.Colorize(cell.text, cell.color).String()
+			if t.Columns[i].Align == AlignRight {
+				b.WriteString(padding + text)
+			} else {
+				b.WriteString(text + padding)
+			}
+			b.WriteByte(' ')
+		}
+		b.WriteByte('\n')
+	}
+
+	io.WriteString(w, b.String())
+}
+
+// pad 按对齐方式将s填充到width个字符
+func pad(s string, width int, align Align) string {
+	padding := strings.Repeat(" ", max(width-utf8.RuneCountInString(s), 0))
+	if align == AlignRight {
+		return padding + s
+	}
+	return s + padding
+}
+
+// truncate 将s截断到n个字符以内，n<=0时不截断
+func truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string([]rune(s)[:n])
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
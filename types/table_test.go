@@ -0,0 +1,101 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/logrusorgru/aurora/v4"
+)
+
+// renderLines 输出表格并按行拆分，plain为去掉颜色后的内容
+func renderLines(t *testing.T, tb *Table) (colored, plain []string) {
+	t.Helper()
+	var b strings.Builder
+	tb.Render(&b)
+	out := strings.TrimSuffix(b.String(), "\n")
+	return strings.Split(out, "\n"), strings.Split(SanitizeTerminal(out, false), "\n")
+}
+
+func TestTableAlign(t *testing.T) {
+	tb := NewTable(
+		Column{Title: "ID"},
+		Column{Title: "Score", Align: AlignRight, Format: "%.1f"},
+		Column{Title: "Name"},
+	)
+	tb.AddRow("a", 5.0, "alice")
+	tb.AddRow("long-id", 100.0, "b")
+
+	_, plain := renderLines(t, tb)
+	want := []string{
+		"ID      Score Name  ",
+		"a         5.0 alice ",
+		"long-id 100.0 b     ",
+	}
+	if strings.Join(plain, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rendered\n%s\nwant\n%s", strings.Join(plain, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTableTruncate(t *testing.T) {
+	tb := NewTable(Column{Title: "Path", MaxWidth: 8}, Column{Title: "X", MaxWidth: 2})
+	tb.AddRow("src/main.go", "abc")
+	tb.AddRow("a.go", "é")
+	tb.AddRow("目录/文件/很长.go", "xy")
+
+	_, plain := renderLines(t, tb)
+	want := []string{
+		"Path     X  ",
+		"src/m... ab ",
+		"a.go     é  ",
+		"目录/文件... xy ",
+	}
+	if strings.Join(plain, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rendered\n%s\nwant\n%s", strings.Join(plain, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, tt := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 0, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 4, "h..."},
+		{"hello", 3, "hel"},
+		{"hello", 1, "h"},
+	} {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTableColoredCells(t *testing.T) {
+	tb := NewTable(
+		Column{Title: "Status", Color: aurora.BlueFg},
+		Column{Title: "Score", Align: AlignRight, Format: "%.2f"},
+		Column{Title: "End"},
+	)
+	tb.AddRow(aurora.Green("ok"), aurora.Red(12.5), "|")
+	tb.AddRow("failed", 3.0, "|")
+	tb.AddRow(aurora.Bold(aurora.Yellow("pending")), aurora.Bold(100.0), "|")
+
+	colored, plain := renderLines(t, tb)
+	want := []string{
+		"Status   Score End ",
+		"ok       12.50 |   ",
+		"failed    3.00 |   ",
+		"pending 100.00 |   ",
+	}
+	if strings.Join(plain, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rendered\n%s\nwant\n%s", strings.Join(plain, "\n"), strings.Join(want, "\n"))
+	}
+
+	// 单元格自带的颜色优先于列的颜色
+	if !strings.Contains(colored[1], aurora.Green("ok").String()) {
+		t.Errorf("line 1 %q lost the cell color", colored[1])
+	}
+	if !strings.Contains(colored[2], aurora.Blue("failed").String()) {
+		t.Errorf("line 2 %q does not use the column color", colored[2])
+	}
+}
@@ -0,0 +1,88 @@
+package types
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// SanitizeTerminal 去掉s中的终端控制字符和转义序列，保留换行和制表符，无效的UTF-8替换为U+FFFD
+// 用户或评测程序产生的内容可能包含移动光标、改写标题、清屏等转义序列，直接输出到终端会伪造或隐藏信息
+// keepSGR为true时保留设置颜色的SGR序列（ESC [ ... m），用于重放评测输出
+func SanitizeTerminal(s string, keepSGR bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b.WriteRune(utf8.RuneError)
+			i++
+		case r == 0x1b:
+			n, sgr := escapeSequence(s[i:])
+			if keepSGR && sgr {
+				b.WriteString(s[i : i+n])
+			}
+			i += n
+		case r == '\r':
+			// 单独的\r会让下一行覆盖当前行，\r\n按\n处理
+			i++
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+			i += size
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			// C0、DEL和C1控制字符，C1中的0x9b等同于ESC [
+			i += size
+		default:
+			b.WriteString(s[i : i+size])
+			i += size
+		}
+	}
+	return b.String()
+}
+
+// escapeSequence 解析以ESC开头的转义序列，返回序列的字节数和它是否为SGR序列
+// 不完整的序列一直延伸到字符串末尾
+func escapeSequence(s string) (n int, sgr bool) {
+	if len(s) < 2 {
+		return len(s), false
+	}
+
+	switch s[1] {
+	case '[':
+		// CSI: 参数字节0x30-0x3f，中间字节0x20-0x2f，结束字节0x40-0x7e
+		i := 2
+		params := true
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c >= 0x40 && c <= 0x7e {
+				return i + 1, c == 'm' && params
+			}
+			if c < 0x20 || c > 0x3f {
+				// 中间字节或非法字节
+				if c < 0x20 || c > 0x2f {
+					return i, false
+				}
+			}
+			if (c < '0' || c > '9') && c != ';' {
+				params = false
+			}
+		}
+		return len(s), false
+	case ']', 'P', 'X', '^', '_':
+		// OSC、DCS等字符串序列，以BEL或ESC \ 结束
+		for i := 2; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1, false
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2, false
+			}
+		}
+		return len(s), false
+	default:
+		// 两字节序列，如ESC c（重置终端）
+		_, size := utf8.DecodeRuneInString(s[1:])
+		return 1 + size, false
+	}
+}
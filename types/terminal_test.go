@@ -0,0 +1,61 @@
+package types
+
+import "testing"
+
+func TestSanitizeTerminal(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "int main() {\n\treturn 0;\n}\n", "int main() {\n\treturn 0;\n}\n"},
+		{"unicode", "// 注释 ✓\n", "// 注释 ✓\n"},
+		{"crlf", "a\r\nb\r\n", "a\nb\n"},
+		{"carriage return overwrite", "evil\rgood", "evilgood"},
+		{"sgr", "\x1b[31mred\x1b[0m", "red"},
+		{"cursor movement", "a\x1b[2Ab\x1b[10;20Hc", "abc"},
+		{"clear screen", "\x1b[2J\x1b[Hx", "x"},
+		{"private mode", "\x1b[?1049hx\x1b[?25l", "x"},
+		{"osc title bel", "\x1b]0;pwned\x07x", "x"},
+		{"osc hyperlink st", "\x1b]8;;http://evil\x1b\\link\x1b]8;;\x1b\\", "link"},
+		{"dcs", "\x1bPq#0;2;0;0;0\x1b\\x", "x"},
+		{"two byte escape", "\x1bcx\x1b7y", "xy"},
+		{"unterminated csi", "x\x1b[12", "x"},
+		{"unterminated osc", "x\x1b]0;title", "x"},
+		{"trailing esc", "x\x1b", "x"},
+		{"c0 controls", "a\x00b\x07c\x08d\x7fe", "abcde"},
+		{"c1 csi", "a\u009b31mb", "a31mb"},
+		{"invalid utf8", "a\xffb", "a�b"},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeTerminal(tt.in, false); got != tt.want {
+			t.Errorf("%s: SanitizeTerminal(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTerminalKeepSGR(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"sgr", "\x1b[31mred\x1b[0m", "\x1b[31mred\x1b[0m"},
+		{"sgr params", "\x1b[1;38;5;208mx\x1b[m", "\x1b[1;38;5;208mx\x1b[m"},
+		{"private sgr-like", "\x1b[?1mx", "x"},
+		{"intermediate before m", "\x1b[1 mx", "x"},
+		{"cursor movement", "\x1b[32mok\x1b[1A\x1b[2Kpwned\x1b[0m", "\x1b[32mokpwned\x1b[0m"},
+		{"osc title", "\x1b]2;title\x07\x1b[1mx", "\x1b[1mx"},
+		{"unterminated sgr", "x\x1b[31", "x"},
+		{"reset terminal", "\x1bcx", "x"},
+		{"c1 csi", "\u009b31mx", "31mx"},
+		{"controls", "a\rb\x08c\n", "abc\n"},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeTerminal(tt.in, true); got != tt.want {
+			t.Errorf("%s: SanitizeTerminal(%q, true) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
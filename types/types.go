@@ -4,10 +4,19 @@ import (
 	"bytes"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/logrusorgru/aurora/v4"
 )
 
@@ -17,6 +26,10 @@ type Config struct {
 	ListenAddr string `yaml:"ListenAddr"`
 	APIAddr    string `yaml:"APIAddr"`
 
+	CORSOrigins []string `yaml:"CORSOrigins"` // 允许跨域访问API的来源，为空时不设置CORS，"*"表示任意来源但不允许携带凭据
+
+	StaticDir string `yaml:"StaticDir"` // 前端构建产物目录，设置后由API服务器一并提供，未知路径回退到index.html；为空时不提供
+
 	AllowedSSHPubkey string `yaml:"AllowedSSHPubkey"`
 
 	SubmitsDir    string `yaml:"SubmitsDir"`
@@ -26,24 +39,359 @@ type Config struct {
 	RealSubmitsDir    string `yaml:"RealSubmitsDir"`
 	RealSubmitWorkDir string `yaml:"RealSubmitWorkDir"`
 
+	UserQuotaBytes int64 `yaml:"UserQuotaBytes"` // 每个用户提交目录的存储配额（字节），0表示不限制
+
 	SqlitePath string `yaml:"SqlitePath"`
 
 	DockerCli        string `yaml:"DockerCli"`
+	ContainerPrefix  string `yaml:"ContainerPrefix"` // 评测容器名前缀，默认为soj-judge
 	ProblemURLPrefix string `yaml:"ProblemURLPrefix"`
 
+	RestrictedNetwork string `yaml:"RestrictedNetwork"` // 设置allowedhosts的工作流使用的内部网络名前缀，默认为soj-restricted
+
+	// 评测容器的安全选项，为空时使用docker的默认配置
+	// SeccompProfile 为seccomp配置文件(JSON)的路径，AppArmorProfile 为宿主机上已加载的AppArmor配置名
+	// NoNewPrivileges 禁止容器中的进程通过setuid等方式获得新的权限
+	SeccompProfile  string `yaml:"SeccompProfile"`
+	AppArmorProfile string `yaml:"AppArmorProfile"`
+	NoNewPrivileges bool   `yaml:"NoNewPrivileges"`
+
 	SubmitGid int `yaml:"SubmitGid"`
 	SubmitUid int `yaml:"SubmitUid"`
 
-	Admins []string `yaml:"Admins"`
+	// 复制到评测目录的提交文件和目录的权限(八进制)，默认为0400和0700
+	// 评测程序以其他用户运行时可设为0440/0750并让其加入SubmitGid组
+	SubmitFileMode string `yaml:"SubmitFileMode"`
+	SubmitDirMode  string `yaml:"SubmitDirMode"`
+
+	JudgeWorkers         int `yaml:"JudgeWorkers"`         // 同时运行的评测数量，默认为CPU核数
+	MaxPerUserConcurrent int `yaml:"MaxPerUserConcurrent"` // 单个用户最多同时占用的评测槽位，0表示不限制，管理员不受限制
+
+	StuckJudgeTimeout int `yaml:"StuckJudgeTimeout"` // 评测超过该秒数未更新状态时由看门狗终止，0表示不启用
+
+	SubmitRetentionDays int `yaml:"SubmitRetentionDays"` // 定期删除早于该天数的提交及其工作目录（保留各用户的最佳提交），0表示永久保留
+
+	MaxSubmitFiles int `yaml:"MaxSubmitFiles"` // 目录提交的最大文件数，0表示不限制，可被问题的maxfiles覆盖
+
+	MaxWorkflows int `yaml:"MaxWorkflows"` // 每个问题的最大工作流数，超过的问题在加载时被拒绝，0表示不限制
+
+	PerProblemCooldownSeconds int `yaml:"PerProblemCooldownSeconds"` // 同一用户对同一问题两次提交之间的最短间隔（秒），可被问题的cooldown覆盖，0表示不限制
+
+	SubmitPassScore float64 `yaml:"SubmitPassScore"` // submit命令以退出码0结束所需的最低分数，默认为满分
+
+	SkipDuplicateSubmits bool `yaml:"SkipDuplicateSubmits"` // 提交文件与同一问题版本的已评测提交完全相同时不再评测，直接沿用其结果
+
+	NotifyURL string `yaml:"NotifyURL"` // 评测结束后POST通知的地址，为空时不通知
+
+	DefaultWeight    float64 `yaml:"DefaultWeight"`    // 问题未设置权重时的默认权重，默认为1.0
+	NormalizeTotalTo float64 `yaml:"NormalizeTotalTo"` // 大于0时将满分总分缩放到该值
+
+	Admins []string          `yaml:"Admins"` // 兼容旧配置，列表中的用户均为superadmin
+	Roles  map[string]string `yaml:"Roles"`  // 用户名 -> 角色(viewer, grader, superadmin)
+
+	// 比赛时间窗口，零值表示不限制
+	ContestStart time.Time `yaml:"ContestStart"`
+	ContestEnd   time.Time `yaml:"ContestEnd"`
+
+	// 通过问题后是否允许查看他人代码，可被问题的showsolutions覆盖
+	ShowSolutions      bool `yaml:"ShowSolutions"`
+	ShowSolutionsLimit int  `yaml:"ShowSolutionsLimit"`
+
+	// SSH欢迎信息和禁用的命令，管理员不受禁用限制
+	Banner           string   `yaml:"Banner"`
+	DisabledCommands []string `yaml:"DisabledCommands"`
+
+	// 封榜时间，adm snapshot --freeze 只统计此时间之前的提交
+	FreezeTime  time.Time `yaml:"FreezeTime"`
+	SnapshotDir string    `yaml:"SnapshotDir"`
+
+	// AnonymizeRank 排行榜对普通用户显示稳定的化名，管理员和用户本人仍看到真实用户名
+	// PseudonymSalt 生成化名的盐值，为空时自动生成并保存在数据库中
+	AnonymizeRank bool   `yaml:"AnonymizeRank"`
+	PseudonymSalt string `yaml:"PseudonymSalt"`
+
+	// ProblemGroups 分组名 -> 该组用户可访问的问题ID，为空时不限制
+	// UngroupedAccess 未分组用户可访问的问题，all(默认)或none
+	ProblemGroups   map[string][]string `yaml:"ProblemGroups"`
+	UngroupedAccess string              `yaml:"UngroupedAccess"`
+
+	DisplayTimezone string `yaml:"DisplayTimezone"` // 显示时间使用的时区(IANA名称)，为空时使用服务器本地时区
+
+	ScorePrecision *int `yaml:"ScorePrecision"` // 分数保留的小数位数(0-6)，总分和显示均按此四舍五入，默认为2
+
+	// 评测程序给出的分数的有效范围，默认为0-100，超出范围的分数被截断到范围内
+	// RejectOutOfRangeScores 为true时超出范围的提交直接标记为失败
+	MinScore               *float64 `yaml:"MinScore"`
+	MaxScore               *float64 `yaml:"MaxScore"`
+	RejectOutOfRangeScores bool     `yaml:"RejectOutOfRangeScores"`
+}
+
+// ScoreRange 评测程序给出的分数的有效范围
+func (cfg *Config) ScoreRange() (lo float64, hi float64) {
+	lo, hi = 0, 100
+	if cfg.MinScore != nil {
+		lo = *cfg.MinScore
+	}
+	if cfg.MaxScore != nil {
+		hi = *cfg.MaxScore
+	}
+	return lo, hi
+}
+
+// FullScore 获取单个问题的满分（未加权），即分数范围的上限
+func (cfg *Config) FullScore() float64 {
+	_, hi := cfg.ScoreRange()
+	return hi
+}
+
+// commandAliases 命令别名到命令名的映射
+var commandAliases = map[string]string{
+	"sub": "submit",
+	"ls":  "list",
+	"st":  "status",
+	"rk":  "rank",
+	"sol": "solutions",
+}
+
+// CommandDisabled 判断命令（或其别名）是否被禁用
+func (cfg *Config) CommandDisabled(cmd string) bool {
+	if name, ok := commandAliases[cmd]; ok {
+		cmd = name
+	}
+	for _, d := range cfg.DisabledCommands {
+		if name, ok := commandAliases[d]; ok {
+			d = name
+		}
+		if d == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// SubmitModes 解析并校验提交文件和目录的权限
+func (cfg *Config) SubmitModes() (fileMode os.FileMode, dirMode os.FileMode, err error) {
+	fileMode, err = parseMode(cfg.SubmitFileMode, 0400)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid SubmitFileMode: %w", err)
+	}
+	if fileMode&0400 == 0 {
+		return 0, 0, fmt.Errorf("invalid SubmitFileMode: %#o is not readable by owner", fileMode)
+	}
+	dirMode, err = parseMode(cfg.SubmitDirMode, 0700)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid SubmitDirMode: %w", err)
+	}
+	if dirMode&0500 != 0500 {
+		return 0, 0, fmt.Errorf("invalid SubmitDirMode: %#o is not readable and searchable by owner", dirMode)
+	}
+	return fileMode, dirMode, nil
+}
+
+// SecurityOpts 生成评测容器的安全选项，读取并校验seccomp配置文件
+func (cfg *Config) SecurityOpts() ([]string, error) {
+	var opts []string
+	if cfg.SeccompProfile != "" {
+		profile, err := os.ReadFile(cfg.SeccompProfile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SeccompProfile: %w", err)
+		}
+		if !json.Valid(profile) {
+			return nil, fmt.Errorf("invalid SeccompProfile: %s is not valid JSON", cfg.SeccompProfile)
+		}
+		// docker API接受配置内容而不是路径
+		var compact bytes.Buffer
+		json.Compact(&compact, profile)
+		opts = append(opts, "seccomp="+compact.String())
+	}
+	if cfg.AppArmorProfile != "" {
+		opts = append(opts, "apparmor="+cfg.AppArmorProfile)
+	}
+	if cfg.NoNewPrivileges {
+		opts = append(opts, "no-new-privileges")
+	}
+	return opts, nil
+}
+
+// parseMode 解析八进制权限字符串，为空时返回默认值
+func parseMode(s string, def os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return def, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode&^0777 != 0 {
+		return 0, fmt.Errorf("%s has bits outside 0777", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Validate 校验配置，返回所有发现的问题
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"HostKey", cfg.HostKey},
+		{"ListenAddr", cfg.ListenAddr},
+		{"SubmitsDir", cfg.SubmitsDir},
+		{"SubmitWorkDir", cfg.SubmitWorkDir},
+		{"ProblemsDir", cfg.ProblemsDir},
+		{"SqlitePath", cfg.SqlitePath},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", r.name))
+		}
+	}
+
+	if _, _, err := cfg.SubmitModes(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := cfg.SecurityOpts(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DisplayTimezone != "" {
+		if _, err := time.LoadLocation(cfg.DisplayTimezone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid DisplayTimezone: %w", err))
+		}
+	}
+
+	for user, role := range cfg.Roles {
+		if _, ok := RoleCapabilities[role]; !ok {
+			errs = append(errs, fmt.Errorf("user %s has unknown role %q", user, role))
+		}
+	}
+
+	if cfg.ScorePrecision != nil && (*cfg.ScorePrecision < 0 || *cfg.ScorePrecision > MaxScorePrecision) {
+		errs = append(errs, fmt.Errorf("ScorePrecision must be between 0 and %d", MaxScorePrecision))
+	}
+
+	switch cfg.UngroupedAccess {
+	case "", UngroupedAll, UngroupedNone:
+	default:
+		errs = append(errs, fmt.Errorf("invalid UngroupedAccess %q, must be %s or %s", cfg.UngroupedAccess, UngroupedAll, UngroupedNone))
+	}
+	for group, problems := range cfg.ProblemGroups {
+		if group == "" {
+			errs = append(errs, errors.New("ProblemGroups has an empty group name"))
+		}
+		if len(problems) == 0 {
+			errs = append(errs, fmt.Errorf("problem group %s has no problems", group))
+		}
+	}
+
+	if cfg.StaticDir != "" {
+		if info, err := os.Stat(path.Join(cfg.StaticDir, "index.html")); err != nil || info.IsDir() {
+			errs = append(errs, fmt.Errorf("StaticDir %s does not contain index.html", cfg.StaticDir))
+		}
+	}
+
+	if lo, hi := cfg.ScoreRange(); !(lo < hi) || math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		errs = append(errs, errors.New("MinScore must be less than MaxScore"))
+	}
+
+	if !cfg.ContestStart.IsZero() && !cfg.ContestEnd.IsZero() && !cfg.ContestStart.Before(cfg.ContestEnd) {
+		errs = append(errs, errors.New("ContestStart is not before ContestEnd"))
+	}
+
+	numbers := []struct {
+		name  string
+		value float64
+	}{
+		{"UserQuotaBytes", float64(cfg.UserQuotaBytes)},
+		{"JudgeWorkers", float64(cfg.JudgeWorkers)},
+		{"MaxPerUserConcurrent", float64(cfg.MaxPerUserConcurrent)},
+		{"StuckJudgeTimeout", float64(cfg.StuckJudgeTimeout)},
+		{"SubmitRetentionDays", float64(cfg.SubmitRetentionDays)},
+		{"MaxSubmitFiles", float64(cfg.MaxSubmitFiles)},
+		{"MaxWorkflows", float64(cfg.MaxWorkflows)},
+		{"PerProblemCooldownSeconds", float64(cfg.PerProblemCooldownSeconds)},
+		{"SubmitPassScore", cfg.SubmitPassScore},
+		{"DefaultWeight", cfg.DefaultWeight},
+		{"NormalizeTotalTo", cfg.NormalizeTotalTo},
+		{"ShowSolutionsLimit", float64(cfg.ShowSolutionsLimit)},
+	}
+	for _, n := range numbers {
+		if n.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", n.name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ContestActive 判断比赛是否正在进行
+func (cfg *Config) ContestActive(now time.Time) bool {
+	if cfg.ContestStart.IsZero() && cfg.ContestEnd.IsZero() {
+		return false
+	}
+	if !cfg.ContestStart.IsZero() && now.Before(cfg.ContestStart) {
+		return false
+	}
+	if !cfg.ContestEnd.IsZero() && !now.Before(cfg.ContestEnd) {
+		return false
+	}
+	return true
+}
+
+// 管理员能力
+const (
+	CapView   = "view"   // 查看所有提交和用户
+	CapGrade  = "grade"  // 修改评测结果
+	CapManage = "manage" // 删除提交、暂停、管理问题等危险操作
+)
+
+// 管理员角色
+const (
+	RoleViewer     = "viewer"
+	RoleGrader     = "grader"
+	RoleSuperAdmin = "superadmin"
+)
+
+// RoleCapabilities 角色拥有的能力
+var RoleCapabilities = map[string][]string{
+	RoleViewer:     {CapView},
+	RoleGrader:     {CapView, CapGrade},
+	RoleSuperAdmin: {CapView, CapGrade, CapManage},
 }
 
 // JudgeResult 评测结果
 type JudgeResult struct {
-	Success bool    `json:"success"`
-	Score   float64 `json:"score"`
-	Msg     string  `json:"message"`
-	Memory  uint64  `json:"memory"` // in bytes
-	Time    uint64  `json:"time"`   // in ns
+	Success bool    `json:"success" yaml:"success"`
+	Score   float64 `json:"score" yaml:"score"`
+	Msg     string  `json:"message" yaml:"message"`
+	Memory  uint64  `json:"memory" yaml:"memory"` // in bytes
+	Time    uint64  `json:"time" yaml:"time"`     // in ns
+
+	// Verdict 评测结论，如AC、WA、TLE，设置后Success由其决定（AC和PC为成功），为空时保持旧的行为
+	Verdict string `json:"verdict,omitempty" yaml:"verdict,omitempty"`
+
+	Subtasks []SubtaskResult `json:"subtasks,omitempty" yaml:"subtasks,omitempty"`
+}
+
+// SubtaskResult 子任务评测结果
+type SubtaskResult struct {
+	Name     string       `json:"name" yaml:"name"`
+	Score    float64      `json:"score" yaml:"score"`
+	MaxScore float64      `json:"max_score" yaml:"max_score"`
+	Skipped  bool         `json:"skipped,omitempty" yaml:"skipped,omitempty"` // 依赖的子任务未通过，测试点未运行
+	Cases    []CaseResult `json:"cases" yaml:"cases"`
+}
+
+// CaseResult 单个测试点的评测结果
+type CaseResult struct {
+	Name       string `json:"name" yaml:"name"`
+	Passed     bool   `json:"passed" yaml:"passed"`
+	Verdict    string `json:"verdict,omitempty" yaml:"verdict,omitempty"`
+	Skipped    bool   `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	ExitCode   int    `json:"exit_code" yaml:"exit_code"`
+	DurationNs int64  `json:"duration_ns" yaml:"duration_ns"`
 }
 
 // WorkflowResult 工作流结果
@@ -56,8 +404,9 @@ type WorkflowResult struct {
 
 // WorkflowStepResult 工作流步骤结果
 type WorkflowStepResult struct {
-	Logs     string `json:"logs"`
-	ExitCode int    `json:"exit_code"`
+	Logs       string `json:"logs"`
+	ExitCode   int    `json:"exit_code"`
+	DurationNs int64  `json:"duration_ns"`
 }
 
 // Userface 用户界面包装器
@@ -87,8 +436,38 @@ func (f Userface) Write(p []byte) (n int, err error) {
 
 // SubmitHash 提交文件哈希
 type SubmitHash struct {
-	Path string `json:"path"`
-	Hash string `json:"hash"`
+	Path   string `json:"path"`
+	Hash   string `json:"hash"`             // MD5
+	SHA256 string `json:"sha256,omitempty"` // 旧记录中为空
+}
+
+// Display 获取用于展示的哈希，优先使用SHA-256，旧记录回退到MD5
+func (sh SubmitHash) Display() string {
+	if sh.SHA256 != "" {
+		return sh.SHA256
+	}
+	return sh.Hash
+}
+
+// SameFiles 判断两次提交的文件集合及内容是否完全相同，双方都有SHA-256时比较SHA-256，否则比较MD5
+func (sh SubmitsHashes) SameFiles(other SubmitsHashes) bool {
+	if len(sh) != len(other) {
+		return false
+	}
+	files := make(map[string]SubmitHash, len(sh))
+	for _, h := range sh {
+		files[h.Path] = h
+	}
+	for _, o := range other {
+		h, ok := files[o.Path]
+		if !ok || h.Hash != o.Hash {
+			return false
+		}
+		if h.SHA256 != "" && o.SHA256 != "" && h.SHA256 != o.SHA256 {
+			return false
+		}
+	}
+	return true
 }
 
 // SubmitCtx 提交上下文
@@ -97,22 +476,83 @@ type SubmitCtx struct {
 	User    string `json:"user"`
 	Problem string `json:"problem"`
 
+	// UserSeq 用户自己的提交序号，从1开始，首次写入数据库时分配
+	UserSeq int `gorm:"index" json:"user_seq"`
+
 	SubmitTime int64 `json:"submit_time"`
 	LastUpdate int64 `json:"last_update"`
 
 	Status string `json:"status"`
 	Msg    string `json:"message"`
 
+	IdempotencyKey string `gorm:"index" json:"-"`
+
+	// 评测时使用的问题版本
+	ProblemVersion int `json:"problem_version"`
+
 	SubmitDir       string          `json:"-"`
 	SubmitsHashes   SubmitsHashes   `json:"submits_hashes"`
 	Workdir         string          `json:"-"`
 	WorkflowResults WorkflowResults `json:"workflow_results"`
 	JudgeResult     JudgeResult     `json:"judge_result"`
 
+	// 评测结果中的资源占用，单独成列以便查询
+	ResultMemory uint64 `gorm:"index" json:"-"`
+	ResultTime   uint64 `gorm:"index" json:"-"`
+
 	RealWorkdir string `json:"-"`
 
+	// StoredFiles 为true时SubmitDir是之前评测保存的提交文件（压缩包已解压）
+	StoredFiles bool `gorm:"-" json:"-"`
+
+	// DryRun 为true时评测结果不写入数据库，也不发送通知
+	DryRun bool `gorm:"-" json:"-"`
+
 	Running  chan struct{} `gorm:"-" json:"-"`
 	Userface Userface      `json:"-"`
+
+	// recordedStatus 最近一次写入事件日志的状态，用于UpdateSubmit比较状态变化
+	recordedStatus string
+}
+
+// SubmitEvent 提交状态变化事件
+type SubmitEvent struct {
+	ID       uint   `gorm:"primaryKey" json:"-"`
+	SubmitID string `gorm:"index" json:"submit_id"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Time     int64  `json:"time"`
+}
+
+// JudgeDuration 所有工作流步骤的耗时之和
+func (ctx *SubmitCtx) JudgeDuration() time.Duration {
+	var total int64
+	for _, w := range ctx.WorkflowResults {
+		for _, step := range w.Steps {
+			total += step.DurationNs
+		}
+	}
+	return time.Duration(total)
+}
+
+// ParseUserSeq 解析"#5"或"5"形式的用户提交序号
+// 较长的纯数字视为旧格式的提交ID而不是序号
+func ParseUserSeq(ref string) (int, bool) {
+	ref = strings.TrimPrefix(ref, "#")
+	if ref == "" || len(ref) > 9 {
+		return 0, false
+	}
+	seq, err := strconv.Atoi(ref)
+	if err != nil || seq <= 0 {
+		return 0, false
+	}
+	return seq, true
+}
+
+// NewSubmitID 生成提交ID: 纳秒时间戳加随机后缀
+// 时间戳在2286年前固定为19位，按字符串倒序排序仍为时间倒序
+func NewSubmitID(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10) + "-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:8]
 }
 
 func (ctx *SubmitCtx) SetStatus(status string) *SubmitCtx {
@@ -135,12 +575,115 @@ type Problem struct {
 	Weight   float64    `yaml:"weight"`
 	Submits  []Submit   `yaml:"submits"`
 	Workflow []Workflow `yaml:"workflow"`
+
+	ShowSolutions *bool `yaml:"showsolutions"`
+
+	// ResultFormat 评测结果格式: json(result.json), yaml(result.yaml), score(result.txt，仅包含分数)
+	ResultFormat string `yaml:"resultformat"`
+
+	// ProtectedResult 为true时从只有root可写的结果目录（/result，仅挂载到root工作流）读取结果文件，而不是 /work
+	// /work 对提交的程序可写，提交的程序可以在其中写入伪造的结果文件；评测程序需以root工作流运行，
+	// 并且不能把 /work 中的内容当作可信结果，提交的程序也不能在root工作流中运行
+	ProtectedResult bool `yaml:"protectedresult"`
+
+	// Env 传递给所有工作流的环境变量，不能使用保留的 SOJ_ 前缀
+	Env map[string]string `yaml:"env"`
+
+	// MaxFiles 目录提交的最大文件数，0表示使用全局配置
+	MaxFiles int `yaml:"maxfiles"`
+
+	// Manifest 提交文件路径到SHA-256的映射，设置后提交的文件必须与之一致
+	Manifest map[string]string `yaml:"manifest"`
+
+	// Reference 参考解答目录，结构与用户的提交目录相同，用于 adm validate 校验测试数据和评测程序
+	Reference string `yaml:"reference"`
+
+	// 问题开放提交的时间窗口(RFC3339)，零值表示不限制，与全局比赛时间独立
+	OpenTime  time.Time `yaml:"opentime"`
+	CloseTime time.Time `yaml:"closetime"`
+
+	// ScoreAdjust 根据评测结果中的运行时间和内存调整分数，为空时不调整
+	ScoreAdjust *ScoreAdjust `yaml:"scoreadjust"`
+
+	// Cooldown 同一用户两次提交该问题之间的最短间隔（秒），覆盖全局的PerProblemCooldownSeconds，0表示不限制
+	Cooldown *int `yaml:"cooldown"`
+}
+
+// FormatCooldown 格式化剩余的冷却时间，不足一秒按一秒显示
+func FormatCooldown(d time.Duration) string {
+	return max(d.Round(time.Second), time.Second).String()
+}
+
+// ScoreAdjust 按评测结果报告的资源占用调整评测成功的提交的分数，时间和内存的系数相乘
+// 例如 time: {threshold: 1000000000, limit: 3000000000, penalty: 0.5}
+// 表示运行时间超过1秒后线性扣分，达到3秒及以上时扣除一半的分数
+type ScoreAdjust struct {
+	Time   *ResourcePenalty `yaml:"time"`   // 按评测结果的time(ns)计算
+	Memory *ResourcePenalty `yaml:"memory"` // 按评测结果的memory(字节)计算
+}
+
+// ResourcePenalty 用量超过Threshold后线性扣分，达到Limit时扣除Penalty比例(0-1)的分数
+type ResourcePenalty struct {
+	Threshold uint64  `yaml:"threshold"`
+	Limit     uint64  `yaml:"limit"`
+	Penalty   float64 `yaml:"penalty"`
+}
+
+// Factor 获取用量为used时的得分系数，未设置时为1
+func (p *ResourcePenalty) Factor(used uint64) float64 {
+	if p == nil || used <= p.Threshold {
+		return 1
+	}
+	if used >= p.Limit {
+		return 1 - p.Penalty
+	}
+	return 1 - p.Penalty*float64(used-p.Threshold)/float64(p.Limit-p.Threshold)
+}
+
+// Factor 获取评测结果的得分系数
+func (sa *ScoreAdjust) Factor(res JudgeResult) float64 {
+	if sa == nil {
+		return 1
+	}
+	return sa.Time.Factor(res.Time) * sa.Memory.Factor(res.Memory)
+}
+
+// NotYetOpen 判断问题是否尚未开放
+func (p *Problem) NotYetOpen(now time.Time) bool {
+	return !p.OpenTime.IsZero() && now.Before(p.OpenTime)
+}
+
+// Closed 判断问题是否已关闭提交
+func (p *Problem) Closed(now time.Time) bool {
+	return !p.CloseTime.IsZero() && !now.Before(p.CloseTime)
+}
+
+// SolutionsVisible 判断通过后是否可查看他人代码，问题配置优先于全局配置
+func (p *Problem) SolutionsVisible(cfg *Config) bool {
+	if p.ShowSolutions != nil {
+		return *p.ShowSolutions
+	}
+	return cfg.ShowSolutions
 }
 
 // Submit 提交定义
 type Submit struct {
 	Path  string `yaml:"path"`
 	IsDir bool   `yaml:"isdir"`
+	// Archive 为true时用户上传 <path>.tar.gz，评测时解压到 <path> 目录
+	Archive bool `yaml:"archive"`
+	// Optional 为true时文件缺失不影响评测，由评测程序自行处理
+	Optional bool `yaml:"optional"`
+	// MaxFileSize 上传内容的最大字节数（目录为所有文件之和，压缩包为压缩后大小），0表示不限制
+	MaxFileSize int64 `yaml:"maxfilesize"`
+}
+
+// SourcePath 用户上传时该提交项在提交目录中的路径
+func (s *Submit) SourcePath() string {
+	if s.Archive {
+		return s.Path + ".tar.gz"
+	}
+	return s.Path
 }
 
 // Workflow 工作流定义
@@ -154,6 +697,66 @@ type Workflow struct {
 	PrivilegedSteps []int    `yaml:"privilegedsteps"`
 	NetworkHostMode bool     `yaml:"networkhostmode"`
 	Mounts          []Mount  `yaml:"mounts"`
+	MaxShownBytes   int      `yaml:"maxshownbytes"` // 每个显示步骤推送给用户的最大字节数，0表示不限制
+	// MergeOutput 显示步骤的stdout和stderr合并为同一颜色输出，默认分别以蓝色和红色显示
+	MergeOutput bool `yaml:"mergeoutput"`
+	// AllowedHosts 非空时容器接入单独的内部网络 <RestrictedNetwork>-<提交ID>-<工作流序号>，只能访问列出的主机
+	// 每项为同一Docker主机上正在运行的容器名称，该容器被接入网络，评测容器按名称访问它；不支持IP地址
+	AllowedHosts []string `yaml:"allowedhosts"`
+	// Env 该工作流的环境变量，覆盖问题级别的同名变量
+	Env map[string]string `yaml:"env"`
+	// CaptureContainerLogs 是否保存容器的完整日志，默认保存；步骤日志已足够时可关闭以节省存储
+	CaptureContainerLogs *bool `yaml:"capturecontainerlogs"`
+	// TestCases 步骤（通常是编译）完成后在同一容器中逐个运行的测试点
+	TestCases *TestCases `yaml:"testcases"`
+	// Entrypoint 非空时覆盖镜像的入口点（同时忽略镜像的CMD），为空时使用镜像默认入口点
+	// 步骤通过exec在容器中执行，容器需在评测期间保持运行；镜像入口点会立即退出或有副作用时
+	// 可设为 ["sleep", "infinity"] 或 ["tail", "-f", "/dev/null"] 使容器保持运行
+	Entrypoint []string `yaml:"entrypoint"`
+	// 提交文件和工作目录在容器中的挂载点，默认为 /submits 和 /work
+	// ContainerWorkdir 容器的工作目录，默认为工作目录的挂载点
+	SubmitsMount     string `yaml:"submitsmount"`
+	WorkDirMount     string `yaml:"workdirmount"`
+	ContainerWorkdir string `yaml:"containerworkdir"`
+	// CompileSteps 编译步骤（从1开始），失败时以编译错误(CE)结束评测并向用户显示其stderr
+	CompileSteps []int `yaml:"compilesteps"`
+	// Retries 评测容器无法启动时重新运行该工作流的次数；步骤开始运行后的失败（包括非零退出码、超时和执行环境出错）不重试
+	Retries int `yaml:"retries"`
+	// Grader 为true时该工作流是评测程序：在独立的容器中以root运行，工作目录以只读方式挂载，
+	// 结果写入只挂载到评测工作流的结果目录 /result；运行前之前工作流的容器都已停止，
+	// 提交的程序只能通过工作目录中的输出影响评测，无法修改评测程序或结果文件
+	Grader bool `yaml:"grader"`
+	// Unconfined 为true时不使用seccomp和AppArmor配置，用于需要ptrace等被默认配置禁止的系统调用的评测程序
+	// 会削弱沙箱隔离，只应在确有需要的工作流中设置
+	Unconfined bool `yaml:"unconfined"`
+}
+
+// TestCases 测试点配置，测试数据目录中的每个 <name>.in 为一个测试点，答案为 <name>.ans
+// 目录以只读方式挂载到容器的 /tests，需同时对SOJ和docker宿主机可见
+type TestCases struct {
+	Dir string `yaml:"dir"`
+	// Run 每个测试点执行的命令，退出码为0表示通过
+	// 可使用 SOJ_TEST_NAME、SOJ_TEST_INPUT、SOJ_TEST_ANSWER 环境变量
+	Run string `yaml:"run"`
+	// Timeout 单个测试点的超时时间（秒），0表示使用工作流的Timeout
+	Timeout int `yaml:"timeout"`
+	// Subtasks 为空时所有测试点平分满分（MaxScore）
+	Subtasks []Subtask `yaml:"subtasks"`
+}
+
+// Subtask 子任务，所有测试点都通过才得分
+type Subtask struct {
+	Name  string  `yaml:"name"`
+	Score float64 `yaml:"score"`
+	// Cases 测试点名的通配模式（path.Match）
+	Cases []string `yaml:"cases"`
+	// DependsOn 依赖的子任务序号（从1开始，只能是之前的子任务），任一依赖未全部通过时跳过本子任务
+	DependsOn []int `yaml:"dependson"`
+}
+
+// CaptureLogs 判断是否保存容器的完整日志
+func (w *Workflow) CaptureLogs() bool {
+	return w.CaptureContainerLogs == nil || *w.CaptureContainerLogs
 }
 
 // Mount 挂载定义
@@ -164,6 +767,12 @@ type Mount struct {
 	ReadOnly bool   `yaml:"readonly"`
 }
 
+// Setting 运行时可修改的设置，重启后保留
+type Setting struct {
+	Key   string `gorm:"primaryKey" json:"key"`
+	Value string `json:"value"`
+}
+
 // User 用户信息
 type User struct {
 	ID             string         `gorm:"primaryKey" json:"id"`
@@ -172,22 +781,213 @@ type User struct {
 	BestSubmits    JMapStrString  `json:"best_submits"`
 	BestSubmitDate JMapStrInt64   `json:"best_submit_date"`
 	TotalScore     float64        `json:"total_score"`
+
+	Banned    bool   `json:"banned"`
+	BanReason string `json:"ban_reason,omitempty"`
+	BannedBy  string `json:"-"`
+	BannedAt  int64  `json:"-"`
+
+	// Anonymous 用户选择在公开排行榜上显示化名
+	Anonymous bool `json:"anonymous"`
+
+	// Group 用户所在的问题分组，为空表示未分组
+	Group string `json:"group,omitempty"`
+}
+
+// ProblemAttempts 用户在某问题上首次通过前的尝试次数
+type ProblemAttempts struct {
+	User           string `json:"user"`
+	Problem        string `json:"problem"`
+	Attempts       int    `json:"attempts"` // 首次通过之前的提交数（不含通过的那次）
+	Solved         bool   `json:"solved"`
+	FirstSolveID   string `json:"first_solve_id,omitempty"`
+	FirstSolveTime int64  `json:"first_solve_time,omitempty"`
+}
+
+// FirstSolve 问题的首个通过记录（一血）
+type FirstSolve struct {
+	Problem    string `json:"problem"`
+	User       string `json:"user"`
+	SubmitID   string `json:"submit_id"`
+	SubmitTime int64  `json:"submit_time"`
+}
+
+// HistoryPoint 用户得分历史中的一个点
+type HistoryPoint struct {
+	Time       int64   `json:"time"`
+	SubmitID   string  `json:"submit_id"`
+	Problem    string  `json:"problem"`
+	Score      float64 `json:"score"`       // 本次提交的加权分数
+	TotalScore float64 `json:"total_score"` // 截至本次提交的最佳总分
+}
+
+// BestSubmit 用户在某问题上的最佳提交
+type BestSubmit struct {
+	Problem    string  `json:"problem"`
+	SubmitID   string  `json:"submit_id"`
+	UserSeq    int     `json:"user_seq"`
+	Score      float64 `json:"score"` // 计入总分的加权分数
+	SubmitTime int64   `json:"submit_time"`
+}
+
+// ResourceTrend 某问题某天的资源占用统计
+type ResourceTrend struct {
+	Day       string  `json:"day"`
+	Count     int64   `json:"count"`
+	AvgMemory float64 `json:"avg_memory"` // in bytes
+	AvgTime   float64 `json:"avg_time"`   // in ns
+}
+
+// Standing 排行榜中的一行
+type Standing struct {
+	Rank       int                `json:"rank"`
+	User       string             `json:"user"`
+	TotalScore float64            `json:"total_score"`
+	Scores     map[string]float64 `json:"scores"`
+}
+
+// StandingsSnapshot 排行榜快照
+type StandingsSnapshot struct {
+	Time       int64      `json:"time"`
+	Frozen     bool       `json:"frozen"`
+	FreezeTime int64      `json:"freeze_time,omitempty"`
+	Problems   []string   `json:"problems"`
+	Standings  []Standing `json:"standings"`
 }
 
+// CalculateTotalScore 计算用户总分，按ScorePrecision舍入
 func (u *User) CalculateTotalScore() {
+	u.TotalScore = RoundScore(u.sumBestScores())
+}
+
+// sumBestScores 按问题ID顺序累加最佳分数，保证相同成绩的浮点求和结果一致
+// 跳过NaN和Inf，避免个别异常的分数破坏总分和排名
+func (u *User) sumBestScores() float64 {
 	var total float64
-	for _, s := range u.BestScores {
-		total += s
+	for _, k := range slices.Sorted(maps.Keys(u.BestScores)) {
+		if score := u.BestScores[k]; !math.IsNaN(score) && !math.IsInf(score, 0) {
+			total += score
+		}
+	}
+	return total
+}
+
+// applyBestSubmit 用提交更新用户在该问题上的最佳成绩
+// 只记录成功完成且加权分数高于当前最佳的提交，未通过的问题当前最佳视为0，因此0分的提交不计为通过
+func (u *User) applyBestSubmit(s *SubmitCtx, problem *Problem) {
+	if s.Status != "completed" || !s.JudgeResult.Success {
+		return
+	}
+	newScore := s.JudgeResult.Score * problem.Weight
+	if u.BestScores[s.Problem] >= newScore {
+		return
+	}
+	if u.BestScores == nil {
+		u.BestScores = make(map[string]float64)
+		u.BestSubmits = make(map[string]string)
+		u.BestSubmitDate = make(map[string]int64)
 	}
-	u.TotalScore = total
+	u.BestScores[s.Problem] = newScore
+	u.BestSubmits[s.Problem] = s.ID
+	u.BestSubmitDate[s.Problem] = s.SubmitTime
 }
 
 // 辅助函数
+// displayLocation 显示时间使用的时区
+var displayLocation = time.Local
+
+// SetDisplayTimezone 设置显示时间使用的时区，name为空时使用服务器本地时区
+func SetDisplayTimezone(name string) error {
+	if name == "" {
+		displayLocation = time.Local
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	displayLocation = loc
+	return nil
+}
+
+// scorePrecision 分数保留的小数位数
+var scorePrecision = 2
+
+// MaxScorePrecision 分数最多保留的小数位数
+const MaxScorePrecision = 6
+
+// SetScorePrecision 设置分数保留的小数位数
+func SetScorePrecision(n int) {
+	scorePrecision = n
+}
+
+// RoundScore 将分数四舍五入（0.5远离零）到ScorePrecision位小数
+// 先加一个远小于精度的偏移，避免1.005这类无法精确表示的值被舍去
+func RoundScore(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return v
+	}
+	pow := math.Pow10(scorePrecision)
+	r := math.Round(v*pow+math.Copysign(1e-7, v)) / pow
+	if r == 0 {
+		// 舍入为-0时显示为"-0.00"
+		return 0
+	}
+	return r
+}
+
+// FormatScore 按ScorePrecision舍入并格式化分数
+func FormatScore(v float64) string {
+	return strconv.FormatFloat(RoundScore(v), 'f', scorePrecision, 64)
+}
+
+// ScoreFormat 按ScorePrecision格式化分数的格式串，用于表格列
+func ScoreFormat() string {
+	return "%." + strconv.Itoa(scorePrecision) + "f"
+}
+
+// FormatTime 按显示时区格式化时间
+func FormatTime(t time.Time) string {
+	return t.In(displayLocation).Format(time.DateTime + " MST")
+}
+
+// FormatUnixNano 按显示时区格式化纳秒时间戳
+func FormatUnixNano(ns int64) string {
+	return FormatTime(time.Unix(0, ns))
+}
+
+// FormatRelative 将纳秒时间戳格式化为相对now的时间，如 "3 minutes ago"
+func FormatRelative(ns int64, now time.Time) string {
+	d := now.Sub(time.Unix(0, ns))
+	if d < time.Minute {
+		return "just now"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, u := range units {
+		if n := int64(d / u.size); n >= 1 {
+			if n == 1 {
+				return "1 " + u.name + " ago"
+			}
+			return strconv.FormatInt(n, 10) + " " + u.name + "s ago"
+		}
+	}
+	return "just now"
+}
+
 func GetTime(t time.Time) aurora.Value {
-	return aurora.Gray(15, t.Format("2006-01-02 15:04:05.000"))
+	return aurora.Gray(15, t.In(displayLocation).Format("2006-01-02 15:04:05.000"))
 }
 
 func ColorizeScore(res JudgeResult) aurora.Value {
+	res.Score = RoundScore(res.Score)
 	if !res.Success {
 		return aurora.Gray(15, res.Score)
 	}
@@ -200,7 +1000,60 @@ func ColorizeScore(res JudgeResult) aurora.Value {
 	}
 }
 
+// 常用的评测结论，result.json中也可以使用其他自定义结论
+const (
+	VerdictAccepted            = "AC"  // 答案正确
+	VerdictPartiallyCorrect    = "PC"  // 部分正确，得分有效
+	VerdictWrongAnswer         = "WA"  // 答案错误
+	VerdictTimeLimitExceeded   = "TLE" // 超出时间限制
+	VerdictMemoryLimitExceeded = "MLE" // 超出内存限制
+	VerdictRuntimeError        = "RE"  // 运行时错误
+	VerdictCompileError        = "CE"  // 编译错误
+)
+
+// VerdictSuccess 判断评测结论是否为成功（得分有效）
+func VerdictSuccess(verdict string) bool {
+	return verdict == VerdictAccepted || verdict == VerdictPartiallyCorrect
+}
+
+// ColorizeVerdict 评测结论着色
+func ColorizeVerdict(verdict string) aurora.Value {
+	switch verdict {
+	case VerdictAccepted:
+		return aurora.Green(verdict)
+	case VerdictPartiallyCorrect:
+		return aurora.Yellow(verdict)
+	case VerdictWrongAnswer:
+		return aurora.Red(verdict)
+	case VerdictTimeLimitExceeded, VerdictMemoryLimitExceeded:
+		return aurora.Magenta(verdict)
+	case VerdictRuntimeError:
+		return aurora.BrightRed(verdict)
+	case VerdictCompileError:
+		return aurora.Cyan(verdict)
+	default:
+		return aurora.Bold(verdict)
+	}
+}
+
+// FinalStatuses 评测已结束的提交状态
+// completed为评测通过，rejected为评测正常结束但结果为失败（如答案错误），failed为评测系统或环境出错
+var FinalStatuses = []string{"completed", "rejected", "failed", "dead"}
+
+// IsFinalStatus 判断提交是否已结束评测
+func IsFinalStatus(status string) bool {
+	return slices.Contains(FinalStatuses, status)
+}
+
+// HasJudgeResult 判断该状态的提交是否有评测结果
+func HasJudgeResult(status string) bool {
+	return status == "completed" || status == "rejected"
+}
+
 func ColorizeStatus(status string) aurora.Value {
+	if strings.HasPrefix(status, "queued-") {
+		return aurora.Cyan(status)
+	}
 	switch status {
 	case "init":
 		return aurora.Gray(10, status)
@@ -216,8 +1069,10 @@ func ColorizeStatus(status string) aurora.Value {
 		return aurora.Yellow(status)
 	case "completed":
 		return aurora.Green(status)
-	case "failed":
+	case "rejected":
 		return aurora.Red(status)
+	case "failed":
+		return aurora.Magenta(status)
 	case "dead":
 		return aurora.Gray(15, status)
 	default:
@@ -238,9 +1093,10 @@ func (sh SubmitHash) Value() (driver.Value, error) {
 }
 
 func (sh *SubmitHash) Scan(value interface{}) error {
-	b, ok := value.([]byte)
+	b, ok := jsonBytes(value)
 	if !ok {
-		return json.Unmarshal(b, sh)
+		*sh = SubmitHash{}
+		return nil
 	}
 	return json.Unmarshal(b, sh)
 }
@@ -249,14 +1105,27 @@ func (sh SubmitsHashes) Value() (driver.Value, error) {
 	return json.Marshal(sh)
 }
 
+// 旧记录只有path和hash(MD5)字段，sha256缺失时为空
 func (sh *SubmitsHashes) Scan(value interface{}) error {
-	b, ok := value.([]byte)
+	b, ok := jsonBytes(value)
 	if !ok {
-		return json.Unmarshal(b, sh)
+		*sh = nil
+		return nil
 	}
 	return json.Unmarshal(b, sh)
 }
 
+// jsonBytes 获取数据库中JSON列的原始内容，NULL或空值返回false
+func jsonBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, len(v) > 0
+	case string:
+		return []byte(v), v != ""
+	}
+	return nil, false
+}
+
 func (sh WorkflowResult) Value() (driver.Value, error) {
 	return json.Marshal(sh)
 }
@@ -0,0 +1,94 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRoundScore(t *testing.T) {
+	defer SetScorePrecision(scorePrecision)
+
+	tests := []struct {
+		precision int
+		in        float64
+		want      float64
+	}{
+		{2, 1.005, 1.01},
+		{2, 2.675, 2.68},
+		{2, 99.995, 100},
+		{2, 1.004, 1},
+		{2, -1.005, -1.01},
+		{2, -2.675, -2.68},
+		{2, -0.004, 0},
+		{1, 0.05, 0.1},
+		{1, 1.45, 1.5},
+		{3, 1.0005, 1.001},
+		{0, 0.5, 1},
+		{0, 2.5, 3},
+		{0, 2.4999, 2},
+		{0, -0.5, -1},
+		{0, -2.5, -3},
+		{6, 1.0000005, 1.000001},
+		{6, 0.1234564, 0.123456},
+		{6, -1.0000005, -1.000001},
+		{2, 0, 0},
+	}
+	for _, tt := range tests {
+		SetScorePrecision(tt.precision)
+		if got := RoundScore(tt.in); got != tt.want {
+			t.Errorf("RoundScore(%v) at precision %d = %v, want %v", tt.in, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestRoundScoreNonFinite(t *testing.T) {
+	defer SetScorePrecision(scorePrecision)
+
+	for _, precision := range []int{0, 2, 6} {
+		SetScorePrecision(precision)
+		if got := RoundScore(math.NaN()); !math.IsNaN(got) {
+			t.Errorf("RoundScore(NaN) at precision %d = %v, want NaN", precision, got)
+		}
+		if got := RoundScore(math.Inf(1)); !math.IsInf(got, 1) {
+			t.Errorf("RoundScore(+Inf) at precision %d = %v, want +Inf", precision, got)
+		}
+		if got := RoundScore(math.Inf(-1)); !math.IsInf(got, -1) {
+			t.Errorf("RoundScore(-Inf) at precision %d = %v, want -Inf", precision, got)
+		}
+	}
+}
+
+func TestFormatScore(t *testing.T) {
+	defer SetScorePrecision(scorePrecision)
+
+	tests := []struct {
+		precision int
+		in        float64
+		want      string
+	}{
+		{2, 1.005, "1.01"},
+		{0, 2.5, "3"},
+		{6, 1.0000005, "1.000001"},
+		{2, -0.004, "0.00"},
+	}
+	for _, tt := range tests {
+		SetScorePrecision(tt.precision)
+		if got := FormatScore(tt.in); got != tt.want {
+			t.Errorf("FormatScore(%v) at precision %d = %q, want %q", tt.in, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateTotalScoreSkipsNonFinite(t *testing.T) {
+	u := User{BestScores: map[string]float64{
+		"a": 40,
+		"b": math.NaN(),
+		"c": math.Inf(1),
+		"d": math.Inf(-1),
+		"e": 2.5,
+	}}
+	u.CalculateTotalScore()
+	if u.TotalScore != 42.5 {
+		t.Errorf("TotalScore = %v, want 42.5", u.TotalScore)
+	}
+}
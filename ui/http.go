@@ -1,48 +1,180 @@
 package ui
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mrhaoxx/SOJ/judge"
 	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 // HTTPServer HTTP服务器
 type HTTPServer struct {
-	dbService *types.DatabaseService
+	dbService      *types.DatabaseService
+	problemManager *judge.ProblemManager
+	evaluator      *judge.Evaluator
+	cfg            *types.Config
+	state          *types.RuntimeState
+
+	rank rankCache
+}
+
+// rankCache 按观察者缓存序列化后的排行榜，版本变化时整体丢弃
+type rankCache struct {
+	mu      sync.Mutex
+	version uint64
+	entries map[string]rankEntry
+}
+
+type rankEntry struct {
+	body []byte
+	etag string
+}
+
+// rankUser 排行榜中的一行，不包含封禁原因等审计信息和用户分组
+type rankUser struct {
+	ID             string               `json:"id"`
+	BestScores     types.JMapStrFloat64 `json:"best_scores"`
+	BestSubmits    types.JMapStrString  `json:"best_submits"`
+	BestSubmitDate types.JMapStrInt64   `json:"best_submit_date"`
+	TotalScore     float64              `json:"total_score"`
+	Anonymous      bool                 `json:"anonymous"`
 }
 
+// maxHistoryPoints 得分历史接口最多返回的点数
+const maxHistoryPoints = 1000
+
 // NewHTTPServer 创建新的HTTP服务器
-func NewHTTPServer(dbService *types.DatabaseService) *HTTPServer {
+func NewHTTPServer(dbService *types.DatabaseService, problemManager *judge.ProblemManager, evaluator *judge.Evaluator, cfg *types.Config, state *types.RuntimeState) *HTTPServer {
 	return &HTTPServer{
-		dbService: dbService,
+		dbService:      dbService,
+		problemManager: problemManager,
+		evaluator:      evaluator,
+		cfg:            cfg,
+		state:          state,
 	}
 }
 
+// CORSMiddleware 跨域中间件，只允许配置的来源携带凭据访问，配置"*"时允许任意来源不携带凭据访问
+func (s *HTTPServer) CORSMiddleware() gin.HandlerFunc {
+	allowed := make(map[string]struct{})
+	for _, origin := range s.cfg.CORSOrigins {
+		allowed[origin] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		// 只有明确列出的来源可以携带凭据，"*"允许任意来源但不携带凭据，
+		// 否则任意网站都能以用户的token cookie调用API
+		if _, ok := allowed[origin]; ok {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+			c.Header("Vary", "Origin")
+		} else if _, ok := allowed["*"]; ok {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Next()
+			return
+		}
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
+
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequestLogMiddleware 使用zerolog记录请求日志，包含认证后的用户和请求ID
+func (s *HTTPServer) RequestLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 {
+			requestID = uuid.NewString()
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+
+		c.Next()
+
+		user := c.GetString("user")
+		status := c.Writer.Status()
+
+		var event *zerolog.Event
+		switch {
+		case status >= http.StatusInternalServerError:
+			event = log.Error()
+		case status >= http.StatusBadRequest:
+			event = log.Warn()
+		default:
+			event = log.Info()
+		}
+
+		event.Str("request_id", requestID).
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("ip", c.ClientIP()).
+			Str("user", user).
+			Msg("http request")
+	}
+}
+
+// resolveToken 从Authorization: Bearer头或token cookie中获取token
+func resolveToken(c *gin.Context) (string, bool) {
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok && strings.TrimSpace(token) != "" {
+			return strings.TrimSpace(token), true
+		}
+	}
+
+	token, err := c.Cookie("token")
+	if err != nil || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
 // AuthMiddleware 认证中间件
 func (s *HTTPServer) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token, err := c.Cookie("token")
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"code":    0,
-				"message": "Token is required",
-				"data":    nil,
-			})
-			c.Abort()
+		token, ok := resolveToken(c)
+		if !ok {
+			abortError(c, CodeUnauthorized, "Token is required")
 			return
 		}
 
 		user, err := s.dbService.GetUserByToken(token)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"code":    0,
-				"message": "Invalid Token",
-				"data":    nil,
-			})
-			c.Abort()
+			abortError(c, CodeUnauthorized, "Invalid Token")
 			return
 		}
 
@@ -54,30 +186,47 @@ func (s *HTTPServer) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// AdminMiddleware 管理员能力检查中间件，需在AuthMiddleware之后使用
+func (s *HTTPServer) AdminMiddleware(capability string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, _ := c.Get("user")
+		if !s.dbService.HasCapability(user.(string), capability) {
+			abortError(c, CodeForbidden, "Permission denied")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// MaintenanceMiddleware 维护模式下对非管理员返回503，需在AuthMiddleware之后使用
+func (s *HTTPServer) MaintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.state.Maintenance() && !c.GetBool("is_admin") {
+			abortError(c, CodeUnavailable, "Service is under maintenance")
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // listSubmits 列出提交
 func (s *HTTPServer) listSubmits(c *gin.Context) {
 	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
 	if err != nil || page <= 0 {
-		c.JSON(400, gin.H{
-			"message": "Invalid parameter: page",
-		})
+		respondError(c, CodeBadRequest, "Invalid parameter: page")
 		return
 	}
 	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	if err != nil || limit <= 0 {
-		c.JSON(400, gin.H{
-			"message": "Invalid parameter: limit",
-		})
+		respondError(c, CodeBadRequest, "Invalid parameter: limit")
 		return
 	}
 
 	submits, total, err := s.dbService.GetSubmitsForAPI(page, limit)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"code":    1,
-			"message": "Database error",
-			"data":    nil,
-		})
+		respondError(c, CodeInternal, "Database error")
 		return
 	}
 
@@ -91,13 +240,9 @@ func (s *HTTPServer) listSubmits(c *gin.Context) {
 		}
 	}
 
-	c.JSON(200, gin.H{
-		"code":    0,
-		"message": "success",
-		"data": gin.H{
-			"total":   total,
-			"submits": submits,
-		},
+	respondOK(c, gin.H{
+		"total":   total,
+		"submits": submits,
 	})
 }
 
@@ -105,60 +250,142 @@ func (s *HTTPServer) listSubmits(c *gin.Context) {
 func (s *HTTPServer) getSubmitDetail(c *gin.Context) {
 	id := c.Param("id")
 	if id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    1,
-			"message": "Invalid parameter",
-			"data":    nil,
-		})
+		respondError(c, CodeBadRequest, "Invalid parameter")
 		return
 	}
 
 	submit, err := s.dbService.GetSubmitByID(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    1,
-			"message": "Submit not found",
-			"data":    nil,
-		})
+		respondError(c, CodeNotFound, "Submit not found")
 		return
 	}
 
 	admin, _ := c.Get("is_admin")
 	user, _ := c.Get("user")
 	if !admin.(bool) && submit.User != user.(string) {
-		c.JSON(http.StatusForbidden, gin.H{
-			"code":    1,
-			"message": "You are not allowed to view this submit",
-			"data":    nil,
-		})
+		respondError(c, CodeForbidden, "You are not allowed to view this submit")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"code":    0,
-		"message": "success",
-		"data":    submit,
-	})
+	respondOK(c, submit)
 	return
 }
 
+// deleteSubmit 删除提交记录并重新计算提交用户的最佳成绩
+func (s *HTTPServer) deleteSubmit(c *gin.Context) {
+	id := c.Param("id")
+
+	submit, err := s.dbService.GetSubmitByID(id)
+	if err != nil {
+		respondError(c, CodeNotFound, "Submit not found")
+		return
+	}
+
+	err = s.dbService.DeleteSubmitByIDWithProblems(id, s.problemManager.GetAllProblems())
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	user, err := s.dbService.LookupUser(submit.User)
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	log.Info().Str("admin", c.GetString("user")).Str("submit", id).Str("user", submit.User).Msg("deleted submit")
+
+	respondOK(c, gin.H{
+		"deleted":      id,
+		"user":         user.ID,
+		"problem":      submit.Problem,
+		"recalculated": true, // 用户的最佳成绩和总分已按剩余提交重新计算
+		"total_score":  user.TotalScore,
+		"best_scores":  user.BestScores,
+	})
+}
+
 // listRank 排行榜
 func (s *HTTPServer) listRank(c *gin.Context) {
-	users, err := s.dbService.GetAllUsersOrderedByScore()
+	viewer := c.GetString("user")
+	entry, err := s.rankEntry(viewer)
 	if err != nil {
-		c.JSON(500, gin.H{
-			"code":    1,
-			"message": "Database error",
-			"data":    nil,
-		})
+		respondError(c, CodeInternal, "Database error")
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"code":    0,
+	c.Header("ETag", entry.etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatch(c.GetHeader("If-None-Match"), entry.etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", entry.body)
+}
+
+// etagMatch 判断If-None-Match是否包含给定的ETag，忽略弱校验前缀
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// rankEntry 获取观察者视角的排行榜响应，排行榜版本未变化时直接使用缓存
+func (s *HTTPServer) rankEntry(viewer string) (rankEntry, error) {
+	users, version, err := s.dbService.GetRankSnapshot()
+	if err != nil {
+		return rankEntry{}, err
+	}
+
+	// 管理员看到的都是真实用户名，共用同一份缓存
+	key := viewer
+	if s.dbService.IsAdmin(viewer) {
+		key = ""
+	}
+
+	s.rank.mu.Lock()
+	defer s.rank.mu.Unlock()
+
+	if s.rank.entries == nil || s.rank.version != version {
+		s.rank.entries = make(map[string]rankEntry)
+		s.rank.version = version
+	}
+	if entry, ok := s.rank.entries[key]; ok {
+		return entry, nil
+	}
+
+	rows := make([]rankUser, len(users))
+	for i, u := range users {
+		rows[i] = rankUser{
+			ID:             s.dbService.RankName(u, viewer),
+			BestScores:     u.BestScores,
+			BestSubmits:    u.BestSubmits,
+			BestSubmitDate: u.BestSubmitDate,
+			TotalScore:     u.TotalScore,
+			Anonymous:      u.Anonymous,
+		}
+	}
+	body, err := json.Marshal(gin.H{
+		"code":    CodeSuccess,
 		"message": "success",
-		"data":    users,
+		"data":    rows,
 	})
+	if err != nil {
+		return rankEntry{}, err
+	}
+
+	sum := sha256.Sum256(body)
+	entry := rankEntry{
+		body: body,
+		etag: `"` + hex.EncodeToString(sum[:8]) + `"`,
+	}
+	s.rank.entries[key] = entry
+	return entry, nil
 }
 
 // getUserSummary 获取用户摘要
@@ -166,37 +393,360 @@ func (s *HTTPServer) getUserSummary(c *gin.Context) {
 	id, _ := c.Get("user")
 	user, err := s.dbService.GetUserByID(id.(string))
 	if err != nil {
-		c.JSON(500, gin.H{
-			"code":    1,
-			"message": "Database error",
-			"data":    nil,
-		})
+		respondError(c, CodeInternal, "Database error")
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"code":    0,
-		"message": "success",
-		"data":    user,
-	})
+	respondOK(c, user)
 	return
 }
 
+// getUserHistory 获取用户得分历史，只返回最近的limit个点
+func (s *HTTPServer) getUserHistory(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "200"))
+	if err != nil || limit <= 0 || limit > maxHistoryPoints {
+		respondError(c, CodeBadRequest, "Invalid parameter: limit")
+		return
+	}
+
+	id, _ := c.Get("user")
+	history, err := s.dbService.GetUserScoreHistory(id.(string), s.problemManager.GetAllProblems())
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	total := len(history)
+	if total > limit {
+		history = history[total-limit:]
+	}
+
+	respondOK(c, gin.H{
+		"total":   total,
+		"history": history,
+	})
+}
+
+// getUserBest 获取用户每个问题的最佳提交
+func (s *HTTPServer) getUserBest(c *gin.Context) {
+	id, _ := c.Get("user")
+	best, err := s.dbService.GetUserBestSubmits(id.(string))
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	respondOK(c, best)
+}
+
+// recompute 使用当前问题集重新计算所有用户的成绩
+func (s *HTTPServer) recompute(c *gin.Context) {
+	problems := s.problemManager.GetAllProblems()
+
+	updated, err := s.dbService.DoFullUserScan(problems)
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	user, _ := c.Get("user")
+	log.Info().Str("admin", user.(string)).Int("users", updated).Msg("recomputed user scores")
+
+	respondOK(c, gin.H{
+		"updated": updated,
+	})
+}
+
+// tokenCookieMaxAge token cookie的有效期（秒）
+const tokenCookieMaxAge = 30 * 24 * 3600
+
+// getMyToken 获取当前用户的token状态，不返回token本身
+func (s *HTTPServer) getMyToken(c *gin.Context) {
+	id, _ := c.Get("user")
+	user, err := s.dbService.GetUserByID(id.(string))
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	_, cookieErr := c.Cookie("token")
+	respondOK(c, gin.H{
+		"present":     user.Token != "",
+		"from_cookie": cookieErr == nil,
+	})
+}
+
+// rotateMyToken 重新生成当前用户的token，清除旧cookie并写入新cookie
+func (s *HTTPServer) rotateMyToken(c *gin.Context) {
+	id, _ := c.Get("user")
+	user, err := s.dbService.RotateUserToken(id.(string))
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	secure := c.Request.TLS != nil
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie("token", "", -1, "/", "", secure, true)
+	c.SetCookie("token", user.Token, tokenCookieMaxAge, "/", "", secure, true)
+
+	log.Info().Str("user", user.ID).Msg("rotated token via API")
+
+	respondOK(c, gin.H{
+		"present": true,
+	})
+}
+
+// ProblemMeta 问题的权重和满分信息
+type ProblemMeta struct {
+	ID        string     `json:"id"`
+	Weight    float64    `json:"weight"`
+	MaxScore  float64    `json:"max_score"`
+	URL       string     `json:"url"`
+	OpenTime  *time.Time `json:"open_time,omitempty"`
+	CloseTime *time.Time `json:"close_time,omitempty"`
+
+	// Submits 需要上传的文件，MaxFiles 目录提交的最大文件数（0表示不限制）
+	Submits  []SubmitMeta `json:"submits"`
+	MaxFiles int          `json:"max_files"`
+}
+
+// SubmitMeta 问题要求上传的一项文件，供前端生成上传表单并在上传前校验
+type SubmitMeta struct {
+	Path        string `json:"path"`        // 评测时的路径
+	UploadPath  string `json:"upload_path"` // 上传到提交目录中的路径，压缩包为 <path>.tar.gz
+	IsDir       bool   `json:"is_dir"`
+	Archive     bool   `json:"archive"`
+	Optional    bool   `json:"optional"`
+	MaxFileSize int64  `json:"max_file_size"` // 0表示不限制
+}
+
+// getProblemsMeta 获取问题权重和满分总分，与SSH的my视图计算方式一致
+func (s *HTTPServer) getProblemsMeta(c *gin.Context) {
+	admin := c.GetBool("is_admin")
+	factor := s.dbService.ScoreFactor()
+	now := time.Now()
+
+	problems := s.problemManager.GetAllProblems()
+	metas := make([]ProblemMeta, 0, len(problems))
+	for _, p := range problems {
+		if !admin && p.NotYetOpen(now) {
+			continue
+		}
+		if !s.dbService.CanAccessProblem(c.GetString("user"), p.Id) {
+			continue
+		}
+		meta := ProblemMeta{
+			ID:       p.Id,
+			Weight:   p.Weight,
+			MaxScore: s.cfg.FullScore() * p.Weight * factor,
+			URL:      s.cfg.ProblemURLPrefix + p.Id,
+			Submits:  make([]SubmitMeta, 0, len(p.Submits)),
+			MaxFiles: p.MaxFiles,
+		}
+		if meta.MaxFiles <= 0 {
+			meta.MaxFiles = s.cfg.MaxSubmitFiles
+		}
+		for _, sub := range p.Submits {
+			meta.Submits = append(meta.Submits, SubmitMeta{
+				Path:        sub.Path,
+				UploadPath:  sub.SourcePath(),
+				IsDir:       sub.IsDir,
+				Archive:     sub.Archive,
+				Optional:    sub.Optional,
+				MaxFileSize: sub.MaxFileSize,
+			})
+		}
+		if !p.OpenTime.IsZero() {
+			meta.OpenTime = &p.OpenTime
+		}
+		if !p.CloseTime.IsZero() {
+			meta.CloseTime = &p.CloseTime
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].ID < metas[j].ID
+	})
+
+	respondOK(c, gin.H{
+		"problems":     metas,
+		"max_total":    s.dbService.MaxTotalScore(),
+		"score_factor": factor,
+	})
+}
+
+// getPause 获取提交暂停状态
+func (s *HTTPServer) getPause(c *gin.Context) {
+	respondOK(c, gin.H{
+		"paused": s.state.Paused(),
+	})
+}
+
+// getStatistics 获取全局提交统计信息
+func (s *HTTPServer) getStatistics(c *gin.Context) {
+	stats, err := s.dbService.GetSubmitStatistics()
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+	respondOK(c, stats)
+}
+
+// setPause 设置提交暂停状态
+func (s *HTTPServer) setPause(c *gin.Context) {
+	var req struct {
+		Paused *bool `json:"paused"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Paused == nil {
+		respondError(c, CodeBadRequest, "Invalid parameter: paused")
+		return
+	}
+
+	err := s.state.SetPaused(*req.Paused)
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+
+	user, _ := c.Get("user")
+	log.Info().Str("admin", user.(string)).Bool("paused", *req.Paused).Msg("changed pause state")
+
+	respondOK(c, gin.H{
+		"paused": s.state.Paused(),
+	})
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipResponseWriter 在首次写入响应体时才开始压缩，空响应体（如204/304）保持原样
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.gz == nil {
+		h := w.Header()
+		if h.Get("Content-Encoding") != "" {
+			return w.ResponseWriter.Write(b)
+		}
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzipWriterPool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(nil)
+	gzipWriterPool.Put(w.gz)
+	w.gz = nil
+}
+
+// GzipMiddleware 在客户端接受gzip时压缩响应体，跳过HEAD请求和WebSocket升级
+func (s *HTTPServer) GzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead ||
+			!strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") ||
+			strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+
+		gw := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = gw
+		defer func() {
+			gw.close()
+			c.Writer = gw.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// serveStatic 未匹配的路由：配置了StaticDir时提供前端静态文件，
+// 不存在且不带扩展名的路径回退到index.html以支持前端的history路由，API路径始终返回JSON错误
+func (s *HTTPServer) serveStatic(c *gin.Context) {
+	p := path.Clean("/" + c.Request.URL.Path)
+	if s.cfg.StaticDir == "" || p == "/api" || strings.HasPrefix(p, "/api/") ||
+		(c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) {
+		respondError(c, CodeNotFound, "Not found")
+		return
+	}
+
+	file := filepath.Join(s.cfg.StaticDir, filepath.FromSlash(p))
+	if info, err := os.Stat(file); err == nil && !info.IsDir() {
+		c.File(file)
+		return
+	}
+
+	// 缺失的资源文件（如 .js、.css）直接返回404，避免以HTML内容响应
+	if path.Ext(p) != "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.File(filepath.Join(s.cfg.StaticDir, "index.html"))
+}
+
 // ServeHTTP 启动HTTP服务器
 func (s *HTTPServer) ServeHTTP(addr string) {
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), s.RequestLogMiddleware())
 	err := router.SetTrustedProxies([]string{"127.0.0.1"})
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to set trusted proxies")
 		return
 	}
 
-	auth := router.Group("/api/v1", s.AuthMiddleware())
+	if len(s.cfg.CORSOrigins) > 0 {
+		router.Use(s.CORSMiddleware())
+	}
+	router.Use(s.GzipMiddleware())
+
+	router.NoRoute(s.serveStatic)
+
+	auth := router.Group("/api/v1", s.AuthMiddleware(), s.MaintenanceMiddleware())
 	auth.GET("rank", s.listRank)
 	auth.GET("list", s.listSubmits)
 	auth.GET("my", s.getUserSummary)
+	auth.GET("my/history", s.getUserHistory)
+	auth.GET("my/best", s.getUserBest)
+	auth.GET("my/token", s.getMyToken)
+	auth.POST("my/token/rotate", s.rotateMyToken)
 	auth.GET("status/:id", s.getSubmitDetail)
+	auth.GET("problems/meta", s.getProblemsMeta)
+	auth.POST("submit/:problem", s.submitUpload)
+
+	admin := auth.Group("admin")
+	admin.POST("recompute", s.AdminMiddleware(types.CapGrade), s.recompute)
+	admin.GET("pause", s.AdminMiddleware(types.CapView), s.getPause)
+	admin.POST("pause", s.AdminMiddleware(types.CapManage), s.setPause)
+	admin.GET("statistics", s.AdminMiddleware(types.CapView), s.getStatistics)
+	admin.DELETE("status/:id", s.AdminMiddleware(types.CapManage), s.deleteSubmit)
 
 	go func() {
 		log.Info().Str("addr", addr).Msg("HTTP server started")
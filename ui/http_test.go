@@ -0,0 +1,60 @@
+package ui
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+func TestRankHidesAuditFields(t *testing.T) {
+	for _, anonymize := range []bool{false, true} {
+		cfg := &types.Config{
+			SqlitePath:    path.Join(t.TempDir(), "soj.db"),
+			Admins:        []string{"root"},
+			AnonymizeRank: anonymize,
+			ProblemGroups: map[string][]string{"secret-group": {"p1"}},
+		}
+		ds, err := types.NewDatabaseService(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.SetUserBanned("mallory", true, "copied from alice", "root"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.SetUserGroup("mallory", "secret-group"); err != nil {
+			t.Fatal(err)
+		}
+
+		s := &HTTPServer{dbService: ds, cfg: cfg}
+		for _, viewer := range []string{"alice", "mallory", "root"} {
+			entry, err := s.rankEntry(viewer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := string(entry.body)
+			for _, leak := range []string{"copied from alice", "secret-group", "ban_reason", "banned", "group"} {
+				if strings.Contains(body, leak) {
+					t.Errorf("anonymize=%v viewer=%s: rank contains %q: %s", anonymize, viewer, leak, body)
+				}
+			}
+
+			var resp struct {
+				Data []map[string]any `json:"data"`
+			}
+			if err := json.Unmarshal(entry.body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Data) != 1 {
+				t.Fatalf("rank has %d rows, want 1", len(resp.Data))
+			}
+			// 化名只对其他普通用户生效
+			real := !anonymize || viewer == "root" || viewer == "mallory"
+			if id := resp.Data[0]["id"]; (id == "mallory") != real {
+				t.Errorf("anonymize=%v viewer=%s: rank shows id %v", anonymize, viewer, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ui
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIErrorCode API响应中的code字段，0表示成功，非0表示错误类别
+type APIErrorCode int
+
+const (
+	CodeSuccess      APIErrorCode = 0
+	CodeBadRequest   APIErrorCode = 1 // 请求参数错误
+	CodeUnauthorized APIErrorCode = 2 // 未认证或token无效
+	CodeForbidden    APIErrorCode = 3 // 无权限
+	CodeNotFound     APIErrorCode = 4 // 资源不存在
+	CodeInternal     APIErrorCode = 5 // 服务器内部错误
+	CodeUnavailable  APIErrorCode = 6 // 服务维护中
+)
+
+// httpStatus 错误类别对应的HTTP状态码
+func (code APIErrorCode) httpStatus() int {
+	switch code {
+	case CodeSuccess:
+		return http.StatusOK
+	case CodeBadRequest:
+		return http.StatusBadRequest
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondOK 返回成功响应
+func respondOK(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    CodeSuccess,
+		"message": "success",
+		"data":    data,
+	})
+}
+
+// respondError 返回错误响应，HTTP状态码由错误类别决定
+func respondError(c *gin.Context, code APIErrorCode, message string) {
+	c.JSON(code.httpStatus(), gin.H{
+		"code":    code,
+		"message": message,
+		"data":    nil,
+	})
+}
+
+// abortError 返回错误响应并中止后续处理，用于中间件
+func abortError(c *gin.Context, code APIErrorCode, message string) {
+	respondError(c, code, message)
+	c.Abort()
+}
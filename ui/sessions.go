@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	ssh "github.com/gliderlabs/ssh"
+	"github.com/logrusorgru/aurora/v4"
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog/log"
+)
+
+// broadcastTimeout 广播时等待每个会话写入完成的最长时间，客户端不读取数据时写入会阻塞
+const broadcastTimeout = 5 * time.Second
+
+// sessionRegistry 当前连接的SSH会话，用于广播消息
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[ssh.Session]struct{}
+}
+
+// TrackSession 登记SSH会话，返回的函数在会话结束时调用以取消登记
+func (sh *SSHHandler) TrackSession(s ssh.Session) func() {
+	sh.sessions.mu.Lock()
+	if sh.sessions.sessions == nil {
+		sh.sessions.sessions = make(map[ssh.Session]struct{})
+	}
+	sh.sessions.sessions[s] = struct{}{}
+	sh.sessions.mu.Unlock()
+
+	return func() {
+		sh.sessions.mu.Lock()
+		delete(sh.sessions.sessions, s)
+		sh.sessions.mu.Unlock()
+	}
+}
+
+// activeSessions 获取仍然连接的会话
+func (sh *SSHHandler) activeSessions() []ssh.Session {
+	sh.sessions.mu.Lock()
+	defer sh.sessions.mu.Unlock()
+
+	var sessions []ssh.Session
+	for s := range sh.sessions.sessions {
+		if s.Context().Err() != nil {
+			delete(sh.sessions.sessions, s)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// broadcast 向所有连接的会话的stderr写入消息，不干扰stdout上的命令输出，返回成功送达的会话数
+func (sh *SSHHandler) broadcast(message string) (delivered int, total int) {
+	sessions := sh.activeSessions()
+
+	line := aurora.Yellow("[broadcast]").String() + " " + aurora.Gray(15, types.FormatTime(time.Now())).String() + " " + aurora.Bold(message).String() + "\r\n"
+
+	results := make(chan bool, len(sessions))
+	for _, s := range sessions {
+		go func(s ssh.Session) {
+			_, err := io.WriteString(s.Stderr(), line)
+			if err != nil {
+				log.Debug().Err(err).Str("user", s.User()).Msg("failed to deliver broadcast")
+			}
+			results <- err == nil
+		}(s)
+	}
+
+	timeout := time.After(broadcastTimeout)
+	for range sessions {
+		select {
+		case ok := <-results:
+			if ok {
+				delivered++
+			}
+		case <-timeout:
+			return delivered, len(sessions)
+		}
+	}
+	return delivered, len(sessions)
+}
+
+// handleAdminBroadcast 向所有连接的SSH会话广播消息
+func (sh *SSHHandler) handleAdminBroadcast(s ssh.Session, uf types.Userface, message string) {
+	delivered, total := sh.broadcast(message)
+	log.Info().Str("admin", s.User()).Str("message", message).Int("delivered", delivered).Int("sessions", total).Msg("broadcast message")
+	uf.Println(aurora.Green("Success:"), "Delivered to", aurora.Bold(delivered), "of", aurora.Bold(total), "sessions")
+}
@@ -2,7 +2,11 @@ package ui
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -10,35 +14,116 @@ import (
 
 	ssh "github.com/gliderlabs/ssh"
 	"github.com/logrusorgru/aurora/v4"
+	"github.com/mrhaoxx/SOJ/file_transfer"
+	"github.com/mrhaoxx/SOJ/judge"
 	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog/log"
 )
 
+// maxProblemDefinitionSize 通过stdin上传的问题定义的最大字节数
+const maxProblemDefinitionSize = 1 << 20
+
+// maxSolutionFileSize 查看他人代码时单个文件显示的最大字节数
+const maxSolutionFileSize = 64 << 10
+
+// adminCommandCapabilities 管理员命令所需的能力，未列出的命令需要manage能力
+var adminCommandCapabilities = map[string]string{
+	"list":            types.CapView,
+	"status":          types.CapView,
+	"user":            types.CapView,
+	"attempts":        types.CapView,
+	"firstblood":      types.CapView,
+	"fb":              types.CapView,
+	"trends":          types.CapView,
+	"capacity":        types.CapView,
+	"stats-global":    types.CapView,
+	"modify":          types.CapGrade,
+	"rejudge-problem": types.CapGrade,
+	"rejudge":         types.CapGrade,
+	"validate":        types.CapGrade,
+	"pause":           types.CapManage,
+	"resume":          types.CapManage,
+	"delete":          types.CapManage,
+	"reload":          types.CapManage,
+	"putproblem":      types.CapManage,
+	"import-users":    types.CapManage,
+	"group":           types.CapManage,
+	"gc-workdirs":     types.CapManage,
+	"broadcast":       types.CapManage,
+	"snapshot":        types.CapManage,
+	"ban":             types.CapManage,
+	"unban":           types.CapManage,
+	"maintenance":     types.CapManage,
+	"setting":         types.CapManage,
+}
+
+// adminCommandCapability 获取管理员命令所需的能力
+func adminCommandCapability(cmd string) string {
+	if capability, ok := adminCommandCapabilities[cmd]; ok {
+		return capability
+	}
+	return types.CapManage
+}
+
+// commandHelps 欢迎信息中显示的命令帮助
+var commandHelps = []struct {
+	name string
+	help []interface{}
+}{
+	{"submit", []interface{}{"Use 'submit", aurora.Gray(15, "(sub)"), "<problem_id>' to submit a problem",
+		aurora.Gray(15, "(exit code: 0 accepted, 1 judge failed, 2 partial score, 3 not submitted)")}},
+	{"resubmit", []interface{}{"Use 'resubmit <submit_id|#seq>' to submit the files of a previous submission again"}},
+	{"list", []interface{}{"Use 'list", aurora.Gray(15, "(ls)"), "[page] [--status <status>] [--problem <problem_id>] [--relative]' to list your submissions"}},
+	{"status", []interface{}{"Use 'status", aurora.Gray(15, "(st)"), "<submit_id|#seq> [--relative]' to show a submission", aurora.Magenta("(fuzzy match)")}},
+	{"transcript", []interface{}{"Use 'transcript <submit_id|#seq>' to replay the full judge output of a submission"}},
+	{"rank", []interface{}{"Use 'rank", aurora.Gray(15, "(rk)"), "' to show rank list"}},
+	{"my", []interface{}{"Use 'my [--relative]' to show your submission summary"}},
+	{"todo", []interface{}{"Use 'todo' to list problems you have not solved yet"}},
+	{"quota", []interface{}{"Use 'quota' to show your storage usage"}},
+	{"solutions", []interface{}{"Use 'solutions", aurora.Gray(15, "(sol)"), "<problem_id>' to view others' solutions after solving"}},
+	{"token", []interface{}{"Use 'token [rotate]' to get or regenerate token for frontend authentication"}},
+	{"anonymous", []interface{}{"Use 'anonymous [on|off]' to hide your username on the public ranking"}},
+}
+
 // SSHHandler SSH处理器
 type SSHHandler struct {
-	dbService *types.DatabaseService
-	cfg       *types.Config
-	problems  map[string]types.Problem
-	paused    bool
+	dbService      *types.DatabaseService
+	cfg            *types.Config
+	problemManager *judge.ProblemManager
+	evaluator      *judge.Evaluator
+	state          *types.RuntimeState
+	problems       map[string]types.Problem
+	sessions       sessionRegistry
 }
 
 // NewSSHHandler 创建新的SSH处理器
-func NewSSHHandler(dbService *types.DatabaseService, cfg *types.Config, problems map[string]types.Problem) *SSHHandler {
+func NewSSHHandler(dbService *types.DatabaseService, cfg *types.Config, problemManager *judge.ProblemManager, evaluator *judge.Evaluator, state *types.RuntimeState) *SSHHandler {
 	return &SSHHandler{
-		dbService: dbService,
-		cfg:       cfg,
-		problems:  problems,
-		paused:    false,
+		dbService:      dbService,
+		cfg:            cfg,
+		problemManager: problemManager,
+		evaluator:      evaluator,
+		state:          state,
+		problems:       problemManager.GetAllProblems(),
 	}
 }
 
-// SetPaused 设置暂停状态
-func (sh *SSHHandler) SetPaused(paused bool) {
-	sh.paused = paused
-}
-
 // UpdateProblems 更新问题列表
 func (sh *SSHHandler) UpdateProblems(problems map[string]types.Problem) {
 	sh.problems = problems
+	err := sh.dbService.SetProblems(problems)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to recalculate total scores")
+	}
+}
+
+// CheckCommand 检查命令是否被禁用，被禁用时提示用户并返回false，管理员不受限制
+func (sh *SSHHandler) CheckCommand(s ssh.Session, cmd string) bool {
+	if !sh.cfg.CommandDisabled(cmd) || sh.dbService.IsAdmin(s.User()) {
+		return true
+	}
+	s.Write([]byte("command disabled\n"))
+	return false
 }
 
 // HandleSession 处理SSH会话
@@ -51,22 +136,34 @@ func (sh *SSHHandler) HandleSession(s ssh.Session) {
 	cmds := s.Command()
 
 	if len(cmds) == 0 {
-		uf.Println("Welcome to", aurora.Bold("SOJ"), aurora.Gray(aurora.GrayIndex(10), "Secure Online Judge"), ",", aurora.BrightBlue(s.User()))
-		uf.Println(aurora.Yellow(time.Now().Format(time.DateTime + " MST")))
-		uf.Println("Use 'submit", aurora.Gray(15, "(sub)"), "<problem_id>' to submit a problem")
-		uf.Println("Use 'list", aurora.Gray(15, "(ls)"), "[page]' to list your submissions")
-		uf.Println("Use 'status", aurora.Gray(15, "(st)"), "<submit_id>' to show a submission", aurora.Magenta("(fuzzy match)"))
-		uf.Println("Use 'rank", aurora.Gray(15, "(rk)"), "' to show rank list")
-		uf.Println("Use 'my' to show your submission summary")
-		uf.Println("Use 'token' to get token for frontend authentication")
+		if sh.cfg.Banner != "" {
+			uf.Println(sh.cfg.Banner)
+		} else {
+			uf.Println("Welcome to", aurora.Bold("SOJ"), aurora.Gray(aurora.GrayIndex(10), "Secure Online Judge"), ",", aurora.BrightBlue(s.User()))
+		}
+		uf.Println(aurora.Yellow(types.FormatTime(time.Now())))
+		if sh.state.Maintenance() {
+			uf.Println(aurora.Yellow("The judge is under maintenance, new submissions are not accepted"))
+		}
+		if running, err := sh.dbService.GetUserRunningSubmit(s.User()); err == nil {
+			uf.Println(aurora.Cyan("You have a submission"), aurora.Magenta(running.ID), aurora.Gray(15, "(#"+strconv.Itoa(running.UserSeq)+")"),
+				"for", aurora.Bold(running.Problem), "currently", types.ColorizeStatus(running.Status))
+		}
+
+		admin := sh.dbService.IsAdmin(s.User())
+		for _, h := range commandHelps {
+			if admin || !sh.cfg.CommandDisabled(h.name) {
+				uf.Println(h.help...)
+			}
+		}
 		uf.Println()
 
 	} else {
-		uf.Println(aurora.Yellow(time.Now().Format(time.DateTime + " MST")))
+		uf.Println(aurora.Yellow(types.FormatTime(time.Now())))
 
 		switch cmds[0] {
 		case "rank", "rk":
-			sh.handleRank(uf)
+			sh.handleRank(s, uf)
 
 		case "submit", "sub":
 			sh.handleSubmit(s, uf, cmds)
@@ -77,11 +174,26 @@ func (sh *SSHHandler) HandleSession(s ssh.Session) {
 		case "status", "st":
 			sh.handleStatus(s, uf, cmds)
 
+		case "transcript":
+			sh.handleTranscript(s, uf, cmds)
+
 		case "my":
-			sh.handleMy(s, uf)
+			sh.handleMy(s, uf, cmds)
+
+		case "todo":
+			sh.handleTodo(s, uf)
+
+		case "quota":
+			sh.handleQuota(s, uf)
 
 		case "token":
-			sh.handleToken(s, uf)
+			sh.handleToken(s, uf, cmds)
+
+		case "anonymous":
+			sh.handleAnonymous(s, uf, cmds)
+
+		case "solutions", "sol":
+			sh.handleSolutions(s, uf, cmds)
 
 		case "adm":
 			sh.handleAdmin(s, uf, cmds)
@@ -93,7 +205,7 @@ func (sh *SSHHandler) HandleSession(s ssh.Session) {
 }
 
 // handleRank 处理排行榜命令
-func (sh *SSHHandler) handleRank(uf types.Userface) {
+func (sh *SSHHandler) handleRank(s ssh.Session, uf types.Userface) {
 	users, err := sh.dbService.GetAllUsersOrderedByScore()
 	if err != nil {
 		uf.Println(aurora.Red("error:"), "failed to get user rankings")
@@ -108,34 +220,27 @@ func (sh *SSHHandler) handleRank(uf types.Userface) {
 	sort.Strings(prblmss)
 
 	var ranks []string
-
-	var cursoc float64 = -1
-	var currk int = 0
-	for i := range users {
-		if users[i].TotalScore != cursoc {
-			currk = i
-			cursoc = users[i].TotalScore
-		}
-		ranks = append(ranks, strconv.Itoa(currk+1))
-
+	for _, rk := range computeRanks(users) {
+		ranks = append(ranks, strconv.Itoa(rk))
 	}
 
 	var userss []string
 	for _, u := range users {
-		userss = append(userss, u.ID)
+		userss = append(userss, sh.dbService.RankName(u, s.User()))
 	}
 
 	var totalscores []string
 	for _, u := range users {
-		totalscores = append(totalscores, fmt.Sprintf("%.2f", u.TotalScore))
+		totalscores = append(totalscores, types.FormatScore(u.TotalScore))
 	}
 
 	var bestscores [][]string
+	factor := sh.dbService.ScoreFactor()
 
 	for _, p := range prblmss {
 		var scores []string
 		for _, u := range users {
-			scores = append(scores, fmt.Sprintf("%.2f", u.BestScores[p]))
+			scores = append(scores, types.FormatScore(u.BestScores[p]*factor))
 		}
 		bestscores = append(bestscores, scores)
 	}
@@ -148,6 +253,22 @@ func (sh *SSHHandler) handleRank(uf types.Userface) {
 	sh.mkTable(uf, append([]string{"Rank", "User", "Total"}, prblmss...), append([]aurora.Color{aurora.BoldFm | aurora.YellowFg, aurora.BoldFm | aurora.WhiteFg, aurora.BoldFm | aurora.GreenFg}, colc...), append([][]string{ranks, userss, totalscores}, bestscores...))
 }
 
+// computeRanks 计算按总分排序后用户的名次，同分同名次
+func computeRanks(users []types.User) []int {
+	var ranks []int
+
+	var cursoc float64 = -1
+	var currk int = 0
+	for i := range users {
+		if users[i].TotalScore != cursoc {
+			currk = i
+			cursoc = users[i].TotalScore
+		}
+		ranks = append(ranks, currk+1)
+	}
+	return ranks
+}
+
 // handleSubmit 处理提交命令
 func (sh *SSHHandler) handleSubmit(s ssh.Session, uf types.Userface, cmds []string) {
 	if len(cmds) != 2 {
@@ -155,7 +276,7 @@ func (sh *SSHHandler) handleSubmit(s ssh.Session, uf types.Userface, cmds []stri
 		uf.Println("usage: submit <problem_id>")
 		return
 	}
-	if sh.paused {
+	if sh.state.Paused() {
 		uf.Println(aurora.Red("error:"), "submit is paused. Please try again later")
 		return
 	}
@@ -166,26 +287,51 @@ func (sh *SSHHandler) handleSubmit(s ssh.Session, uf types.Userface, cmds []stri
 
 // handleList 处理列表命令
 func (sh *SSHHandler) handleList(s ssh.Session, uf types.Userface, cmds []string) {
-	if len(cmds) > 2 {
+	page := 1
+	var status, problem string
+
+	var badArgs bool
+	var gotPage bool
+	var relative bool
+	for i := 1; i < len(cmds) && !badArgs; i++ {
+		switch cmds[i] {
+		case relativeFlag:
+			relative = true
+		case "--status", "--problem":
+			if i+1 >= len(cmds) || cmds[i+1] == "" {
+				badArgs = true
+				break
+			}
+			if cmds[i] == "--status" {
+				status = cmds[i+1]
+			} else {
+				problem = cmds[i+1]
+			}
+			i++
+		default:
+			var err error
+			page, err = strconv.Atoi(cmds[i])
+			if gotPage || err != nil || page < 1 {
+				badArgs = true
+			}
+			gotPage = true
+		}
+	}
+	if badArgs {
 		uf.Println(aurora.Red("error:"), "invalid arguments")
-		uf.Println("usage: list [page]")
+		uf.Println("usage: list [page] [--status <status>] [--problem <problem_id>] [--relative]")
 		return
 	}
 
 	uf.Println(aurora.Green("Listing"), aurora.Bold("submissions"))
-
-	page := 1
-
-	if len(cmds) == 2 {
-		var err error
-		page, err = strconv.Atoi(cmds[1])
-		if err != nil {
-			uf.Println(aurora.Red("error:"), "invalid page number")
-			return
-		}
+	if status != "" {
+		uf.Println(aurora.Cyan("Status:"), types.ColorizeStatus(status))
+	}
+	if problem != "" {
+		uf.Println(aurora.Cyan("Problem:"), aurora.Bold(problem))
 	}
 
-	submits, total, err := sh.dbService.GetSubmitsByUser(s.User(), page, 10)
+	submits, total, err := sh.dbService.GetSubmitsByUserFiltered(s.User(), status, problem, page, 10)
 	if err != nil {
 		uf.Println(aurora.Red("error:"), "failed to get submissions")
 		return
@@ -193,32 +339,74 @@ func (sh *SSHHandler) handleList(s ssh.Session, uf types.Userface, cmds []string
 
 	uf.Println(aurora.Cyan("Page"), aurora.Bold(page), "of", aurora.Yellow(total/10+1))
 
-	sh.listSubs(uf, submits)
+	sh.listSubs(uf, submits, relative)
 }
 
 // handleStatus 处理状态命令
 func (sh *SSHHandler) handleStatus(s ssh.Session, uf types.Userface, cmds []string) {
+	cmds, relative := takeFlag(cmds, relativeFlag)
 	if len(cmds) != 2 {
 		uf.Println(aurora.Red("error:"), "invalid arguments")
-		uf.Println("usage: status <submit_id>")
+		uf.Println("usage: status <submit_id|#seq> [--relative]")
 		return
 	}
 
 	uf.Println(aurora.Green("Showing"), aurora.Bold("submission"), aurora.Magenta(cmds[1]))
 
-	submit, err := sh.dbService.FindSubmitsByUserAndPattern(s.User(), cmds[1])
+	submit, err := sh.dbService.FindUserSubmit(s.User(), cmds[1])
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "submit", aurora.Yellow(strconv.Quote(cmds[1])), "not found")
+		return
+	}
+
+	uf.Println()
+
+	sh.showSub(uf, *submit, relative)
+}
+
+// handleTranscript 处理查看评测完整输出命令，管理员可查看所有提交
+func (sh *SSHHandler) handleTranscript(s ssh.Session, uf types.Userface, cmds []string) {
+	if len(cmds) != 2 {
+		uf.Println(aurora.Red("error:"), "invalid arguments")
+		uf.Println("usage: transcript <submit_id|#seq>")
+		return
+	}
+
+	var submit *types.SubmitCtx
+	var err error
+	if sh.dbService.IsAdmin(s.User()) {
+		submit, err = sh.dbService.GetSubmitByID(cmds[1])
+	}
+	if submit == nil {
+		submit, err = sh.dbService.FindUserSubmit(s.User(), cmds[1])
+	}
 	if err != nil {
 		uf.Println(aurora.Red("error:"), "submit", aurora.Yellow(strconv.Quote(cmds[1])), "not found")
 		return
 	}
 
+	uf.Println(aurora.Green("Transcript of"), aurora.Bold("submission"), aurora.Magenta(submit.ID))
 	uf.Println()
 
-	sh.showSub(uf, *submit)
+	if submit.Userface.Buffer == nil || submit.Userface.Buffer.Len() == 0 {
+		uf.Println(aurora.Gray(15, "No transcript recorded"))
+		return
+	}
+
+	// 评测输出来自容器，只保留颜色，去掉其他转义序列
+	uf.Write([]byte(types.SanitizeTerminal(submit.Userface.Buffer.String(), true)))
+	uf.Println()
 }
 
 // handleMy 处理个人信息命令
-func (sh *SSHHandler) handleMy(s ssh.Session, uf types.Userface) {
+func (sh *SSHHandler) handleMy(s ssh.Session, uf types.Userface, cmds []string) {
+	cmds, relative := takeFlag(cmds, relativeFlag)
+	if len(cmds) != 1 {
+		uf.Println(aurora.Red("error:"), "invalid arguments")
+		uf.Println("usage: my [--relative]")
+		return
+	}
+
 	uf.Println("User", aurora.Bold(aurora.BrightWhite(s.User())))
 
 	user, err := sh.dbService.GetUserByID(s.User())
@@ -227,60 +415,203 @@ func (sh *SSHHandler) handleMy(s ssh.Session, uf types.Userface) {
 		return
 	}
 
+	if user.Group != "" {
+		uf.Println("Group:", aurora.Cyan(user.Group))
+	}
+
+	attempts := sh.userAttempts(s.User())
+
 	var prblmss []string
 	for k := range sh.problems {
-		prblmss = append(prblmss, k)
+		if sh.dbService.CanAccessProblem(s.User(), k) {
+			prblmss = append(prblmss, k)
+		}
 	}
 
 	sort.Strings(prblmss)
 
-	Cols := []string{"Problem", "Score", "Weight", "Submit ID", "Date"}
-	var ColLongest = make([]int, len(Cols))
-	for i, col := range Cols {
-		ColLongest[i] = len(col)
-	}
+	// 得分和满分按ScoreFactor缩放，与总分一致
+	factor := sh.dbService.ScoreFactor()
 
-	var map_succ map[string]bool = make(map[string]bool)
+	table := types.NewTable(
+		types.Column{Title: "Problem", Color: aurora.BoldFm | aurora.ItalicFm},
+		types.Column{Title: "Score", Align: types.AlignRight, Format: types.ScoreFormat()},
+		types.Column{Title: "Weight", Color: aurora.BoldFm, Align: types.AlignRight, Format: "%.2f"},
+		types.Column{Title: "Earned", Color: aurora.BoldFm, Align: types.AlignRight, Format: types.ScoreFormat()},
+		types.Column{Title: "Max", Color: aurora.Color(0).Gray(15), Align: types.AlignRight, Format: types.ScoreFormat()},
+		types.Column{Title: "Progress", Align: types.AlignRight},
+		types.Column{Title: "Submit ID", Color: aurora.MagentaFg},
+		types.Column{Title: "Date"},
+		types.Column{Title: "Attempts", Color: aurora.CyanFg},
+	)
+
+	var earned, maximum float64
+	var full = sh.cfg.FullScore()
 
 	for _, problem_id := range prblmss {
-		sco, ok := user.BestScores[problem_id]
-		if ok {
-			map_succ[problem_id] = true
+		weight := sh.problems[problem_id].Weight
+		sco, solved := user.BestScores[problem_id]
+		earned += sco
+		maximum += full * weight
+
+		pct := progress(sco, full*weight)
+		date := aurora.Gray(15, "N/A")
+		if solved {
+			date = aurora.Yellow(formatSubmitTime(user.BestSubmitDate[problem_id], relative))
 		}
-		ColLongest[0] = max(ColLongest[0], len(problem_id))
-		ColLongest[1] = max(ColLongest[1], len(fmt.Sprintf("%.2f", sco/sh.problems[problem_id].Weight)))
-		ColLongest[2] = max(ColLongest[2], len(fmt.Sprintf("%.2f", sh.problems[problem_id].Weight)))
-		ColLongest[3] = max(ColLongest[3], len(user.BestSubmits[problem_id]))
-		ColLongest[4] = max(ColLongest[4], len(time.Unix(0, user.BestSubmitDate[problem_id]).Format(time.DateTime+" MST")))
-	}
 
-	for i, col := range Cols {
-		uf.Printf("%-*s ", ColLongest[i], col)
+		table.AddRow(
+			problem_id,
+			aurora.Bold(types.ColorizeScore(types.JudgeResult{Success: solved, Score: sco / weight})),
+			weight,
+			types.RoundScore(sco*factor),
+			types.RoundScore(full*weight*factor),
+			aurora.Colorize(formatPercent(pct), types.ColorizeScore(types.JudgeResult{Success: solved, Score: pct}).Color()),
+			user.BestSubmits[problem_id],
+			date,
+			formatAttempts(attempts[problem_id]),
+		)
 	}
 
+	table.Render(uf)
+
 	uf.Println()
-	for _, problem_id := range prblmss {
-		uf.Printf("%-*s %-*.2f %-*.2f %-*s %-*s\n",
-			ColLongest[0], aurora.Bold(aurora.Italic(problem_id)),
-			ColLongest[1], aurora.Bold(types.ColorizeScore(types.JudgeResult{Success: map_succ[problem_id], Score: user.BestScores[problem_id] / sh.problems[problem_id].Weight})),
-			ColLongest[2], aurora.Bold(sh.problems[problem_id].Weight),
-			ColLongest[3], aurora.Magenta(user.BestSubmits[problem_id]),
-			ColLongest[4],
-			func() aurora.Value {
-				if map_succ[problem_id] {
-					return aurora.Yellow(time.Unix(0, user.BestSubmitDate[problem_id]).Format(time.DateTime + " MST"))
-				} else {
-					return aurora.Gray(15, "N/A")
-				}
-			}())
+	uf.Println("Total Score:", aurora.Bold(aurora.BrightWhite(types.FormatScore(user.TotalScore))), "/", aurora.Gray(15, types.FormatScore(sh.dbService.MaxTotalScore())))
+	total := progress(earned, maximum)
+	uf.Println("Completion:", aurora.Bold(aurora.Colorize(formatPercent(total), types.ColorizeScore(types.JudgeResult{Success: true, Score: total}).Color())))
+}
+
+// progress 计算得分占满分的百分比
+func progress(score, full float64) float64 {
+	if full <= 0 {
+		return 0
 	}
+	return score / full * 100
+}
 
-	uf.Println()
-	uf.Println("Total Score:", aurora.Bold(aurora.BrightWhite(user.TotalScore)))
+// formatPercent 格式化百分比
+func formatPercent(pct float64) string {
+	return fmt.Sprintf("%.1f%%", pct)
+}
+
+// handleTodo 列出用户尚未通过的问题，按权重从高到低排序
+func (sh *SSHHandler) handleTodo(s ssh.Session, uf types.Userface) {
+	var solved types.JMapStrFloat64
+	if user, err := sh.dbService.GetUserByID(s.User()); err == nil {
+		solved = user.BestScores
+	}
+
+	// 非管理员不显示尚未开放的问题
+	admin := sh.dbService.IsAdmin(s.User())
+	now := time.Now()
+
+	var todo []types.Problem
+	for id, problem := range sh.problems {
+		if _, ok := solved[id]; ok {
+			continue
+		}
+		if !admin && problem.NotYetOpen(now) {
+			continue
+		}
+		if !sh.dbService.CanAccessProblem(s.User(), id) {
+			continue
+		}
+		todo = append(todo, problem)
+	}
+
+	if len(todo) == 0 {
+		uf.Println(aurora.Green("All problems solved"))
+		return
+	}
+
+	sort.Slice(todo, func(i, j int) bool {
+		if todo[i].Weight != todo[j].Weight {
+			return todo[i].Weight > todo[j].Weight
+		}
+		return todo[i].Id < todo[j].Id
+	})
+
+	uf.Println(aurora.Green("Unsolved"), aurora.Bold("problems"), aurora.Gray(15, "("+strconv.Itoa(len(todo))+")"))
+
+	var ids, weights, states, urls []string
+	for _, problem := range todo {
+		ids = append(ids, problem.Id)
+		weights = append(weights, fmt.Sprintf("%.2f", problem.Weight))
+		switch {
+		case problem.NotYetOpen(now):
+			states = append(states, "opens "+types.FormatTime(problem.OpenTime))
+		case problem.Closed(now):
+			states = append(states, "locked")
+		case !problem.CloseTime.IsZero():
+			states = append(states, "closes "+types.FormatTime(problem.CloseTime))
+		default:
+			states = append(states, "open")
+		}
+		urls = append(urls, sh.cfg.ProblemURLPrefix+problem.Id)
+	}
+
+	sh.mkTable(uf, []string{"Problem", "Weight", "Status", "Statement"},
+		[]aurora.Color{aurora.BoldFm | aurora.ItalicFm, aurora.BoldFm | aurora.GreenFg, aurora.YellowFg, aurora.BlueFg},
+		[][]string{ids, weights, states, urls})
+}
+
+// handleQuota 显示用户提交目录的存储占用和剩余配额
+func (sh *SSHHandler) handleQuota(s ssh.Session, uf types.Userface) {
+	usage, err := file_transfer.DirUsage(path.Join(sh.cfg.SubmitsDir, s.User()))
+	if err != nil && !os.IsNotExist(err) {
+		uf.Println(aurora.Red("error:"), "failed to calculate storage usage")
+		log.Error().Err(err).Str("user", s.User()).Msg("failed to calculate storage usage")
+		return
+	}
+
+	uf.Println("Used:", aurora.Bold(formatBytes(usage)))
+	if sh.cfg.UserQuotaBytes <= 0 {
+		uf.Println("Quota:", aurora.Gray(15, "unlimited"))
+		return
+	}
+
+	remaining := max(sh.cfg.UserQuotaBytes-usage, 0)
+	uf.Println("Quota:", aurora.Bold(formatBytes(sh.cfg.UserQuotaBytes)))
+	if remaining == 0 {
+		uf.Println("Remaining:", aurora.Red(formatBytes(remaining)), "- delete files to upload more")
+	} else {
+		uf.Println("Remaining:", aurora.Green(formatBytes(remaining)))
+	}
+}
+
+// formatBytes 以二进制单位格式化字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 // handleToken 处理token命令
-func (sh *SSHHandler) handleToken(s ssh.Session, uf types.Userface) {
+func (sh *SSHHandler) handleToken(s ssh.Session, uf types.Userface, cmds []string) {
+	if len(cmds) > 2 || (len(cmds) == 2 && cmds[1] != "rotate") {
+		uf.Println(aurora.Red("error:"), "invalid arguments")
+		uf.Println("usage: token [rotate]")
+		return
+	}
+
+	if len(cmds) == 2 {
+		user, err := sh.dbService.RotateUserToken(s.User())
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to rotate token")
+			return
+		}
+		uf.Println(aurora.Green("Token rotated,"), "the old token no longer works")
+		uf.Println("Your new token is:", aurora.Bold(user.Token), "please keep it secret")
+		return
+	}
+
 	user, err := sh.dbService.GetUserByID(s.User())
 	if err != nil {
 		uf.Println(aurora.Red("error:"), "failed to get user token")
@@ -289,6 +620,126 @@ func (sh *SSHHandler) handleToken(s ssh.Session, uf types.Userface) {
 	uf.Println("Your token is:", aurora.Bold(user.Token), "please keep it secret")
 }
 
+// handleAnonymous 查看或设置是否在排行榜上显示化名
+func (sh *SSHHandler) handleAnonymous(s ssh.Session, uf types.Userface, cmds []string) {
+	if len(cmds) > 2 || (len(cmds) == 2 && cmds[1] != "on" && cmds[1] != "off") {
+		uf.Println(aurora.Red("error:"), "invalid arguments")
+		uf.Println("usage: anonymous [on|off]")
+		return
+	}
+
+	if len(cmds) == 2 {
+		err := sh.dbService.SetUserAnonymous(s.User(), cmds[1] == "on")
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to update setting")
+			return
+		}
+	}
+
+	user, err := sh.dbService.GetUserByID(s.User())
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get user")
+		return
+	}
+
+	switch {
+	case sh.cfg.AnonymizeRank:
+		uf.Println("The ranking is anonymized for everyone, others see you as", aurora.Bold(sh.dbService.Pseudonym(user.ID)))
+	case user.Anonymous:
+		uf.Println(aurora.Green("Anonymous:"), "on, others see you as", aurora.Bold(sh.dbService.Pseudonym(user.ID)))
+	default:
+		uf.Println(aurora.Yellow("Anonymous:"), "off, others see your username on the ranking")
+	}
+}
+
+// solutionsDenied 返回用户不能查看问题他人代码的原因，可以查看时返回空字符串
+func (sh *SSHHandler) solutionsDenied(user string, problem *types.Problem, now time.Time) string {
+	if sh.dbService.IsAdmin(user) {
+		return ""
+	}
+	if !problem.SolutionsVisible(sh.cfg) {
+		return "viewing solutions is disabled for this problem"
+	}
+	if sh.cfg.ContestActive(now) {
+		return "viewing solutions is disabled during the contest"
+	}
+
+	u, err := sh.dbService.GetUserByID(user)
+	if err != nil {
+		return "failed to get user"
+	}
+	if _, solved := u.BestScores[problem.Id]; !solved {
+		return "you have not solved " + problem.Id + " yet"
+	}
+	return ""
+}
+
+// handleSolutions 处理查看他人代码命令，仅对已通过该问题的用户开放
+func (sh *SSHHandler) handleSolutions(s ssh.Session, uf types.Userface, cmds []string) {
+	if len(cmds) != 2 {
+		uf.Println(aurora.Red("error:"), "invalid arguments")
+		uf.Println("usage: solutions <problem_id>")
+		return
+	}
+
+	pid := cmds[1]
+	problem, ok := sh.problems[pid]
+	if !ok || !sh.dbService.CanAccessProblem(s.User(), pid) {
+		uf.Println(aurora.Red("error:"), "problem", aurora.Yellow(strconv.Quote(pid)), "not found")
+		return
+	}
+
+	admin := sh.dbService.IsAdmin(s.User())
+
+	if reason := sh.solutionsDenied(s.User(), &problem, time.Now()); reason != "" {
+		uf.Println(aurora.Red("error:"), reason)
+		return
+	}
+
+	limit := sh.cfg.ShowSolutionsLimit
+	if limit <= 0 {
+		limit = 3
+	}
+
+	submits, err := sh.dbService.GetSolutionsForProblem(pid, s.User(), limit)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get solutions")
+		return
+	}
+
+	uf.Println(aurora.Green("Showing"), aurora.Bold("solutions"), "for", aurora.Bold(pid))
+
+	if len(submits) == 0 {
+		uf.Println(aurora.Gray(15, "No solutions yet"))
+		return
+	}
+
+	for i, submit := range submits {
+		name := "Solution #" + strconv.Itoa(i+1)
+		if admin {
+			name += " (" + submit.User + ", " + submit.ID + ")"
+		}
+		uf.Println()
+		uf.Println(aurora.Bold(aurora.Cyan(name)), "Score", types.ColorizeScore(submit.JudgeResult))
+
+		for _, f := range submit.SubmitsHashes {
+			uf.Println("	*", aurora.Yellow(f.Path), ":", aurora.Blue(f.Display()))
+			content, err := os.ReadFile(path.Join(submit.Workdir, "submits", f.Path))
+			if err != nil {
+				uf.Println(aurora.Gray(15, "	file is no longer available"))
+				continue
+			}
+			if len(content) > maxSolutionFileSize {
+				content = content[:maxSolutionFileSize]
+				uf.Println(types.SanitizeTerminal(string(content), false))
+				uf.Println(aurora.Gray(15, "	... (truncated)"))
+				continue
+			}
+			uf.Println(types.SanitizeTerminal(string(content), false))
+		}
+	}
+}
+
 // handleAdminUserSummary 处理管理员查看用户摘要命令
 func (sh *SSHHandler) handleAdminUserSummary(uf types.Userface, targetUser string) {
 	uf.Println("User", aurora.Bold(aurora.BrightWhite(targetUser)))
@@ -299,6 +750,8 @@ func (sh *SSHHandler) handleAdminUserSummary(uf types.Userface, targetUser strin
 		return
 	}
 
+	attempts := sh.userAttempts(targetUser)
+
 	var prblmss []string
 	for k := range sh.problems {
 		prblmss = append(prblmss, k)
@@ -306,7 +759,7 @@ func (sh *SSHHandler) handleAdminUserSummary(uf types.Userface, targetUser strin
 
 	sort.Strings(prblmss)
 
-	Cols := []string{"Problem", "Score", "Weight", "Submit ID", "Date"}
+	Cols := []string{"Problem", "Score", "Weight", "Submit ID", "Date", "Attempts"}
 	var ColLongest = make([]int, len(Cols))
 	for i, col := range Cols {
 		ColLongest[i] = len(col)
@@ -320,10 +773,11 @@ func (sh *SSHHandler) handleAdminUserSummary(uf types.Userface, targetUser strin
 			map_succ[problem_id] = true
 		}
 		ColLongest[0] = max(ColLongest[0], len(problem_id))
-		ColLongest[1] = max(ColLongest[1], len(fmt.Sprintf("%.2f", sco/sh.problems[problem_id].Weight)))
+		ColLongest[1] = max(ColLongest[1], len(types.FormatScore(sco/sh.problems[problem_id].Weight)))
 		ColLongest[2] = max(ColLongest[2], len(fmt.Sprintf("%.2f", sh.problems[problem_id].Weight)))
 		ColLongest[3] = max(ColLongest[3], len(user.BestSubmits[problem_id]))
-		ColLongest[4] = max(ColLongest[4], len(time.Unix(0, user.BestSubmitDate[problem_id]).Format(time.DateTime+" MST")))
+		ColLongest[4] = max(ColLongest[4], len(types.FormatUnixNano(user.BestSubmitDate[problem_id])))
+		ColLongest[5] = max(ColLongest[5], len(formatAttempts(attempts[problem_id])))
 	}
 
 	for i, col := range Cols {
@@ -332,26 +786,34 @@ func (sh *SSHHandler) handleAdminUserSummary(uf types.Userface, targetUser strin
 
 	uf.Println()
 	for _, problem_id := range prblmss {
-		uf.Printf("%-*s %-*.2f %-*.2f %-*s %-*s\n",
+		score := types.ColorizeScore(types.JudgeResult{Success: map_succ[problem_id], Score: user.BestScores[problem_id] / sh.problems[problem_id].Weight})
+		uf.Printf("%-*s %-*s %-*.2f %-*s %-*s %-*s\n",
 			ColLongest[0], aurora.Bold(aurora.Italic(problem_id)),
-			ColLongest[1], aurora.Bold(types.ColorizeScore(types.JudgeResult{Success: map_succ[problem_id], Score: user.BestScores[problem_id] / sh.problems[problem_id].Weight})),
+			ColLongest[1], aurora.Bold(aurora.Colorize(types.FormatScore(user.BestScores[problem_id]/sh.problems[problem_id].Weight), score.Color())),
 			ColLongest[2], aurora.Bold(sh.problems[problem_id].Weight),
 			ColLongest[3], aurora.Magenta(user.BestSubmits[problem_id]),
 			ColLongest[4],
 			func() aurora.Value {
 				if map_succ[problem_id] {
-					return aurora.Yellow(time.Unix(0, user.BestSubmitDate[problem_id]).Format(time.DateTime + " MST"))
+					return aurora.Yellow(types.FormatUnixNano(user.BestSubmitDate[problem_id]))
 				} else {
 					return aurora.Gray(15, "N/A")
 				}
-			}())
+			}(),
+			ColLongest[5], aurora.Cyan(formatAttempts(attempts[problem_id])))
 	}
 
 	uf.Println()
-	uf.Println("Total Score:", aurora.Bold(aurora.BrightWhite(user.TotalScore)))
+	uf.Println("Total Score:", aurora.Bold(aurora.BrightWhite(types.FormatScore(user.TotalScore))), "/", aurora.Gray(15, types.FormatScore(sh.dbService.MaxTotalScore())))
 
 	// Additional admin info
 	uf.Println("Token:", aurora.Gray(15, user.Token))
+	if user.Banned {
+		uf.Println("Banned:", aurora.Red("yes"), "by", aurora.Blue(user.BannedBy), "at", aurora.Yellow(types.FormatUnixNano(user.BannedAt)))
+		if user.BanReason != "" {
+			uf.Println("Ban Reason:", aurora.Cyan(user.BanReason))
+		}
+	}
 }
 
 // handleAdminModifySubmission 处理管理员修改提交命令
@@ -401,7 +863,7 @@ func (sh *SSHHandler) handleAdminModifySubmission(uf types.Userface, args []stri
 	uf.Println(aurora.Green("Success:"), "Modified submit", aurora.Magenta(submitID))
 	uf.Println("  User:", aurora.Blue(submit.User))
 	uf.Println("  Problem:", aurora.Bold(submit.Problem))
-	uf.Println("  Score:", aurora.Yellow(fmt.Sprintf("%.2f", originalScore)), "→", aurora.Green(fmt.Sprintf("%.2f", score)))
+	uf.Println("  Score:", aurora.Yellow(types.FormatScore(originalScore)), "→", aurora.Green(types.FormatScore(score)))
 	if message != "" {
 		uf.Println("  Message:", aurora.Gray(15, originalMessage), "→", aurora.Cyan(message))
 	}
@@ -423,6 +885,13 @@ func (sh *SSHHandler) handleAdmin(s ssh.Session, uf types.Userface, cmds []strin
 		uf.Println("usage: adm <command>")
 		return
 	}
+
+	capability := adminCommandCapability(cmds[1])
+	if !sh.dbService.HasCapability(s.User(), capability) {
+		uf.Println(aurora.Red("error:"), "permission denied:", aurora.Bold(cmds[1]), "requires", aurora.Yellow(capability), "capability")
+		return
+	}
+
 	switch cmds[1] {
 	case "list":
 		if len(cmds) > 4 {
@@ -477,7 +946,7 @@ func (sh *SSHHandler) handleAdmin(s ssh.Session, uf types.Userface, cmds []strin
 
 		uf.Println(aurora.Cyan("Page"), aurora.Bold(page), "of", aurora.Yellow(total/20+1))
 
-		sh.listSubs(uf, submits)
+		sh.listSubs(uf, submits, false)
 	case "status":
 		if len(cmds) != 3 {
 			uf.Println(aurora.Red("error:"), "invalid arguments")
@@ -495,10 +964,21 @@ func (sh *SSHHandler) handleAdmin(s ssh.Session, uf types.Userface, cmds []strin
 
 		uf.Println()
 
-		sh.showSub(uf, *submit)
-	case "pause":
-		sh.SetPaused(true)
-		uf.Println(aurora.Green("Submit"), aurora.Bold("paused"))
+		sh.showSub(uf, *submit, false)
+		sh.showWorkflowSteps(uf, *submit)
+		sh.showStatusTimeline(uf, *submit)
+	case "pause", "resume":
+		err := sh.state.SetPaused(cmds[1] == "pause")
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to save pause state:", err.Error())
+			return
+		}
+		log.Info().Str("admin", s.User()).Bool("paused", sh.state.Paused()).Msg("changed pause state")
+		if sh.state.Paused() {
+			uf.Println(aurora.Green("Submit"), aurora.Bold("paused"))
+		} else {
+			uf.Println(aurora.Green("Submit"), aurora.Bold("resumed"))
+		}
 	case "delete":
 		if len(cmds) != 3 {
 			uf.Println(aurora.Red("error:"), "invalid arguments")
@@ -526,6 +1006,9 @@ func (sh *SSHHandler) handleAdmin(s ssh.Session, uf types.Userface, cmds []strin
 		uf.Println("  User:", aurora.Blue(submit.User))
 		uf.Println("  Problem:", aurora.Bold(submit.Problem))
 		uf.Println("  User records have been updated")
+		if user, err := sh.dbService.LookupUser(submit.User); err == nil {
+			uf.Println("  Recalculated total score:", aurora.Bold(types.FormatScore(user.TotalScore)))
+		}
 
 	case "user":
 		if len(cmds) != 3 {
@@ -542,67 +1025,938 @@ func (sh *SSHHandler) handleAdmin(s ssh.Session, uf types.Userface, cmds []strin
 		}
 		sh.handleAdminModifySubmission(uf, cmds[2:])
 	case "reload":
-		// 这个功能需要在main.go中实现
-		uf.Println("Reload functionality will be implemented in main.go")
-	}
-}
-
-// listSubs 列出提交
-func (sh *SSHHandler) listSubs(uf types.Userface, submits []types.SubmitCtx) {
-	if len(submits) == 0 {
-		uf.Println(aurora.Gray(15, "No submissions yet"))
-	} else {
-		Cols := []string{"ID", "User", "Problem", "Status", "Message", "Score", "Judge Message", "Date"}
-		var ColLongest = make([]int, len(Cols))
-		for i, col := range Cols {
-			ColLongest[i] = len(col)
+		problems, err := sh.problemManager.ReloadProblemDir(sh.cfg.ProblemsDir)
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to reload problems:", err.Error())
+			return
 		}
-
-		for _, submit := range submits {
-			ColLongest[0] = max(ColLongest[0], len(submit.ID))
-			ColLongest[1] = max(ColLongest[1], len(submit.User))
-			ColLongest[2] = max(ColLongest[2], len(submit.Problem))
-			ColLongest[3] = max(ColLongest[3], len(submit.Status))
-			ColLongest[4] = max(ColLongest[4], len(submit.Msg))
-			ColLongest[5] = max(ColLongest[5], len(fmt.Sprintf("%.2f", submit.JudgeResult.Score)))
-			ColLongest[6] = max(ColLongest[6], len(sh.omitStr(submit.JudgeResult.Msg, 20)))
-			ColLongest[7] = max(ColLongest[7], len(time.Unix(0, submit.SubmitTime).Format(time.DateTime)))
+		sh.UpdateProblems(problems)
+		log.Info().Str("admin", s.User()).Int("problems", len(problems)).Msg("reloaded problems")
+		uf.Println(aurora.Green("Success:"), "Reloaded", aurora.Bold(len(problems)), "problems")
+	case "attempts":
+		if len(cmds) > 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm attempts [username]")
+			return
 		}
-
-		for i, col := range Cols {
-			uf.Printf("%-*s ", ColLongest[i], col)
+		var username string
+		if len(cmds) == 3 {
+			username = cmds[2]
 		}
-		uf.Println()
-
-		for _, submit := range submits {
-			uf.Printf("%-*s %-*s %-*s %-*s %-*s %-*.2f %-*s %-*s\n",
-				ColLongest[0], aurora.Magenta(submit.ID),
-				ColLongest[1], aurora.Blue(submit.User),
-				ColLongest[2], aurora.Bold(submit.Problem),
-				ColLongest[3], types.ColorizeStatus(submit.Status),
-				ColLongest[4], aurora.Gray(15, submit.Msg),
-				ColLongest[5], types.ColorizeScore(submit.JudgeResult),
-				ColLongest[6], aurora.Gray(15, sh.omitStr(submit.JudgeResult.Msg, 20)),
-				ColLongest[7], aurora.Yellow(time.Unix(0, submit.SubmitTime).Format(time.DateTime)))
+		sh.handleAdminAttempts(uf, username)
+	case "firstblood", "fb":
+		sh.handleAdminFirstBlood(uf)
+	case "snapshot":
+		if len(cmds) > 3 || (len(cmds) == 3 && cmds[2] != "--freeze") {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm snapshot [--freeze]")
+			return
 		}
-	}
+		sh.handleAdminSnapshot(s, uf, len(cmds) == 3)
+	case "ban":
+		if len(cmds) < 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm ban <username> [reason]")
+			return
+		}
+		user, err := sh.dbService.SetUserBanned(cmds[2], true, strings.Join(cmds[3:], " "), s.User())
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to ban user:", err.Error())
+			return
+		}
+		uf.Println(aurora.Green("Success:"), "Banned user", aurora.Blue(user.ID))
+		if user.BanReason != "" {
+			uf.Println("  Reason:", aurora.Cyan(user.BanReason))
+		}
+	case "unban":
+		if len(cmds) != 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm unban <username>")
+			return
+		}
+		user, err := sh.dbService.SetUserBanned(cmds[2], false, "", s.User())
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to unban user:", err.Error())
+			return
+		}
+		uf.Println(aurora.Green("Success:"), "Unbanned user", aurora.Blue(user.ID))
+	case "trends":
+		if len(cmds) != 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm trends <problem_id>")
+			return
+		}
+		sh.handleAdminTrends(uf, cmds[2])
+	case "putproblem":
+		if len(cmds) != 2 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm putproblem < problem.yaml")
+			return
+		}
+		sh.handleAdminPutProblem(s, uf)
+	case "gc-workdirs":
+		if len(cmds) > 3 || (len(cmds) == 3 && cmds[2] != "--yes") {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm gc-workdirs [--yes]")
+			return
+		}
+		sh.handleAdminGCWorkdirs(s, uf, len(cmds) == 3)
+	case "broadcast":
+		if len(cmds) < 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm broadcast <message>")
+			return
+		}
+		sh.handleAdminBroadcast(s, uf, strings.Join(cmds[2:], " "))
+	case "group":
+		if len(cmds) < 3 || len(cmds) > 4 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm group <username> [group|--clear]")
+			return
+		}
+		sh.handleAdminGroup(s, uf, cmds[2], cmds[3:])
+	case "import-users":
+		if len(cmds) != 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm import-users <file|->")
+			return
+		}
+		sh.handleAdminImportUsers(s, uf, cmds[2])
+	case "maintenance":
+		if len(cmds) > 3 || (len(cmds) == 3 && cmds[2] != "on" && cmds[2] != "off") {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm maintenance [on|off]")
+			return
+		}
+		if len(cmds) == 3 {
+			err := sh.state.SetMaintenance(cmds[2] == "on")
+			if err != nil {
+				uf.Println(aurora.Red("error:"), "failed to save maintenance state:", err.Error())
+				return
+			}
+			log.Info().Str("admin", s.User()).Bool("maintenance", sh.state.Maintenance()).Msg("changed maintenance mode")
+		}
+		if sh.state.Maintenance() {
+			uf.Println("Maintenance mode is", aurora.Yellow("on"))
+		} else {
+			uf.Println("Maintenance mode is", aurora.Green("off"))
+		}
+	case "setting":
+		if len(cmds) > 4 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm setting [key [value]]")
+			return
+		}
+		sh.handleAdminSetting(s, uf, cmds[2:])
+	case "capacity":
+		sh.handleAdminCapacity(uf)
+	case "stats-global":
+		sh.handleAdminStatsGlobal(uf)
+	case "rejudge-problem":
+		if len(cmds) != 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm rejudge-problem <problem_id>")
+			return
+		}
+		sh.handleAdminRejudgeProblem(s, uf, cmds[2])
+	case "validate":
+		if len(cmds) != 3 {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm validate <problem_id>")
+			return
+		}
+		sh.handleAdminValidate(s, uf, cmds[2])
+	case "rejudge":
+		if len(cmds) != 5 || cmds[3] != "--with" {
+			uf.Println(aurora.Red("error:"), "invalid arguments")
+			uf.Println("usage: adm rejudge <submit_id> --with <problem_file>")
+			return
+		}
+		sh.handleAdminDryRejudge(s, uf, cmds[2], cmds[4])
+	}
+}
+
+// handleAdminSetting 查看或修改运行时设置，修改后立即生效并持久化
+func (sh *SSHHandler) handleAdminSetting(s ssh.Session, uf types.Userface, args []string) {
+	keys := types.SettingKeys
+	if len(args) >= 1 {
+		keys = []string{args[0]}
+	}
+
+	if len(args) == 2 {
+		err := sh.state.Set(args[0], args[1])
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to set", aurora.Bold(args[0]+":"), err.Error())
+			return
+		}
+		log.Info().Str("admin", s.User()).Str("key", args[0]).Str("value", args[1]).Msg("changed setting")
+	}
+
+	var values []string
+	for _, key := range keys {
+		value, err := sh.state.Get(key)
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "unknown setting", aurora.Yellow(strconv.Quote(key)))
+			uf.Println("known settings:", strings.Join(types.SettingKeys, ", "))
+			return
+		}
+		if value == "" {
+			value = "-"
+		}
+		values = append(values, value)
+	}
+
+	sh.mkTable(uf, []string{"Setting", "Value"},
+		[]aurora.Color{aurora.BoldFm, aurora.CyanFg},
+		[][]string{keys, values})
+}
+
+// maxRosterSize adm import-users 读取名单的最大字节数
+const maxRosterSize = 1 << 20
+
+// handleAdminImportUsers 从名单批量创建用户，每行为"用户名 [token]"，空行和#开头的行被忽略
+// file为"-"时从标准输入读取，已存在的用户被跳过
+func (sh *SSHHandler) handleAdminImportUsers(s ssh.Session, uf types.Userface, file string) {
+	var r io.Reader = s
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			uf.Println(aurora.Red("error:"), "failed to open roster:", err.Error())
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxRosterSize+1))
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to read roster:", err.Error())
+		return
+	}
+	if len(data) > maxRosterSize {
+		uf.Println(aurora.Red("error:"), "roster is too large")
+		return
+	}
+
+	var users, tokens, results []string
+	var created int
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			uf.Println(aurora.Red("error:"), "line", aurora.Yellow(i+1), "has too many fields, stopped")
+			break
+		}
+
+		id, token := fields[0], ""
+		if len(fields) == 2 {
+			token = fields[1]
+		}
+
+		users = append(users, id)
+		if _, err := sh.dbService.LookupUser(id); err == nil {
+			tokens = append(tokens, "-")
+			results = append(results, "exists")
+			continue
+		}
+		if token != "" {
+			if _, err := sh.dbService.GetUserByToken(token); err == nil {
+				tokens = append(tokens, "-")
+				results = append(results, "token in use")
+				continue
+			}
+		}
+
+		user, err := sh.dbService.CreateUserWithToken(id, token)
+		if err != nil {
+			tokens = append(tokens, "-")
+			results = append(results, "failed: "+err.Error())
+			continue
+		}
+		created++
+		tokens = append(tokens, user.Token)
+		results = append(results, "created")
+	}
+
+	log.Info().Str("admin", s.User()).Str("file", file).Int("users", len(users)).Int("created", created).Msg("imported users")
+
+	if len(users) > 0 {
+		sh.mkTable(uf, []string{"User", "Token", "Result"},
+			[]aurora.Color{aurora.BlueFg, aurora.YellowFg, aurora.BoldFm},
+			[][]string{users, tokens, results})
+		uf.Println()
+	}
+	uf.Println(aurora.Green("Success:"), "Created", aurora.Bold(created), "of", aurora.Bold(len(users)), "users")
+}
+
+// capacitySamples adm capacity 统计平均耗时使用的最近提交数
+const capacitySamples = 50
+
+// handleAdminCapacity 显示评测容量：工作线程数、运行中和排队中的评测以及最近的平均评测耗时
+func (sh *SSHHandler) handleAdminCapacity(uf types.Userface) {
+	queue := sh.evaluator.Queue()
+
+	uf.Println(aurora.Green("Judge"), aurora.Bold("capacity"))
+	uf.Println("Workers:", aurora.Bold(queue.Workers()))
+	uf.Println("Running:", aurora.Cyan(queue.Running()))
+	uf.Println("Queued:", aurora.Yellow(queue.Waiting()))
+
+	submits, err := sh.dbService.GetRecentFinishedSubmits(capacitySamples)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get recent submissions")
+		return
+	}
+	if len(submits) == 0 {
+		uf.Println("Recent judges:", aurora.Gray(15, "N/A"))
+		return
+	}
+
+	var judgeTime, wallTime time.Duration
+	for _, submit := range submits {
+		judgeTime += submit.JudgeDuration()
+		wallTime += time.Duration(submit.LastUpdate - submit.SubmitTime)
+	}
+	n := time.Duration(len(submits))
+
+	uf.Println("Recent judges:", aurora.Bold(len(submits)))
+	uf.Println("	Avg judge time:", aurora.Cyan((judgeTime / n).Round(time.Millisecond)), aurora.Gray(15, "(workflow steps)"))
+	uf.Println("	Avg wall time:", aurora.Cyan((wallTime / n).Round(time.Millisecond)), aurora.Gray(15, "(including queueing)"))
+	if avg := judgeTime / n; avg > 0 && queue.Waiting() > 0 {
+		wait := avg * time.Duration(queue.Waiting()) / time.Duration(max(queue.Workers(), 1))
+		uf.Println("	Est. queue drain:", aurora.Yellow(wait.Round(time.Second)))
+	}
+}
+
+// gcMinAge 最近修改时间在此之内的工作目录不会被清理，避免删除正在创建或试评测中的目录
+const gcMinAge = time.Hour
+
+// orphanWorkdir 没有对应有效提交的工作目录
+type orphanWorkdir struct {
+	name    string
+	reason  string
+	size    int64
+	modTime time.Time
+}
+
+// handleAdminGCWorkdirs 列出并清理SubmitWorkDir中没有对应有效提交的工作目录
+// 已删除或dead提交的目录视为孤立目录，yes为false时在交互式会话中确认后删除
+func (sh *SSHHandler) handleAdminGCWorkdirs(s ssh.Session, uf types.Userface, yes bool) {
+	statuses, err := sh.dbService.GetWorkdirStatuses()
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get submissions")
+		return
+	}
+
+	entries, err := os.ReadDir(sh.cfg.SubmitWorkDir)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to read work dir:", err.Error())
+		return
+	}
+
+	now := time.Now()
+	var orphans []orphanWorkdir
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		status, ok := statuses[entry.Name()]
+		if ok && status != "dead" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < gcMinAge {
+			continue
+		}
+
+		reason := "no submission"
+		if ok {
+			reason = "dead submission"
+		}
+		size, _ := file_transfer.DirUsage(path.Join(sh.cfg.SubmitWorkDir, entry.Name()))
+		orphans = append(orphans, orphanWorkdir{name: entry.Name(), reason: reason, size: size, modTime: info.ModTime()})
+		total += size
+	}
+
+	if len(orphans) == 0 {
+		uf.Println(aurora.Green("No orphaned work directories"))
+		return
+	}
+
+	var names, reasons, sizes, mtimes []string
+	for _, o := range orphans {
+		names = append(names, o.name)
+		reasons = append(reasons, o.reason)
+		sizes = append(sizes, formatBytes(o.size))
+		mtimes = append(mtimes, types.FormatTime(o.modTime))
+	}
+	uf.Println(aurora.Yellow("Orphaned"), aurora.Bold("work directories"), aurora.Gray(15, "("+strconv.Itoa(len(orphans))+", "+formatBytes(total)+")"))
+	sh.mkTable(uf, []string{"Directory", "Reason", "Size", "Modified"},
+		[]aurora.Color{aurora.MagentaFg, aurora.Color(0).Gray(15), aurora.CyanFg, aurora.YellowFg},
+		[][]string{names, reasons, sizes, mtimes})
+
+	if !yes {
+		if _, _, isPty := s.Pty(); !isPty {
+			uf.Println("Run", aurora.Bold("adm gc-workdirs --yes"), "to delete them")
+			return
+		}
+		if !Confirm(s, uf, "Delete "+strconv.Itoa(len(orphans))+" directories?") {
+			uf.Println(aurora.Yellow("Cancelled"))
+			return
+		}
+	}
+
+	var removed int
+	var reclaimed int64
+	for _, o := range orphans {
+		if err := os.RemoveAll(path.Join(sh.cfg.SubmitWorkDir, o.name)); err != nil {
+			uf.Println(aurora.Red("error:"), "failed to delete", aurora.Magenta(o.name).String()+":", err.Error())
+			continue
+		}
+		removed++
+		reclaimed += o.size
+	}
+
+	log.Info().Str("admin", s.User()).Int("dirs", removed).Int64("bytes", reclaimed).Msg("pruned orphaned work directories")
+	uf.Println(aurora.Green("Success:"), "Deleted", aurora.Bold(removed), "directories, reclaimed", aurora.Bold(formatBytes(reclaimed)))
+}
+
+// Confirm 输出提示并等待用户输入y/n确认
+func Confirm(s ssh.Session, uf types.Userface, prompt string) bool {
+	uf.Printf("%s [y/N] ", prompt)
+
+	buf := make([]byte, 1)
+	for {
+		_, err := s.Read(buf)
+		if err != nil {
+			uf.Println()
+			return false
+		}
+		switch buf[0] {
+		case 'y', 'Y':
+			uf.Println("y")
+			return true
+		case 'n', 'N', '\r', '\n', 0x03, 0x04:
+			uf.Println("n")
+			return false
+		}
+	}
+}
+
+// handleAdminGroup 查看或设置用户的问题分组
+func (sh *SSHHandler) handleAdminGroup(s ssh.Session, uf types.Userface, target string, args []string) {
+	if len(args) == 1 {
+		group := args[0]
+		if group == "--clear" {
+			group = ""
+		}
+		if group != "" && !sh.cfg.GroupExists(group) {
+			uf.Println(aurora.Red("error:"), "unknown group", aurora.Yellow(strconv.Quote(group)))
+			return
+		}
+		if err := sh.dbService.SetUserGroup(target, group); err != nil {
+			uf.Println(aurora.Red("error:"), "failed to set group:", err.Error())
+			return
+		}
+		log.Info().Str("admin", s.User()).Str("user", target).Str("group", group).Msg("changed user group")
+	}
+
+	user, err := sh.dbService.LookupUser(target)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "user", aurora.Yellow(strconv.Quote(target)), "not found")
+		return
+	}
+
+	if user.Group == "" {
+		access := sh.cfg.UngroupedAccess
+		if access == "" {
+			access = types.UngroupedAll
+		}
+		uf.Println("User", aurora.Bold(user.ID), "is not in a group", aurora.Gray(15, "(access: "+access+")"))
+		return
+	}
+	problems := sh.cfg.ProblemGroups[user.Group]
+	uf.Println("User", aurora.Bold(user.ID), "is in group", aurora.Cyan(user.Group), aurora.Gray(15, "("+strings.Join(problems, ", ")+")"))
+}
+
+// handleAdminStatsGlobal 显示全局提交统计信息
+func (sh *SSHHandler) handleAdminStatsGlobal(uf types.Userface) {
+	stats, err := sh.dbService.GetSubmitStatistics()
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get statistics")
+		return
+	}
+
+	uf.Println(aurora.Green("Global"), aurora.Bold("statistics"))
+	uf.Println("Total submits:", aurora.Bold(stats["total_submits"]))
+	uf.Println("	Completed:", aurora.Green(stats["success_submits"]))
+	uf.Println("	Rejected:", aurora.Red(stats["rejected_submits"]))
+	uf.Println("	Failed:", aurora.Magenta(stats["failed_submits"]), aurora.Gray(15, "(judge errors)"))
+	uf.Println("Submits in last 24h:", aurora.Cyan(stats["submits_24h"]))
+	uf.Println("Total users:", aurora.Bold(stats["total_users"]))
+}
+
+// handleAdminRejudgeProblem 通过评测队列重新评测某问题的所有已完成提交，结束后重新计算受影响用户的成绩
+func (sh *SSHHandler) handleAdminRejudgeProblem(s ssh.Session, uf types.Userface, pid string) {
+	problem, ok := sh.problemManager.GetProblem(pid)
+	if !ok {
+		uf.Println(aurora.Red("error:"), "problem", aurora.Yellow(strconv.Quote(pid)), "not found")
+		return
+	}
+
+	submits, err := sh.dbService.GetCompletedSubmitsByProblem(pid)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get submissions:", err.Error())
+		return
+	}
+	if len(submits) == 0 {
+		uf.Println(aurora.Gray(15, "No completed submissions for"), aurora.Bold(pid))
+		return
+	}
+
+	log.Info().Str("admin", s.User()).Str("problem", pid).Int("submits", len(submits)).Msg("rejudging problem")
+	uf.Println(aurora.Green("Rejudging"), aurora.Bold(len(submits)), "submissions of", aurora.Bold(pid),
+		aurora.Gray(15, "("+strconv.Itoa(sh.evaluator.Queue().Workers())+" workers)"))
+
+	type rejudged struct {
+		prev types.SubmitCtx
+		ctx  *types.SubmitCtx
+		err  error
+	}
+	results := make(chan rejudged)
+	for _, submit := range submits {
+		go func(prev types.SubmitCtx) {
+			ctx, err := sh.evaluator.Rejudge(&prev, &problem)
+			results <- rejudged{prev: prev, ctx: ctx, err: err}
+		}(submit)
+	}
+
+	users := make(map[string]bool)
+	var failed int
+	for i := range submits {
+		r := <-results
+		users[r.prev.User] = true
+		progress := aurora.Gray(15, "["+strconv.Itoa(i+1)+"/"+strconv.Itoa(len(submits))+"]")
+		if r.err != nil {
+			failed++
+			uf.Println(progress, aurora.Magenta(r.prev.ID), aurora.Blue(r.prev.User), aurora.Red("skipped:"), r.err.Error())
+			continue
+		}
+		if !types.HasJudgeResult(r.ctx.Status) {
+			failed++
+		}
+		line := []interface{}{progress, aurora.Magenta(r.prev.ID), aurora.Blue(r.prev.User), types.ColorizeStatus(r.ctx.Status),
+			types.ColorizeScore(r.prev.JudgeResult), "->",
+			types.ColorizeScore(r.ctx.JudgeResult)}
+		if r.prev.ProblemVersion != problem.Version {
+			// 与resubmit一致，提示提交时的问题版本已变化
+			line = append(line, aurora.Yellow("problem changed"),
+				"(version", aurora.Cyan(r.prev.ProblemVersion), "->", aurora.Cyan(problem.Version).String()+")")
+		}
+		uf.Println(line...)
+	}
+
+	for user := range users {
+		err := sh.dbService.RecalculateUserBestScoresWithProblems(user, sh.problems)
+		if err != nil {
+			log.Error().Err(err).Str("user", user).Msg("failed to recalculate user scores after rejudge")
+			uf.Println(aurora.Red("error:"), "failed to recalculate scores of", aurora.Blue(user+":"), err.Error())
+		}
+	}
+
+	uf.Println(aurora.Green("Success:"), "Rejudged", aurora.Bold(len(submits)-failed), "of", aurora.Bold(len(submits)), "submissions,",
+		"recalculated", aurora.Bold(len(users)), "users")
+}
+
+// handleAdminDryRejudge 使用另一份问题定义文件试评测提交，结果不写入数据库
+func (sh *SSHHandler) handleAdminDryRejudge(s ssh.Session, uf types.Userface, submitID string, file string) {
+	prev, err := sh.dbService.GetSubmitByID(submitID)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "submission", aurora.Magenta(submitID), "not found")
+		return
+	}
+
+	problem, err := sh.problemManager.ReadProblem(file)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to load problem file:", err.Error())
+		return
+	}
+	if problem.Id != prev.Problem {
+		uf.Println(aurora.Yellow("warning:"), "problem file defines", aurora.Bold(problem.Id+","), "submission is for", aurora.Bold(prev.Problem))
+	}
+
+	log.Info().Str("admin", s.User()).Str("submit", submitID).Str("problem_file", file).Msg("dry rejudging submission")
+	uf.Println(aurora.Green("Dry rejudging"), aurora.Magenta(submitID), "with", aurora.Yellow(file), aurora.Gray(15, "(result is not saved)"))
+
+	ctx, err := sh.evaluator.DryRejudge(prev, &problem, uf)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), err.Error())
+		return
+	}
+
+	uf.Println()
+	uf.Println("Status:", types.ColorizeStatus(ctx.Status), aurora.Gray(15, ctx.Msg))
+	uf.Println("Score:", types.ColorizeScore(prev.JudgeResult), "->", types.ColorizeScore(ctx.JudgeResult))
+}
+
+// handleAdminValidate 使用问题的参考解答试评测，检查测试数据和评测程序，参考解答应得满分
+func (sh *SSHHandler) handleAdminValidate(s ssh.Session, uf types.Userface, pid string) {
+	problem, ok := sh.problemManager.GetProblem(pid)
+	if !ok {
+		uf.Println(aurora.Red("error:"), "problem", aurora.Yellow(strconv.Quote(pid)), "not found")
+		return
+	}
+
+	log.Info().Str("admin", s.User()).Str("problem", pid).Msg("validating reference solution")
+	uf.Println(aurora.Green("Validating"), aurora.Bold(pid), "with reference solution", aurora.Yellow(problem.Reference), aurora.Gray(15, "(result is not saved)"))
+
+	ctx, err := sh.evaluator.ValidateReference(&problem, s.User(), uf)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), err.Error())
+		return
+	}
+
+	uf.Println()
+	uf.Println("Status:", types.ColorizeStatus(ctx.Status), aurora.Gray(15, ctx.Msg))
+	uf.Println("Score:", types.ColorizeScore(ctx.JudgeResult))
+	if len(ctx.JudgeResult.Msg) > 0 {
+		uf.Println("Message:", aurora.Cyan(ctx.JudgeResult.Msg))
+	}
+
+	if ctx.Status == "completed" && ctx.JudgeResult.Score >= sh.cfg.FullScore() {
+		uf.Println(aurora.Green("Reference solution passed"))
+		return
+	}
+	uf.Println(aurora.Red("Reference solution did not get full score, check the test data and checker"))
+	log.Warn().Str("problem", pid).Str("status", ctx.Status).Float64("score", ctx.JudgeResult.Score).Msg("reference solution did not get full score")
+}
+
+// userAttempts 获取用户每个问题首次通过前的尝试次数
+func (sh *SSHHandler) userAttempts(userID string) map[string]types.ProblemAttempts {
+	res := make(map[string]types.ProblemAttempts)
+	attempts, err := sh.dbService.GetAttempts(userID)
+	if err != nil {
+		log.Error().Err(err).Str("user", userID).Msg("failed to get attempts")
+		return res
+	}
+	for _, a := range attempts {
+		res[a.Problem] = a
+	}
+	return res
+}
+
+// formatAttempts 格式化尝试次数，未通过的问题标记为unsolved
+func formatAttempts(a types.ProblemAttempts) string {
+	if a.Solved {
+		return strconv.Itoa(a.Attempts)
+	}
+	if a.Attempts == 0 {
+		return "-"
+	}
+	return strconv.Itoa(a.Attempts) + " (unsolved)"
+}
+
+// handleAdminAttempts 处理管理员导出首次通过前尝试次数命令
+func (sh *SSHHandler) handleAdminAttempts(uf types.Userface, username string) {
+	attempts, err := sh.dbService.GetAttempts(username)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get attempts:", err.Error())
+		return
+	}
+
+	if len(attempts) == 0 {
+		uf.Println(aurora.Gray(15, "No submissions yet"))
+		return
+	}
+
+	var users, problems, counts, solved, firstSolves []string
+	for _, a := range attempts {
+		users = append(users, a.User)
+		problems = append(problems, a.Problem)
+		counts = append(counts, strconv.Itoa(a.Attempts))
+		solved = append(solved, strconv.FormatBool(a.Solved))
+		firstSolves = append(firstSolves, a.FirstSolveID)
+	}
+
+	sh.mkTable(uf, []string{"User", "Problem", "Attempts", "Solved", "First Solve"},
+		[]aurora.Color{aurora.BlueFg, aurora.BoldFm, aurora.YellowFg, aurora.WhiteFg, aurora.MagentaFg},
+		[][]string{users, problems, counts, solved, firstSolves})
+}
+
+// handleAdminFirstBlood 处理管理员查看一血命令
+func (sh *SSHHandler) handleAdminFirstBlood(uf types.Userface) {
+	firsts, err := sh.dbService.GetFirstSolves()
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get first solves:", err.Error())
+		return
+	}
+
+	solved := make(map[string]types.FirstSolve)
+	for _, f := range firsts {
+		solved[f.Problem] = f
+	}
+
+	var prblmss []string
+	for k := range sh.problems {
+		prblmss = append(prblmss, k)
+	}
+	sort.Strings(prblmss)
+
+	uf.Println(aurora.Green("Showing"), aurora.Bold("first blood"))
+
+	if len(prblmss) == 0 {
+		uf.Println(aurora.Gray(15, "No problems loaded"))
+		return
+	}
+
+	var users, ids, dates []string
+	for _, p := range prblmss {
+		f, ok := solved[p]
+		if !ok {
+			users = append(users, "N/A")
+			ids = append(ids, "")
+			dates = append(dates, "")
+			continue
+		}
+		users = append(users, f.User)
+		ids = append(ids, f.SubmitID)
+		dates = append(dates, types.FormatUnixNano(f.SubmitTime))
+	}
+
+	sh.mkTable(uf, []string{"Problem", "User", "Submit ID", "Date"},
+		[]aurora.Color{aurora.BoldFm, aurora.BlueFg, aurora.MagentaFg, aurora.YellowFg},
+		[][]string{prblmss, users, ids, dates})
+}
+
+// handleAdminSnapshot 处理管理员导出排行榜快照命令
+func (sh *SSHHandler) handleAdminSnapshot(s ssh.Session, uf types.Userface, freeze bool) {
+	if sh.cfg.SnapshotDir == "" {
+		uf.Println(aurora.Red("error:"), "SnapshotDir is not configured")
+		return
+	}
+	if freeze && sh.cfg.FreezeTime.IsZero() {
+		uf.Println(aurora.Red("error:"), "FreezeTime is not configured")
+		return
+	}
+
+	var users []types.User
+	var err error
+	if freeze {
+		users, err = sh.dbService.GetUsersOrderedByScoreBefore(sh.cfg.FreezeTime, sh.problems)
+	} else {
+		users, err = sh.dbService.GetAllUsersOrderedByScore()
+	}
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get user rankings")
+		return
+	}
+
+	var prblmss []string
+	for k := range sh.problems {
+		prblmss = append(prblmss, k)
+	}
+	sort.Strings(prblmss)
+
+	now := time.Now()
+	snapshot := types.StandingsSnapshot{
+		Time:     now.UnixNano(),
+		Frozen:   freeze,
+		Problems: prblmss,
+	}
+	if freeze {
+		snapshot.FreezeTime = sh.cfg.FreezeTime.UnixNano()
+	}
+
+	ranks := computeRanks(users)
+	for i, u := range users {
+		scores := make(map[string]float64)
+		for _, p := range prblmss {
+			scores[p] = u.BestScores[p] * sh.dbService.ScoreFactor()
+		}
+		snapshot.Standings = append(snapshot.Standings, types.Standing{
+			Rank:       ranks[i],
+			User:       u.ID,
+			TotalScore: u.TotalScore,
+			Scores:     scores,
+		})
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to serialize snapshot:", err.Error())
+		return
+	}
+
+	name := "standings-" + now.Format("20060102-150405")
+	if freeze {
+		name += "-frozen"
+	}
+	file := path.Join(sh.cfg.SnapshotDir, name+".json")
+
+	err = os.MkdirAll(sh.cfg.SnapshotDir, 0755)
+	if err == nil {
+		err = os.WriteFile(file, data, 0644)
+	}
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to write snapshot:", err.Error())
+		return
+	}
+
+	log.Info().Str("admin", s.User()).Str("file", file).Bool("frozen", freeze).Int("users", len(users)).Msg("wrote standings snapshot")
+
+	uf.Println(aurora.Green("Success:"), "Wrote snapshot", aurora.Yellow(file))
+	uf.Println("  Users:", aurora.Bold(len(users)))
+	uf.Println("  Frozen:", aurora.Bold(freeze))
+}
+
+// handleAdminTrends 处理管理员查看问题资源占用趋势命令
+func (sh *SSHHandler) handleAdminTrends(uf types.Userface, problemID string) {
+	trends, err := sh.dbService.GetResourceTrends(problemID)
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to get resource trends:", err.Error())
+		return
+	}
+
+	uf.Println(aurora.Green("Showing"), aurora.Bold("resource trends"), "for", aurora.Bold(problemID))
+
+	if len(trends) == 0 {
+		uf.Println(aurora.Gray(15, "No completed submissions yet"))
+		return
+	}
+
+	var days, counts, mems, times []string
+	for _, t := range trends {
+		days = append(days, t.Day)
+		counts = append(counts, strconv.FormatInt(t.Count, 10))
+		mems = append(mems, fmt.Sprintf("%.2f MiB", t.AvgMemory/(1<<20)))
+		times = append(times, time.Duration(t.AvgTime).Round(time.Microsecond).String())
+	}
+
+	sh.mkTable(uf, []string{"Day", "Submits", "Avg Memory", "Avg Time"},
+		[]aurora.Color{aurora.YellowFg, aurora.BoldFm, aurora.CyanFg, aurora.CyanFg},
+		[][]string{days, counts, mems, times})
+}
+
+// handleAdminPutProblem 处理管理员上传/替换问题命令
+func (sh *SSHHandler) handleAdminPutProblem(s ssh.Session, uf types.Userface) {
+	data, err := io.ReadAll(io.LimitReader(s, maxProblemDefinitionSize+1))
+	if err != nil {
+		uf.Println(aurora.Red("error:"), "failed to read problem definition:", err.Error())
+		return
+	}
+	if len(data) > maxProblemDefinitionSize {
+		uf.Println(aurora.Red("error:"), "problem definition is too large")
+		return
+	}
+
+	problem, problems, err := sh.problemManager.InstallProblem(sh.cfg.ProblemsDir, data)
+	if err != nil {
+		log.Warn().Err(err).Str("admin", s.User()).Msg("rejected problem definition")
+		uf.Println(aurora.Red("error:"), "problem rejected:", err.Error())
+		return
+	}
+	_, replaced := sh.problems[problem.Id]
+	sh.UpdateProblems(problems)
+
+	log.Info().Str("admin", s.User()).Str("problem", problem.Id).Int("version", problem.Version).Bool("replaced", replaced).Msg("installed problem")
+
+	if replaced {
+		uf.Println(aurora.Green("Success:"), "Replaced problem", aurora.Bold(problem.Id))
+	} else {
+		uf.Println(aurora.Green("Success:"), "Installed problem", aurora.Bold(problem.Id))
+	}
+	uf.Println("  Version:", aurora.Yellow(problem.Version))
+	uf.Println("  Weight:", aurora.Yellow(problem.Weight))
+	uf.Println("  Workflows:", aurora.Yellow(len(problem.Workflow)))
+}
+
+// relativeFlag 以相对时间显示提交时间的参数
+const relativeFlag = "--relative"
+
+// takeFlag 从参数中移除flag，返回剩余参数和flag是否出现
+func takeFlag(cmds []string, flag string) ([]string, bool) {
+	var rest []string
+	var found bool
+	for _, c := range cmds {
+		if c == flag {
+			found = true
+			continue
+		}
+		rest = append(rest, c)
+	}
+	return rest, found
+}
+
+// formatSubmitTime 格式化提交时间，relative为true时显示相对时间
+func formatSubmitTime(ns int64, relative bool) string {
+	if relative {
+		return types.FormatRelative(ns, time.Now())
+	}
+	return types.FormatUnixNano(ns)
 }
 
-// showSub 显示提交详情
-func (sh *SSHHandler) showSub(uf types.Userface, submit types.SubmitCtx) {
-	uf.Println("Submit ID:", aurora.Magenta(submit.ID))
+// listSubs 列出提交，relative为true时以相对时间显示提交时间
+func (sh *SSHHandler) listSubs(uf types.Userface, submits []types.SubmitCtx, relative bool) {
+	if len(submits) == 0 {
+		uf.Println(aurora.Gray(15, "No submissions yet"))
+		return
+	}
+
+	table := types.NewTable(
+		types.Column{Title: "#", Color: aurora.Color(0).Gray(15), Align: types.AlignRight},
+		types.Column{Title: "ID", Color: aurora.MagentaFg},
+		types.Column{Title: "User", Color: aurora.BlueFg},
+		types.Column{Title: "Problem", Color: aurora.BoldFm},
+		types.Column{Title: "Status"},
+		types.Column{Title: "Message", Color: aurora.Color(0).Gray(15)},
+		types.Column{Title: "Score", Align: types.AlignRight, Format: types.ScoreFormat()},
+		types.Column{Title: "Verdict"},
+		types.Column{Title: "Judge Message", Color: aurora.Color(0).Gray(15), MaxWidth: 20},
+		types.Column{Title: "Date", Color: aurora.YellowFg},
+	)
+
+	for _, submit := range submits {
+		table.AddRow(
+			submit.UserSeq,
+			submit.ID,
+			submit.User,
+			submit.Problem,
+			types.ColorizeStatus(submit.Status),
+			submit.Msg,
+			types.ColorizeScore(submit.JudgeResult),
+			types.ColorizeVerdict(submit.JudgeResult.Verdict),
+			submit.JudgeResult.Msg,
+			formatSubmitTime(submit.SubmitTime, relative),
+		)
+	}
+
+	table.Render(uf)
+}
+
+// showSub 显示提交详情，relative为true时在提交时间后附加相对时间
+func (sh *SSHHandler) showSub(uf types.Userface, submit types.SubmitCtx, relative bool) {
+	uf.Println("Submit ID:", aurora.Magenta(submit.ID), aurora.Gray(15, "(#"+strconv.Itoa(submit.UserSeq)+")"))
 	uf.Println("User:", aurora.Blue(submit.User))
-	uf.Println("Problem:", aurora.Bold(submit.Problem))
+	uf.Println("Problem:", aurora.Bold(submit.Problem), aurora.Gray(15, "v"+strconv.Itoa(submit.ProblemVersion)))
 	uf.Println("Status:", types.ColorizeStatus(submit.Status))
 	uf.Println("Message:", aurora.Gray(15, submit.Msg))
-	uf.Println("Submit Time:", aurora.Yellow(time.Unix(0, submit.SubmitTime).Format(time.DateTime+" MST")))
+	if relative {
+		uf.Println("Submit Time:", aurora.Yellow(types.FormatUnixNano(submit.SubmitTime)), aurora.Gray(15, "("+types.FormatRelative(submit.SubmitTime, time.Now())+")"))
+	} else {
+		uf.Println("Submit Time:", aurora.Yellow(types.FormatUnixNano(submit.SubmitTime)))
+	}
 
-	if submit.Status == "completed" {
+	if types.HasJudgeResult(submit.Status) {
 		if submit.JudgeResult.Success {
-			uf.Printf("Score %.2f %s\n", aurora.Underline(aurora.Bold(types.ColorizeScore(submit.JudgeResult))), aurora.Italic(aurora.Gray(15, "max.100 (Unweighted)")))
+			uf.Printf("Score "+types.ScoreFormat()+" %s\n", aurora.Underline(aurora.Bold(types.ColorizeScore(submit.JudgeResult))), aurora.Italic(aurora.Gray(15, "max."+types.FormatScore(sh.cfg.FullScore())+" (Unweighted)")))
 		} else {
 			uf.Println(aurora.Red("Judgement is Failed"))
 		}
+		if submit.JudgeResult.Verdict != "" {
+			uf.Println("Verdict:", aurora.Bold(types.ColorizeVerdict(submit.JudgeResult.Verdict)))
+		}
 
 		uf.Println("Judgement Message:")
 
@@ -611,6 +1965,29 @@ func (sh *SSHHandler) showSub(uf types.Userface, submit types.SubmitCtx) {
 		} else {
 			uf.Println("	", aurora.Gray(15, "No message"))
 		}
+
+		if len(submit.JudgeResult.Subtasks) > 0 {
+			uf.Println("Subtasks:")
+			for _, st := range submit.JudgeResult.Subtasks {
+				var passed int
+				for _, c := range st.Cases {
+					if c.Passed {
+						passed++
+					}
+				}
+				if st.Skipped {
+					uf.Println("	*", aurora.Yellow(st.Name), ":", aurora.Gray(15, "skipped"), "/", aurora.Gray(15, types.FormatScore(st.MaxScore)),
+						aurora.Gray(15, "(dependency failed)"))
+					continue
+				}
+				score := aurora.Red(types.FormatScore(st.Score))
+				if st.Score >= st.MaxScore {
+					score = aurora.Green(types.FormatScore(st.Score))
+				}
+				uf.Println("	*", aurora.Yellow(st.Name), ":", score, "/", aurora.Gray(15, types.FormatScore(st.MaxScore)),
+					aurora.Gray(15, "("+strconv.Itoa(passed)+"/"+strconv.Itoa(len(st.Cases))+" cases)"))
+			}
+		}
 	} else {
 		uf.Println(aurora.Italic(aurora.Underline(aurora.Bold(aurora.Gray(15, "No judgement result")))))
 	}
@@ -622,42 +1999,95 @@ func (sh *SSHHandler) showSub(uf types.Userface, submit types.SubmitCtx) {
 	uf.Println()
 }
 
-// mkTable 创建表格
-func (sh *SSHHandler) mkTable(uf types.Userface, cols []string, colc []aurora.Color, data [][]string) {
-	var ColLongest = make([]int, len(cols))
-	for i, col := range cols {
-		ColLongest[i] = len(col)
+// showWorkflowSteps 显示工作流各步骤的退出码和耗时
+func (sh *SSHHandler) showWorkflowSteps(uf types.Userface, submit types.SubmitCtx) {
+	if len(submit.WorkflowResults) == 0 {
+		return
 	}
 
-	for i := 0; i < len(data[0]); i++ {
-		for j := 0; j < len(cols); j++ {
-			ColLongest[j] = max(ColLongest[j], len(data[j][i]))
+	var workflows, steps, exitcodes, durations []string
+	for widx, w := range submit.WorkflowResults {
+		for sidx, st := range w.Steps {
+			workflows = append(workflows, strconv.Itoa(widx+1))
+			steps = append(steps, strconv.Itoa(sidx+1))
+			exitcodes = append(exitcodes, strconv.Itoa(st.ExitCode))
+			durations = append(durations, time.Duration(st.DurationNs).Round(time.Millisecond).String())
 		}
 	}
 
-	for i, col := range cols {
-		uf.Printf("%-*s ", ColLongest[i], col)
+	if len(workflows) == 0 {
+		return
 	}
+
+	uf.Println("Workflow Steps:")
+	sh.mkTable(uf, []string{"Workflow", "Step", "Exit Code", "Duration"},
+		[]aurora.Color{aurora.BoldFm, aurora.BoldFm, aurora.YellowFg, aurora.CyanFg},
+		[][]string{workflows, steps, exitcodes, durations})
 	uf.Println()
+}
 
-	// for _, row := range data {
-	// 	for i, col := range row {
-	// 		uf.Printf("%-*s ", ColLongest[i], col)
-	// 	}
-	// 	uf.Println()
-	// }
+// showStatusTimeline 显示提交的状态变化时间线及每个阶段的耗时
+func (sh *SSHHandler) showStatusTimeline(uf types.Userface, submit types.SubmitCtx) {
+	events, err := sh.dbService.GetSubmitEvents(submit.ID)
+	if err != nil || len(events) == 0 {
+		return
+	}
+
+	var times, statuses, durations []string
+	for i, ev := range events {
+		times = append(times, types.FormatUnixNano(ev.Time))
+		statuses = append(statuses, ev.To)
+		switch {
+		case i+1 < len(events):
+			durations = append(durations, time.Duration(events[i+1].Time-ev.Time).Round(time.Millisecond).String())
+		case types.IsFinalStatus(ev.To):
+			durations = append(durations, "-")
+		default:
+			durations = append(durations, time.Since(time.Unix(0, ev.Time)).Round(time.Second).String()+" (ongoing)")
+		}
+	}
+
+	uf.Println("Status Timeline:")
+	sh.mkTable(uf, []string{"Time", "Status", "Duration"},
+		[]aurora.Color{aurora.YellowFg, aurora.BoldFm, aurora.CyanFg},
+		[][]string{times, statuses, durations})
+	uf.Println()
+}
+
+// mkTable 创建表格
+func (sh *SSHHandler) mkTable(uf types.Userface, cols []string, colc []aurora.Color, data [][]string) {
+	var columns []types.Column
+	for i, col := range cols {
+		column := types.Column{Title: col, Color: colc[i]}
+		if isNumericColumn(data[i]) {
+			column.Align = types.AlignRight
+		}
+		columns = append(columns, column)
+	}
+
+	table := types.NewTable(columns...)
 	for i := 0; i < len(data[0]); i++ {
-		for j := 0; j < len(cols); j++ {
-			uf.Printf("%-*s ", ColLongest[j], aurora.Colorize(data[j][i], colc[j]))
+		row := make([]interface{}, len(cols))
+		for j := range cols {
+			row[j] = data[j][i]
 		}
-		uf.Println()
+		table.AddRow(row...)
 	}
+
+	table.Render(uf)
 }
 
-// omitStr 省略字符串
-func (sh *SSHHandler) omitStr(s string, n int) string {
-	if len(s) <= n {
-		return s
+// isNumericColumn 判断一列是否为数字列（允许以"-"表示空值），数字列右对齐
+func isNumericColumn(values []string) bool {
+	var numbers int
+	for _, v := range values {
+		if v == "-" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return false
+		}
+		numbers++
 	}
-	return s[:n-3] + "..."
+	return numbers > 0
 }
@@ -0,0 +1,204 @@
+package ui
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mrhaoxx/SOJ/types"
+)
+
+// adminSwitchCommands 从源码中解析 handleAdmin 分发的所有子命令
+func adminSwitchCommands(t *testing.T) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "ssh.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cmds []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "handleAdmin" {
+			return true
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			sw, ok := n.(*ast.SwitchStmt)
+			if !ok {
+				return true
+			}
+			// 只看 switch cmds[1]
+			idx, ok := sw.Tag.(*ast.IndexExpr)
+			if !ok {
+				return true
+			}
+			if lit, ok := idx.Index.(*ast.BasicLit); !ok || lit.Value != "1" {
+				return true
+			}
+			for _, stmt := range sw.Body.List {
+				for _, expr := range stmt.(*ast.CaseClause).List {
+					if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						cmd, _ := strconv.Unquote(lit.Value)
+						cmds = append(cmds, cmd)
+					}
+				}
+			}
+			return false
+		})
+		return false
+	})
+
+	if len(cmds) == 0 {
+		t.Fatal("no adm subcommands found in handleAdmin")
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+func TestAdminCommandsHaveCapabilities(t *testing.T) {
+	cmds := adminSwitchCommands(t)
+
+	dispatched := make(map[string]bool)
+	for _, cmd := range cmds {
+		dispatched[cmd] = true
+		if _, ok := adminCommandCapabilities[cmd]; !ok {
+			t.Errorf("adm %s is not listed in adminCommandCapabilities", cmd)
+		}
+	}
+	for cmd := range adminCommandCapabilities {
+		if !dispatched[cmd] {
+			t.Errorf("adminCommandCapabilities lists %s, which handleAdmin does not handle", cmd)
+		}
+	}
+}
+
+func TestAdminCommandRoles(t *testing.T) {
+	ds, err := types.NewDatabaseService(&types.Config{
+		SqlitePath: path.Join(t.TempDir(), "soj.db"),
+		Admins:     []string{"root"},
+		Roles: map[string]string{
+			"viewer": types.RoleViewer,
+			"grader": types.RoleGrader,
+			"super":  types.RoleSuperAdmin,
+			"bogus":  "nonexistent",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个角色可以使用的命令，未列出的命令必须被拒绝
+	viewer := []string{"list", "status", "user", "attempts", "firstblood", "fb", "trends", "capacity", "stats-global"}
+	grader := append([]string{"modify", "rejudge", "rejudge-problem", "validate"}, viewer...)
+	allowed := map[string][]string{
+		"viewer": viewer,
+		"grader": grader,
+		"super":  adminSwitchCommands(t),
+		"root":   adminSwitchCommands(t),
+		"bogus":  nil,
+		"nobody": nil,
+	}
+
+	for user, cmds := range allowed {
+		can := make(map[string]bool)
+		for _, cmd := range cmds {
+			can[cmd] = true
+		}
+		for _, cmd := range adminSwitchCommands(t) {
+			got := ds.HasCapability(user, adminCommandCapability(cmd))
+			if got != can[cmd] {
+				t.Errorf("%s: adm %s allowed = %v, want %v", user, cmd, got, can[cmd])
+			}
+		}
+	}
+
+	// 评测员可以修改成绩但不能删除提交或管理用户
+	for _, cmd := range []string{"delete", "ban", "unban", "import-users", "group"} {
+		if ds.HasCapability("grader", adminCommandCapability(cmd)) {
+			t.Errorf("grader can run adm %s", cmd)
+		}
+	}
+	if !ds.HasCapability("grader", adminCommandCapability("modify")) {
+		t.Error("grader cannot run adm modify")
+	}
+
+	// 未知的命令需要manage能力
+	if got := adminCommandCapability("no-such-command"); got != types.CapManage {
+		t.Errorf("unknown command requires %q, want %q", got, types.CapManage)
+	}
+}
+
+func TestSolutionsDenied(t *testing.T) {
+	cfg := &types.Config{
+		SqlitePath:    path.Join(t.TempDir(), "soj.db"),
+		Admins:        []string{"root"},
+		ShowSolutions: true,
+	}
+	ds, err := types.NewDatabaseService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := &SSHHandler{dbService: ds, cfg: cfg}
+
+	pb := &types.Problem{Id: "p1", Weight: 1}
+	solved := &types.SubmitCtx{ID: "s1", User: "alice", Problem: "p1", Status: "completed"}
+	solved.JudgeResult.Success = true
+	solved.JudgeResult.Score = 60
+	if err := ds.UpdateUserSubmitResult("alice", solved, pb); err != nil {
+		t.Fatal(err)
+	}
+	failed := &types.SubmitCtx{ID: "s2", User: "bob", Problem: "p1", Status: "completed"}
+	if err := ds.UpdateUserSubmitResult("bob", failed, pb); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	during := func() {
+		cfg.ContestStart = now.Add(-time.Hour)
+		cfg.ContestEnd = now.Add(time.Hour)
+	}
+	after := func() {
+		cfg.ContestStart = now.Add(-2 * time.Hour)
+		cfg.ContestEnd = now.Add(-time.Hour)
+	}
+	hidden := false
+
+	tests := []struct {
+		name    string
+		user    string
+		problem *types.Problem
+		setup   func()
+		denied  bool
+	}{
+		{"solved, no contest", "alice", pb, func() {}, false},
+		{"solved, after contest", "alice", pb, after, false},
+		{"solved, during contest", "alice", pb, during, true},
+		{"not solved", "bob", pb, func() {}, true},
+		{"not solved, after contest", "bob", pb, after, true},
+		{"never submitted", "carol", pb, func() {}, true},
+		{"disabled for problem", "alice", &types.Problem{Id: "p1", ShowSolutions: &hidden}, func() {}, true},
+		{"admin during contest", "root", pb, during, false},
+		{"admin without solving", "root", &types.Problem{Id: "p1", ShowSolutions: &hidden}, func() {}, false},
+	}
+
+	for _, tt := range tests {
+		cfg.ContestStart, cfg.ContestEnd = time.Time{}, time.Time{}
+		tt.setup()
+		reason := sh.solutionsDenied(tt.user, tt.problem, now)
+		if (reason != "") != tt.denied {
+			t.Errorf("%s: solutionsDenied(%s) = %q, want denied = %v", tt.name, tt.user, reason, tt.denied)
+		}
+	}
+
+	// 问题未单独配置时使用全局开关
+	cfg.ShowSolutions = false
+	if reason := sh.solutionsDenied("alice", pb, now); reason == "" {
+		t.Error("solutions visible with ShowSolutions disabled")
+	}
+}
@@ -0,0 +1,301 @@
+package ui
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrhaoxx/SOJ/file_transfer"
+	"github.com/mrhaoxx/SOJ/judge"
+	"github.com/mrhaoxx/SOJ/types"
+	"github.com/rs/zerolog/log"
+)
+
+// maxUploadBytes HTTP上传请求体的最大字节数
+const maxUploadBytes = 64 << 20
+
+// uploadFile 上传的一个文件及其在问题提交目录中的相对路径
+type uploadFile struct {
+	rel    string
+	header *multipart.FileHeader
+}
+
+// submitUpload 通过HTTP上传问题所需的文件并提交评测，作为SFTP上传的替代
+// 请求为multipart表单，每个文件的字段名是它在问题提交目录中的路径（与SFTP上传的路径一致），
+// 上传了文件的目录提交项会先清空原有内容；未上传的提交项沿用提交目录中已有的文件
+// 提交进入评测队列后立即返回提交ID，评测进度通过 status/:id 查询
+func (s *HTTPServer) submitUpload(c *gin.Context) {
+	user := c.GetString("user")
+	pid := c.Param("problem")
+
+	pb, ok := s.problemManager.GetProblem(pid)
+	if !ok || !s.dbService.CanAccessProblem(user, pid) {
+		respondError(c, CodeNotFound, "Problem not found")
+		return
+	}
+
+	if s.state.Paused() {
+		respondError(c, CodeUnavailable, "Submit is paused")
+		return
+	}
+	now := time.Now()
+	if pb.NotYetOpen(now) {
+		respondError(c, CodeForbidden, "Problem is not open yet")
+		return
+	}
+	if pb.Closed(now) {
+		respondError(c, CodeForbidden, "Problem is closed")
+		return
+	}
+
+	u, err := s.dbService.GetUserByID(user)
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+	if u.Banned {
+		respondError(c, CodeForbidden, "Your account is suspended")
+		return
+	}
+
+	running, err := s.dbService.HasUserRunningSubmit(user)
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+	if running {
+		respondError(c, CodeBadRequest, "You have a running submission")
+		return
+	}
+
+	remaining, err := s.dbService.SubmitCooldown(user, &pb, now)
+	if err != nil {
+		respondError(c, CodeInternal, "Database error")
+		return
+	}
+	if remaining > 0 {
+		respondError(c, CodeBadRequest, "Please wait "+types.FormatCooldown(remaining)+" before submitting this problem again")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes)
+	form, err := c.MultipartForm()
+	if err != nil {
+		respondError(c, CodeBadRequest, "Invalid multipart form")
+		return
+	}
+	defer form.RemoveAll()
+
+	maxFiles := pb.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = s.cfg.MaxSubmitFiles
+	}
+	uploads, dirs, err := planUpload(&pb, form.File, maxFiles)
+	if err != nil {
+		respondError(c, CodeBadRequest, err.Error())
+		return
+	}
+
+	userDir := path.Join(s.cfg.SubmitsDir, user)
+	if s.cfg.UserQuotaBytes > 0 {
+		// 按最坏情况计算，不扣除将被替换的文件
+		usage, err := file_transfer.DirUsage(userDir)
+		if err != nil && !os.IsNotExist(err) {
+			respondError(c, CodeInternal, "Failed to calculate storage usage")
+			return
+		}
+		for _, f := range uploads {
+			usage += f.header.Size
+		}
+		if usage > s.cfg.UserQuotaBytes {
+			respondError(c, CodeBadRequest, "Storage quota exceeded")
+			return
+		}
+	}
+
+	err = s.saveUpload(userDir, pid, uploads, dirs)
+	if err != nil {
+		log.Error().Err(err).Str("user", user).Str("problem", pid).Msg("failed to save uploaded files")
+		respondError(c, CodeInternal, "Failed to save uploaded files")
+		return
+	}
+
+	submitDir := path.Join(userDir, pid)
+	for _, sub := range pb.Submits {
+		if sub.Optional {
+			continue
+		}
+		if _, err := os.Stat(path.Join(submitDir, sub.SourcePath())); err != nil {
+			respondError(c, CodeBadRequest, "Missing file: "+sub.SourcePath())
+			return
+		}
+	}
+
+	log.Info().Str("user", user).Str("problem", pid).Int("files", len(uploads)).Msg("received files via HTTP upload")
+
+	ctx := judge.NewSubmitCtx(s.cfg, user, &pb, submitDir, "", false, io.Discard)
+	id, userSeq, status := s.evaluator.Submit(ctx, &pb)
+
+	respondOK(c, gin.H{
+		"id":       id,
+		"user_seq": userSeq,
+		"status":   status,
+	})
+}
+
+// planUpload 将表单中的文件对应到问题的提交项，校验路径、大小和文件数
+// 返回要写入的文件以及需要先清空的目录提交项
+func planUpload(pb *types.Problem, files map[string][]*multipart.FileHeader, maxFiles int) ([]uploadFile, []string, error) {
+	if len(files) == 0 {
+		return nil, nil, errors.New("No files uploaded")
+	}
+
+	var uploads []uploadFile
+	var dirs []string
+	dirSizes := make(map[string]int64)
+	var dirFiles int
+
+	for name, headers := range files {
+		if len(headers) != 1 {
+			return nil, nil, errors.New("Multiple files for " + strconv.Quote(name))
+		}
+		h := headers[0]
+
+		rel := path.Clean(name)
+		if name == "" || path.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, nil, errors.New("Invalid file path " + strconv.Quote(name))
+		}
+
+		var matched *types.Submit
+		for i := range pb.Submits {
+			sub := &pb.Submits[i]
+			if sub.IsDir && !sub.Archive {
+				if strings.HasPrefix(rel, sub.Path+"/") {
+					matched = sub
+					break
+				}
+			} else if rel == sub.SourcePath() {
+				matched = sub
+				break
+			}
+		}
+		if matched == nil {
+			return nil, nil, errors.New("Unexpected file " + strconv.Quote(rel))
+		}
+
+		if matched.IsDir && !matched.Archive {
+			if _, ok := dirSizes[matched.Path]; !ok {
+				dirs = append(dirs, matched.Path)
+			}
+			dirSizes[matched.Path] += h.Size
+			if matched.MaxFileSize > 0 && dirSizes[matched.Path] > matched.MaxFileSize {
+				return nil, nil, errors.New("Directory " + strconv.Quote(matched.Path) + " exceeds the size limit")
+			}
+			if dirFiles++; maxFiles > 0 && dirFiles > maxFiles {
+				return nil, nil, errors.New("Too many files (max " + strconv.Itoa(maxFiles) + ")")
+			}
+		} else if matched.MaxFileSize > 0 && h.Size > matched.MaxFileSize {
+			return nil, nil, errors.New("File " + strconv.Quote(rel) + " exceeds the size limit")
+		}
+
+		uploads = append(uploads, uploadFile{rel: rel, header: h})
+	}
+
+	return uploads, dirs, nil
+}
+
+// saveUpload 将上传的文件写入用户的问题提交目录，新建的目录和文件属于SubmitUid
+// 提交目录由用户通过SFTP控制，因此不跟随其中的符号链接
+func (s *HTTPServer) saveUpload(userDir string, pid string, uploads []uploadFile, dirs []string) error {
+	if err := os.MkdirAll(userDir, 0700); err != nil {
+		return err
+	}
+	os.Chown(userDir, s.cfg.SubmitUid, s.cfg.SubmitGid)
+
+	for _, dir := range dirs {
+		if err := s.mkdirNoFollow(userDir, path.Join(pid, path.Dir(dir))); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path.Join(userDir, pid, dir)); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range uploads {
+		rel := path.Join(pid, f.rel)
+		if err := s.mkdirNoFollow(userDir, path.Dir(rel)); err != nil {
+			return err
+		}
+		if err := s.writeUploadFile(path.Join(userDir, rel), f.header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirNoFollow 在base下逐级创建rel目录，已存在的路径必须是目录而不是符号链接
+func (s *HTTPServer) mkdirNoFollow(base string, rel string) error {
+	cur := base
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		cur = path.Join(cur, part)
+
+		info, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(cur, 0700); err != nil {
+				return err
+			}
+			os.Chown(cur, s.cfg.SubmitUid, s.cfg.SubmitGid)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errors.New(cur + " is not a directory")
+		}
+	}
+	return nil
+}
+
+// writeUploadFile 替换dst为上传的文件内容，dst已有的文件或符号链接先被删除
+func (s *HTTPServer) writeUploadFile(dst string, h *multipart.FileHeader) error {
+	if info, err := os.Lstat(dst); err == nil {
+		if info.IsDir() {
+			return errors.New(dst + " is a directory")
+		}
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+
+	src, err := h.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// O_EXCL保证不会通过刚出现的符号链接写入
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	os.Chown(dst, s.cfg.SubmitUid, s.cfg.SubmitGid)
+	return nil
+}
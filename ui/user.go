@@ -18,7 +18,8 @@ func NewUserManager(dbService *types.DatabaseService) *UserManager {
 
 // DoFULLUserScan 全量用户扫描
 func (um *UserManager) DoFULLUserScan(problems map[string]types.Problem) error {
-	return um.dbService.DoFullUserScan(problems)
+	_, err := um.dbService.DoFullUserScan(problems)
+	return err
 }
 
 // UserUpdate 更新用户信息